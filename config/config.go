@@ -71,6 +71,15 @@ func NewConfigFromText(text string) (ConfigInterface, error) {
 	return c, err
 }
 
+// NewConfigFromReader create an empty configuration representation from the text read from r.
+func NewConfigFromReader(r io.Reader) (ConfigInterface, error) {
+	c := &Config{
+		data: make(map[string]map[string]string),
+	}
+	err := c.parseBuffer(bufio.NewReader(r))
+	return c, err
+}
+
 // AddConfig adds a new section->key:value to the configuration.
 func (c *Config) AddConfig(section string, option string, value string) bool {
 	if section == "" {
@@ -0,0 +1,59 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package effector
+
+import (
+	"errors"
+
+	"github.com/casbin/casbin/v2/constant"
+)
+
+// DenyOverrideEffector is an effector dedicated to the effects where a deny wins over an allow whatever
+// their order in the policy: "some(where (p_eft == allow)) && !some(where (p_eft == deny))" and
+// "!some(where (p_eft == deny))".
+// The first matched rule with a deny effect decides, and the later rules are not evaluated.
+// When no deny matched, the request is allowed if a rule with an allow effect matched, or unconditionally
+// for "!some(where (p_eft == deny))", and denied otherwise.
+type DenyOverrideEffector struct {
+}
+
+// NewDenyOverrideEffector is the constructor for DenyOverrideEffector.
+func NewDenyOverrideEffector() *DenyOverrideEffector {
+	return &DenyOverrideEffector{}
+}
+
+// MergeEffects merges all matching results collected by the enforcer into a single decision.
+func (e *DenyOverrideEffector) MergeEffects(expr string, effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int, error) {
+	if expr != constant.AllowAndDenyEffect && expr != constant.DenyOverrideEffect {
+		return Deny, -1, errors.New("unsupported effect")
+	}
+
+	if matches[policyIndex] != 0 && effects[policyIndex] == Deny {
+		return Deny, policyIndex, nil
+	}
+	if policyIndex < policyLength-1 {
+		return Indeterminate, -1, nil
+	}
+	if expr == constant.DenyOverrideEffect {
+		return Allow, -1, nil
+	}
+	// no deny rule matched, the first matched allow rule decides.
+	for i, eft := range effects {
+		if matches[i] != 0 && eft == Allow {
+			return Allow, i, nil
+		}
+	}
+	return Deny, -1, nil
+}
@@ -27,5 +27,7 @@ const (
 // Effector is the interface for Casbin effectors.
 type Effector interface {
 	// MergeEffects merges all matching results collected by the enforcer into a single decision.
+	// matches holds the result of the matcher for each rule: 1 or 0 for a boolean matcher,
+	// and the raw result for a numeric matcher, a rule matching when its result is not 0.
 	MergeEffects(expr string, effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int, error)
 }
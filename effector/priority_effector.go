@@ -0,0 +1,49 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package effector
+
+import (
+	"errors"
+
+	"github.com/casbin/casbin/v2/constant"
+)
+
+// PriorityEffector is an effector dedicated to the "priority(p_eft) || deny" effect.
+// The rules are evaluated in priority order (see Model.SortPoliciesByPriority), so the first matched
+// rule with an allow or deny effect decides, and the later rules are not evaluated.
+// Rules sharing the same priority keep their order in the policy, so the first of them wins.
+// The request is denied if no rule matches.
+type PriorityEffector struct {
+}
+
+// NewPriorityEffector is the constructor for PriorityEffector.
+func NewPriorityEffector() *PriorityEffector {
+	return &PriorityEffector{}
+}
+
+// MergeEffects merges all matching results collected by the enforcer into a single decision.
+func (e *PriorityEffector) MergeEffects(expr string, effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int, error) {
+	if expr != constant.PriorityEffect {
+		return Deny, -1, errors.New("unsupported effect")
+	}
+
+	if matches[policyIndex] != 0 && effects[policyIndex] != Indeterminate {
+		return effects[policyIndex], policyIndex, nil
+	}
+	if policyIndex == policyLength-1 {
+		return Deny, -1, nil
+	}
+	return Indeterminate, -1, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package effector
+
+// WeightedEffector is an effector for the matchers scoring the rules, like
+// "r.sub == p.sub && r.obj == p.obj ? weight(p.weight) : 0", instead of only matching them.
+// The scores of the matched rules with an allow effect are summed, and the request is allowed
+// when the sum reaches the threshold. The rule reaching it is the one explaining the decision.
+// The request is denied otherwise. The effect expression of the model is not used.
+type WeightedEffector struct {
+	threshold float64
+}
+
+// NewWeightedEffector is the constructor for WeightedEffector.
+func NewWeightedEffector(threshold float64) *WeightedEffector {
+	return &WeightedEffector{threshold: threshold}
+}
+
+// MergeEffects merges all matching results collected by the enforcer into a single decision.
+func (e *WeightedEffector) MergeEffects(expr string, effects []Effect, matches []float64, policyIndex int, policyLength int) (Effect, int, error) {
+	// the scores are summed once all the rules are evaluated.
+	if policyIndex < policyLength-1 {
+		return Indeterminate, -1, nil
+	}
+
+	sum := 0.0
+	for i, eft := range effects {
+		if matches[i] == 0 || eft != Allow {
+			continue
+		}
+		sum += matches[i]
+		if sum >= e.threshold {
+			return Allow, i, nil
+		}
+	}
+	return Deny, -1, nil
+}
@@ -15,14 +15,22 @@
 package casbin
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/casbin/casbin/v2/constant"
 	"github.com/casbin/casbin/v2/effector"
+	Err "github.com/casbin/casbin/v2/errors"
 	"github.com/casbin/casbin/v2/log"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
@@ -37,6 +45,11 @@ import (
 
 // Enforcer is the main interface for authorization enforcement and policy management.
 type Enforcer struct {
+	// the matcher cache counters are accessed atomically and kept first for the 64-bit alignment.
+	matcherCacheHits      int64
+	matcherCacheMisses    int64
+	matcherCacheEvictions int64
+
 	modelPath string
 	model     model.Model
 	fm        model.FunctionMap
@@ -47,6 +60,12 @@ type Enforcer struct {
 	dispatcher persist.Dispatcher
 	rmMap      map[string]rbac.RoleManager
 	matcherMap sync.Map
+	// matcherCache holds the compiled matchers which are not defined in the model, like the ones given to
+	// EnforceWithMatcher, when their number is bounded by SetMatcherCacheSize. They are kept in matcherMap otherwise.
+	matcherCache     *util.SyncLRUCache
+	matcherCacheSize int
+	// evalMatcherMap caches the compiled sub-rules of eval(), it is invalidated together with matcherMap.
+	evalMatcherMap sync.Map
 
 	enabled              bool
 	autoSave             bool
@@ -54,6 +73,49 @@ type Enforcer struct {
 	autoNotifyWatcher    bool
 	autoNotifyDispatcher bool
 	acceptJsonRequest    bool
+	trimPolicyFields     bool
+	defaultDomain        string
+	domainContextKey     interface{}
+	breakGlassToken      string
+	noPanicRecovery      bool
+	explainStrategy      ExplainStrategy
+	lenientMatching      bool
+	caseInsensitive      bool
+	compiler             ExpressionCompiler
+	// journal records the management operations for Undo and Redo, it is nil unless enabled by EnableOperationJournal.
+	journal *operationJournal
+
+	optionalRequestTokens map[string]int
+
+	fallbackDecider func(rvals []interface{}) (bool, error)
+	enforceHook     EnforceHook
+	matcherSelector func(rvals []interface{}) string
+	// preservedGFunctions records the grouping policy types whose function added by AddFunction
+	// must not be shadowed by the generated g-function.
+	preservedGFunctions  map[string]bool
+	contextMatchingFuncs map[string]rbac.ContextMatchingFunc
+	contextFunctions     map[string]ContextFunction
+	// domainMatchingFuncs records the domain matching functions declared by SetDomainMatchingFunc
+	// per grouping policy type, nil for the exact matching of the domains.
+	domainMatchingFuncs map[string]rbac.MatchingFunc
+	// guestRoles records the roles set by SetGuestRole per grouping policy type.
+	guestRoles map[string]string
+	// matchingFuncs and namedDomainMatchingFuncs record the last functions added by AddNamedMatchingFunc and
+	// AddNamedDomainMatchingFunc per grouping policy type, so that Clone adds them to its role managers.
+	matchingFuncs            map[string]rbac.MatchingFunc
+	namedDomainMatchingFuncs map[string]rbac.MatchingFunc
+	// customRoleManagers records the grouping policy types whose role manager was set by SetNamedRoleManager.
+	customRoleManagers map[string]bool
+
+	lazyRoleLinks bool
+	// dirtyRoleLinks records the grouping policy types whose role links need to be rebuilt before use,
+	// it is guarded by roleLinksLock.
+	dirtyRoleLinks map[string]bool
+	roleLinksLock  sync.Mutex
+
+	// modelLock is taken for reading by the enforcement, and for writing when the model or the policy
+	// is replaced as a whole, or when the role links are rebuilt.
+	modelLock sync.RWMutex
 
 	logger log.Logger
 }
@@ -70,6 +132,40 @@ func (e EnforceContext) GetCacheKey() string {
 	return "EnforceContext{" + e.RType + "-" + e.PType + "-" + e.EType + "-" + e.MType + "}"
 }
 
+// Attributer is implemented by the request values whose attributes are accessed in the matchers like r.sub.department,
+// so that the attributes are resolved by GetAttribute instead of reflection or JSON.
+type Attributer interface {
+	GetAttribute(name string) (interface{}, error)
+}
+
+// EnforceReason describes why EnforceWithReason made its decision.
+type EnforceReason int
+
+const (
+	// ReasonEvaluated means the decision is made by evaluating the policy against the model,
+	// and that the request is allowed, the denials being told apart by ReasonNoMatchingRule and ReasonDeniedByRule.
+	ReasonEvaluated EnforceReason = iota
+	// ReasonEnforceDisabled means the enforcement is disabled by EnableEnforce(false), so the request is allowed without evaluation.
+	ReasonEnforceDisabled
+	// ReasonBreakGlass means the request is denied by the policy, but allowed by its break-glass token set by SetBreakGlassToken.
+	ReasonBreakGlass
+	// ReasonNoMatchingRule means the request is denied because no policy rule matches it.
+	ReasonNoMatchingRule
+	// ReasonDeniedByRule means the request is denied although policy rules match it, like a rule with a deny effect.
+	ReasonDeniedByRule
+)
+
+// ExplainStrategy selects the rule explained by EnforceEx when several rules match the request.
+type ExplainStrategy int
+
+const (
+	// ExplainDecidingRule explains the rule with which the effector made its decision, which depends on the effect.
+	ExplainDecidingRule ExplainStrategy = iota
+	// ExplainFirstMatchingRule explains the matching rule with the lowest index among the ones
+	// having the effect of the decision, whatever the effect.
+	ExplainFirstMatchingRule
+)
+
 // NewEnforcer creates an enforcer via file or DB.
 //
 // File:
@@ -80,6 +176,11 @@ func (e EnforceContext) GetCacheKey() string {
 //
 //	a := mysqladapter.NewDBAdapter("mysql", "mysql_username:mysql_password@tcp(127.0.0.1:3306)/")
 //	e := casbin.NewEnforcer("path/to/basic_model.conf", a)
+//
+// Embedded model:
+//
+//	f, _ := modelFS.Open("basic_model.conf")
+//	e := casbin.NewEnforcer(f, a)
 func NewEnforcer(params ...interface{}) (*Enforcer, error) {
 	e := &Enforcer{logger: &log.DefaultLogger{}}
 
@@ -101,6 +202,17 @@ func NewEnforcer(params ...interface{}) (*Enforcer, error) {
 		}
 	}
 
+	// a model read from an io.Reader, like an embedded file, is used as a model.Model.
+	if paramLen-parsedParamLen >= 1 {
+		if r, ok := params[0].(io.Reader); ok {
+			m, err := model.NewModelFromReader(r)
+			if err != nil {
+				return nil, err
+			}
+			params = append([]interface{}{m}, params[1:]...)
+		}
+	}
+
 	if paramLen-parsedParamLen == 2 {
 		switch p0 := params[0].(type) {
 		case string:
@@ -207,7 +319,7 @@ func (e *Enforcer) initialize() {
 	e.rmMap = map[string]rbac.RoleManager{}
 	e.eft = effector.NewDefaultEffector()
 	e.watcher = nil
-	e.matcherMap = sync.Map{}
+	e.invalidateMatcherMap()
 
 	e.enabled = true
 	e.autoSave = true
@@ -240,15 +352,240 @@ func (e *Enforcer) GetModel() model.Model {
 	return e.model
 }
 
+// GetModelText renders the current model into the text of a model file, for diagnostics. The text is followed by
+// comments listing the tokens parsed from the request and policy definitions and the functions available to
+// the matchers, including the ones registered by AddFunction, so the text can still be loaded as a model.
+func (e *Enforcer) GetModelText() string {
+	e.modelLock.RLock()
+	defer e.modelLock.RUnlock()
+
+	s := strings.Builder{}
+	s.WriteString(e.model.ToText())
+	s.WriteString("# tokens\n")
+	for _, sec := range []string{"r", "p"} {
+		keys := make([]string, 0, len(e.model[sec]))
+		for key := range e.model[sec] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s.WriteString(fmt.Sprintf("# %s = %s\n", key, strings.Join(e.model[sec][key].Tokens, ", ")))
+		}
+	}
+	functions := make([]string, 0)
+	for name := range e.fm.GetFunctions() {
+		functions = append(functions, name)
+	}
+	sort.Strings(functions)
+	s.WriteString(fmt.Sprintf("# functions = %s\n", strings.Join(functions, ", ")))
+	return s.String()
+}
+
 // SetModel sets the current model.
 func (e *Enforcer) SetModel(m model.Model) {
+	m.SetLogger(e.logger)
+
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
 	e.model = m
 	e.fm = model.LoadFunctionMap()
-
-	e.model.SetLogger(e.logger)
 	e.initialize()
 }
 
+// SetModelWithValidation sets the current model like SetModel, but first checks that the policy rules
+// already loaded fit the policy definitions of m. If they do not, the error is returned and the current model is kept.
+func (e *Enforcer) SetModelWithValidation(m model.Model) error {
+	if err := m.CheckPolicyCompatibility(e.model); err != nil {
+		return err
+	}
+	e.SetModel(m)
+	return nil
+}
+
+var matcherTokenRegex = regexp.MustCompile(`\b([rp][0-9]*)_([A-Za-z_0-9]+)`)
+var matcherStringRegex = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+
+// Validate checks the model and the policy statically, so that a misconfiguration is reported at startup
+// rather than by the first Enforce call: every request and policy token used in the matchers must be defined
+// (unless SetLenientMatching is enabled), the policy effects must be supported by the default effector when it
+// is used, and the policy rules must have as many fields as their policy definition.
+func (e *Enforcer) Validate() error {
+	e.modelLock.RLock()
+	defer e.modelLock.RUnlock()
+
+	if !e.lenientMatching {
+		for mType, ast := range e.model["m"] {
+			if err := e.checkMatcherTokens(mType, ast.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, ok := e.eft.(*effector.DefaultEffector); ok {
+		for eType, ast := range e.model["e"] {
+			switch ast.Value {
+			case constant.AllowOverrideEffect, constant.DenyOverrideEffect, constant.AllowAndDenyEffect,
+				constant.PriorityEffect, constant.SubjectPriorityEffect:
+			default:
+				return fmt.Errorf("the policy effect %s = %s is not supported", eType, ast.Value)
+			}
+		}
+	}
+
+	return e.model.CheckPolicyCompatibility(e.model)
+}
+
+// checkMatcherTokens checks that every request and policy token used by the matcher mType is defined in the model.
+func (e *Enforcer) checkMatcherTokens(mType string, matcher string) error {
+	matcher = matcherStringRegex.ReplaceAllString(matcher, "")
+	for _, match := range matcherTokenRegex.FindAllStringSubmatch(matcher, -1) {
+		token, ptype, field := match[0], match[1], match[2]
+		def, ok := e.model[ptype[:1]][ptype]
+		if !ok {
+			return fmt.Errorf("matcher %s: %s is not defined in the model", mType, ptype)
+		}
+		if ptype[:1] == "p" && (field == "eft" || field == "index" || field == "count") {
+			continue
+		}
+		defined := false
+		for _, defToken := range def.Tokens {
+			defined = defined || defToken == token
+		}
+		if !defined {
+			return fmt.Errorf("matcher %s: %s is not a token of %s = %s", mType, strings.Replace(token, "_", ".", 1), ptype, def.Value)
+		}
+	}
+	return nil
+}
+
+// UpdateMatcher replaces the matcher mType of the model by expr, like "r.sub == p.sub && r.obj == p.obj",
+// while the policy and the role links in memory are kept, unlike LoadModel which requires to load the policy again.
+// The new matcher must only use the tokens defined in the model (unless SetLenientMatching is enabled) and compile,
+// otherwise an error is returned and the matcher is left unchanged. The compiled matchers are invalidated.
+func (e *Enforcer) UpdateMatcher(mType string, expr string) error {
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
+
+	old, ok := e.model["m"][mType]
+	if !ok {
+		return fmt.Errorf("matcher %s is not defined in the model", mType)
+	}
+	if !e.model.AddDef("m", mType, expr) {
+		return fmt.Errorf("matcher %s cannot be empty", mType)
+	}
+	if err := e.checkMatcher(mType); err != nil {
+		e.model["m"][mType] = old
+		return err
+	}
+
+	e.invalidateMatcherMap()
+	return nil
+}
+
+// checkMatcher checks the tokens of the matcher mType, and that it compiles with the functions of the enforcer.
+func (e *Enforcer) checkMatcher(mType string) error {
+	matcher := e.model["m"][mType].Value
+	if !e.lenientMatching {
+		if err := e.checkMatcherTokens(mType, matcher); err != nil {
+			return err
+		}
+	}
+
+	functions := e.fm.GetFunctions()
+	e.addGFunctions(functions, nil)
+	e.addContextFunctions(functions, nil, nil)
+	functions["eval"] = e.generateEvalFunction(functions, &enforceParameters{}, nil)
+	if _, err := e.compile(matcher, functions); err != nil {
+		return fmt.Errorf("matcher %s: %w", mType, err)
+	}
+	return nil
+}
+
+// Clone returns an independent copy of the enforcer for what-if analyses: the model and the policy are
+// deep-copied and the role links are rebuilt in fresh role managers, so that changes to the clone never
+// affect the enforcer. The adapter is shared, but the clone does not save its policy changes automatically.
+//
+// The clone keeps the functions added by AddFunction and AddFunctionWithContext, the matching functions
+// added by AddNamedMatchingFunc, AddNamedDomainMatchingFunc, AddNamedContextMatchingFunc and SetDomainMatchingFunc,
+// the guest roles, and the enforcement settings like the effector, the enforce hook, the fallback decider
+// or the matcher cache size. The watcher, the dispatcher and the operation journal are not carried over,
+// nor the matching functions added directly on a role manager. An error is returned if a role manager
+// was set by SetRoleManager or SetNamedRoleManager, as it can neither be copied nor shared by the clone.
+func (e *Enforcer) Clone() (*Enforcer, error) {
+	e.modelLock.RLock()
+	defer e.modelLock.RUnlock()
+
+	if len(e.customRoleManagers) != 0 {
+		return nil, errors.New("the role managers set by SetRoleManager or SetNamedRoleManager cannot be cloned")
+	}
+
+	c := &Enforcer{logger: e.logger}
+	c.modelPath = e.modelPath
+	c.model = e.model.Copy()
+	c.fm = model.LoadFunctionMap()
+	for name, function := range e.fm.GetFunctions() {
+		c.fm.AddFunction(name, function)
+	}
+	c.adapter = e.adapter
+
+	c.initialize()
+	c.eft = e.eft
+	c.enabled = e.enabled
+	c.autoSave = false
+	c.autoBuildRoleLinks = e.autoBuildRoleLinks
+	c.acceptJsonRequest = e.acceptJsonRequest
+	c.trimPolicyFields = e.trimPolicyFields
+	c.defaultDomain = e.defaultDomain
+	c.domainContextKey = e.domainContextKey
+	c.breakGlassToken = e.breakGlassToken
+	c.fallbackDecider = e.fallbackDecider
+	c.enforceHook = e.enforceHook
+	c.matcherSelector = e.matcherSelector
+	c.lazyRoleLinks = e.lazyRoleLinks
+	c.noPanicRecovery = e.noPanicRecovery
+	c.explainStrategy = e.explainStrategy
+	c.lenientMatching = e.lenientMatching
+	c.caseInsensitive = e.caseInsensitive
+	c.compiler = e.compiler
+	c.SetMatcherCacheSize(e.matcherCacheSize)
+
+	if e.optionalRequestTokens != nil {
+		c.optionalRequestTokens = make(map[string]int, len(e.optionalRequestTokens))
+		for rtype, n := range e.optionalRequestTokens {
+			c.optionalRequestTokens[rtype] = n
+		}
+	}
+	if e.preservedGFunctions != nil {
+		c.preservedGFunctions = make(map[string]bool, len(e.preservedGFunctions))
+		for ptype, preserve := range e.preservedGFunctions {
+			c.preservedGFunctions[ptype] = preserve
+		}
+	}
+	for ptype, fn := range e.contextMatchingFuncs {
+		c.AddNamedContextMatchingFunc(ptype, "", fn)
+	}
+	for ptype, fn := range e.domainMatchingFuncs {
+		c.SetDomainMatchingFunc(ptype, fn)
+	}
+	for ptype, fn := range e.namedDomainMatchingFuncs {
+		c.AddNamedDomainMatchingFunc(ptype, "g", fn)
+	}
+	for ptype, fn := range e.matchingFuncs {
+		c.AddNamedMatchingFunc(ptype, "g", fn)
+	}
+	for ptype, role := range e.guestRoles {
+		c.SetGuestRole(ptype, role)
+	}
+	for name, fn := range e.contextFunctions {
+		c.AddFunctionWithContext(name, fn)
+	}
+
+	if err := c.BuildRoleLinks(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // GetAdapter gets the current adapter.
 func (e *Enforcer) GetAdapter() persist.Adapter {
 	return e.adapter
@@ -283,14 +620,17 @@ func (e *Enforcer) GetNamedRoleManager(ptype string) rbac.RoleManager {
 
 // SetRoleManager sets the current role manager.
 func (e *Enforcer) SetRoleManager(rm rbac.RoleManager) {
-	e.invalidateMatcherMap()
-	e.rmMap["g"] = rm
+	e.SetNamedRoleManager("g", rm)
 }
 
 // SetNamedRoleManager sets the role manager for the named policy.
 func (e *Enforcer) SetNamedRoleManager(ptype string, rm rbac.RoleManager) {
 	e.invalidateMatcherMap()
 	e.rmMap[ptype] = rm
+	if e.customRoleManagers == nil {
+		e.customRoleManagers = make(map[string]bool)
+	}
+	e.customRoleManagers[ptype] = true
 }
 
 // SetEffector sets the current effector.
@@ -300,7 +640,10 @@ func (e *Enforcer) SetEffector(eft effector.Effector) {
 
 // ClearPolicy clears all policy.
 func (e *Enforcer) ClearPolicy() {
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
 	e.invalidateMatcherMap()
+	e.clearOperationJournal()
 
 	if e.dispatcher != nil && e.autoNotifyDispatcher {
 		_ = e.dispatcher.ClearPolicy()
@@ -310,44 +653,50 @@ func (e *Enforcer) ClearPolicy() {
 }
 
 // LoadPolicy reloads the policy from file/database.
+// The new policy is loaded aside, and only swapped in with the role links rebuilt under the write lock,
+// so that the concurrent enforcements see either the old or the new policy.
 func (e *Enforcer) LoadPolicy() error {
-	e.invalidateMatcherMap()
-
-	needToRebuild := false
 	newModel := e.model.Copy()
 	newModel.ClearPolicy()
 
-	var err error
-	defer func() {
-		if err != nil {
-			if e.autoBuildRoleLinks && needToRebuild {
-				_ = e.BuildRoleLinks()
-			}
-		}
-	}()
-
-	if err = e.adapter.LoadPolicy(newModel); err != nil && err.Error() != "invalid file path, file path cannot be empty" {
+	if err := e.adapter.LoadPolicy(newModel); err != nil && err.Error() != "invalid file path, file path cannot be empty" {
 		return err
 	}
 
-	if err = newModel.SortPoliciesBySubjectHierarchy(); err != nil {
+	if e.trimPolicyFields {
+		newModel.TrimPolicyFields()
+	}
+
+	if err := newModel.SortPoliciesBySubjectHierarchy(); err != nil {
 		return err
 	}
 
-	if err = newModel.SortPoliciesByPriority(); err != nil {
+	if err := newModel.SortPoliciesByPriority(); err != nil {
 		return err
 	}
 
+	return e.swapLoadedModel(newModel)
+}
+
+// swapLoadedModel replaces the current model by a model whose policy was just loaded, and rebuilds the role links
+// from it, or marks them to be rebuilt when the role links are built lazily.
+func (e *Enforcer) swapLoadedModel(newModel model.Model) error {
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
+	e.invalidateMatcherMap()
+	e.clearOperationJournal()
+
 	if e.autoBuildRoleLinks {
-		needToRebuild = true
 		for _, rm := range e.rmMap {
-			err := rm.Clear()
-			if err != nil {
+			if err := rm.Clear(); err != nil {
+				_ = e.buildRoleLinks()
 				return err
 			}
 		}
-		err = newModel.BuildRoleLinks(e.rmMap)
-		if err != nil {
+		if e.lazyRoleLinks {
+			e.markRoleLinksDirty(newModel)
+		} else if err := newModel.BuildRoleLinks(e.rmMap); err != nil {
+			_ = e.buildRoleLinks()
 			return err
 		}
 	}
@@ -355,8 +704,76 @@ func (e *Enforcer) LoadPolicy() error {
 	return nil
 }
 
+// LoadPolicyStream reloads the policy from an adapter implementing persist.StreamingAdapter, appending the rules
+// to a cleared copy of the model as they arrive, through a buffer of 1024 rules in flight, instead of having the
+// adapter fill the model in a single call like LoadPolicy does.
+// The enforcement goes on with the current policy while the policy is loading, and the loaded policy replaces it
+// only if loading succeeds; the current policy is kept untouched if loading fails.
+func (e *Enforcer) LoadPolicyStream() error {
+	streamingAdapter, ok := e.adapter.(persist.StreamingAdapter)
+	if !ok {
+		return errors.New("streaming policies are not supported by this adapter")
+	}
+
+	newModel := e.model.Copy()
+	newModel.ClearPolicy()
+
+	rules := make(chan []string, policyStreamBufferSize)
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- streamingAdapter.LoadPolicyStream(rules)
+		close(rules)
+	}()
+
+	var err error
+	for rule := range rules {
+		// the remaining rules are drained so that the adapter is not blocked
+		if err == nil {
+			err = e.loadStreamedRule(newModel, rule)
+		}
+	}
+	if adapterErr := <-streamErr; adapterErr != nil {
+		err = adapterErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := newModel.SortPoliciesBySubjectHierarchy(); err != nil {
+		return err
+	}
+
+	if err := newModel.SortPoliciesByPriority(); err != nil {
+		return err
+	}
+
+	return e.swapLoadedModel(newModel)
+}
+
+// policyStreamBufferSize is the number of rules buffered between a streaming adapter and LoadPolicyStream.
+const policyStreamBufferSize = 1024
+
+// loadStreamedRule adds a rule sent by a streaming adapter to the model being loaded.
+func (e *Enforcer) loadStreamedRule(m model.Model, rule []string) error {
+	if len(rule) < 2 || rule[0] == "" {
+		return fmt.Errorf("invalid policy rule: %v", rule)
+	}
+	sec, ptype := rule[0][:1], rule[0]
+	if _, ok := m[sec][ptype]; !ok || (sec != "p" && sec != "g") {
+		return fmt.Errorf("invalid policy type: %s", ptype)
+	}
+
+	fields := e.trimRule(rule[1:])
+	if m.HasPolicy(sec, ptype, fields) {
+		return nil
+	}
+	m.AddPolicy(sec, ptype, fields)
+	return nil
+}
+
 func (e *Enforcer) loadFilteredPolicy(filter interface{}) error {
 	e.invalidateMatcherMap()
+	e.clearOperationJournal()
 
 	var filteredAdapter persist.FilteredAdapter
 
@@ -371,6 +788,10 @@ func (e *Enforcer) loadFilteredPolicy(filter interface{}) error {
 		return err
 	}
 
+	if e.trimPolicyFields {
+		e.model.TrimPolicyFields()
+	}
+
 	if err := e.model.SortPoliciesBySubjectHierarchy(); err != nil {
 		return err
 	}
@@ -381,7 +802,9 @@ func (e *Enforcer) loadFilteredPolicy(filter interface{}) error {
 
 	e.initRmMap()
 	e.model.PrintPolicy()
-	if e.autoBuildRoleLinks {
+	if e.autoBuildRoleLinks && e.lazyRoleLinks {
+		e.markRoleLinksDirty(e.model)
+	} else if e.autoBuildRoleLinks {
 		err := e.BuildRoleLinks()
 		if err != nil {
 			return err
@@ -414,21 +837,36 @@ func (e *Enforcer) IsFiltered() bool {
 // SavePolicy saves the current policy (usually after changed with Casbin API) back to file/database.
 func (e *Enforcer) SavePolicy() error {
 	if e.IsFiltered() {
-		return errors.New("cannot save a filtered policy")
+		return Err.ErrCannotSaveFilteredPolicy
 	}
 	if err := e.adapter.SavePolicy(e.model); err != nil {
 		return err
 	}
-	if e.watcher != nil {
-		var err error
-		if watcher, ok := e.watcher.(persist.WatcherEx); ok {
-			err = watcher.UpdateForSavePolicy(e.model)
-		} else {
-			err = e.watcher.Update()
-		}
+	return e.notifySavePolicy()
+}
+
+// SaveFilteredPolicy saves the current policy, loaded with LoadFilteredPolicy, back to an adapter
+// implementing persist.FilteredSaveAdapter. The stored rules matching the filter are replaced by the current policy,
+// while the stored rules outside of the filter are kept, so the filter should be the one the policy was loaded with.
+func (e *Enforcer) SaveFilteredPolicy(filter interface{}) error {
+	filteredSaveAdapter, ok := e.adapter.(persist.FilteredSaveAdapter)
+	if !ok {
+		return errors.New("saving filtered policies is not supported by this adapter")
+	}
+	if err := filteredSaveAdapter.SaveFilteredPolicy(e.model, filter); err != nil {
 		return err
 	}
-	return nil
+	return e.notifySavePolicy()
+}
+
+func (e *Enforcer) notifySavePolicy() error {
+	if e.watcher == nil {
+		return nil
+	}
+	if watcher, ok := e.watcher.(persist.WatcherEx); ok {
+		return watcher.UpdateForSavePolicy(e.model)
+	}
+	return e.watcher.Update()
 }
 
 func (e *Enforcer) initRmMap() {
@@ -437,6 +875,11 @@ func (e *Enforcer) initRmMap() {
 			_ = rm.Clear()
 		} else {
 			e.rmMap[ptype] = defaultrolemanager.NewRoleManager(10)
+			if fn, ok := e.domainMatchingFuncs[ptype]; ok {
+				e.AddNamedDomainMatchingFunc(ptype, "g", fn)
+				continue
+			}
+			// without a declared domain matching function, the domain patterns are detected from the matcher.
 			matchFun := "keyMatch(r_dom, p_dom)"
 			if strings.Contains(e.model["m"]["m"].Value, matchFun) {
 				e.AddNamedDomainMatchingFunc(ptype, "g", util.KeyMatch)
@@ -450,6 +893,19 @@ func (e *Enforcer) EnableEnforce(enable bool) {
 	e.enabled = enable
 }
 
+// EnableEnforcePanicRecovery controls whether a panic during the enforcement, like in a function added by AddFunction,
+// is recovered and returned as an error with its stack. It is enabled by default, disabling it lets the panic propagate
+// for debugging.
+func (e *Enforcer) EnableEnforcePanicRecovery(enable bool) {
+	e.noPanicRecovery = !enable
+}
+
+// SetExplainStrategy sets how the rule explained by EnforceEx is selected when several rules match the request,
+// ExplainDecidingRule by default.
+func (e *Enforcer) SetExplainStrategy(strategy ExplainStrategy) {
+	e.explainStrategy = strategy
+}
+
 // EnableLog changes whether Casbin will log messages to the Logger.
 func (e *Enforcer) EnableLog(enable bool) {
 	e.logger.EnableLog(enable)
@@ -480,13 +936,198 @@ func (e *Enforcer) EnableAutoBuildRoleLinks(autoBuildRoleLinks bool) {
 	e.autoBuildRoleLinks = autoBuildRoleLinks
 }
 
-// EnableAcceptJsonRequest controls whether to accept json as a request parameter
+// EnableAcceptJsonRequest controls whether to accept json as a request parameter,
+// the fields of json policy values can then also be accessed in the matcher, like p.constraint.minAge.
 func (e *Enforcer) EnableAcceptJsonRequest(acceptJsonRequest bool) {
 	e.acceptJsonRequest = acceptJsonRequest
 }
 
+// EnableTrimPolicyFields controls whether to trim the surrounding whitespace of the policy fields
+// when the policy is loaded or added. The rules given to remove, update or look up policies are trimmed
+// the same way, so that they find the trimmed rules. It is disabled by default, since a field may contain
+// spaces on purpose.
+func (e *Enforcer) EnableTrimPolicyFields(trimPolicyFields bool) {
+	e.trimPolicyFields = trimPolicyFields
+}
+
+// SetLenientMatching controls whether a request or policy token referenced by a matcher but missing from
+// the request and policy definitions, like r.tenant in a model without it, resolves to "" instead of failing
+// the enforcement. It eases model migrations where the matchers are upgraded first. It is disabled by default.
+func (e *Enforcer) SetLenientMatching(lenient bool) {
+	e.lenientMatching = lenient
+}
+
+// EnableCaseInsensitiveMatching controls whether the == and != comparisons of the matchers ignore the case of the
+// strings compared, so that a request for "Alice" matches a rule for "alice". Only the comparisons between request or
+// policy tokens and string literals, like r.sub == p.sub or r.obj.Owner != 'bob', are affected: the arguments of the
+// functions like keyMatch or regexMatch, as well as the in operator, keep comparing the strings as they are.
+// With EnableAcceptJsonRequest, the fields of the JSON requests compared with == are folded like the other strings,
+// but the field names in the matcher still have to match the JSON keys exactly. It is disabled by default.
+func (e *Enforcer) EnableCaseInsensitiveMatching(enable bool) {
+	e.caseInsensitive = enable
+	e.invalidateMatcherMap()
+}
+
+// SetDefaultDomain sets the domain used by the RBAC APIs when no domain is given, for models with a domain
+// in the role definition "g". Enforce also injects it at the domain position of a request omitting its domain,
+// that is a request missing one token besides the optional ones set by SetOptionalRequestTokens.
+// Pass "" to remove the default domain.
+func (e *Enforcer) SetDefaultDomain(domain string) {
+	e.defaultDomain = domain
+}
+
+// SetDomainContextKey sets the key of the context value holding the domain of the requests, like the tenant set once
+// by a middleware. EnforceWithContext then injects the string value of the key at the domain position of a request
+// omitting its domain, in place of the default domain. Pass nil to remove the key.
+func (e *Enforcer) SetDomainContextKey(key interface{}) {
+	e.domainContextKey = key
+}
+
+// SetOptionalRequestTokens marks the trailing request tokens names of the request definition rtype as optional,
+// Enforce accepts a request omitting them and treats them as empty strings. A request omitting a required
+// token is still an error. Pass an empty names to make all the tokens required again.
+func (e *Enforcer) SetOptionalRequestTokens(rtype string, names []string) error {
+	ast, ok := e.model["r"][rtype]
+	if !ok {
+		return fmt.Errorf("request definition %s does not exist", rtype)
+	}
+
+	tokens := ast.Tokens
+	if len(names) > len(tokens) {
+		return fmt.Errorf("request definition %s has only %d tokens", rtype, len(tokens))
+	}
+	for i, name := range names {
+		if tokens[len(tokens)-len(names)+i] != rtype+"_"+name {
+			return fmt.Errorf("the optional tokens of request definition %s must be its trailing tokens", rtype)
+		}
+	}
+
+	if e.optionalRequestTokens == nil {
+		e.optionalRequestTokens = make(map[string]int)
+	}
+	e.optionalRequestTokens[rtype] = len(names)
+	return nil
+}
+
+// HealthStatus summarizes the state of an enforcer, as reported by HealthCheck.
+type HealthStatus struct {
+	// PolicyLoaded is true if there is at least one policy rule.
+	PolicyLoaded bool
+	// RoleLinksBuilt is true if the role links of all the grouping policy types are built.
+	RoleLinksBuilt bool
+	// AdapterReachable is true if there is an adapter, and it answers the ping when it implements persist.HealthCheckAdapter.
+	AdapterReachable bool
+	// EnforcementEnabled is false if the enforcement is disabled by EnableEnforce(false).
+	EnforcementEnabled bool
+	// PolicyCount is the number of the policy rules of all the policy types.
+	PolicyCount int
+	// GroupingPolicyCount is the number of the role inheritance rules of all the grouping policy types.
+	GroupingPolicyCount int
+}
+
+// HealthCheck reports whether the enforcer is ready to serve, the error of the adapter ping is returned if any.
+func (e *Enforcer) HealthCheck() (HealthStatus, error) {
+	status := HealthStatus{
+		EnforcementEnabled: e.enabled,
+		RoleLinksBuilt:     true,
+	}
+	for _, ast := range e.model["p"] {
+		status.PolicyCount += len(ast.Policy)
+	}
+	status.PolicyLoaded = status.PolicyCount > 0
+	for _, ast := range e.model["g"] {
+		status.GroupingPolicyCount += len(ast.Policy)
+		if ast.RM == nil {
+			status.RoleLinksBuilt = false
+		}
+	}
+	e.roleLinksLock.Lock()
+	if len(e.dirtyRoleLinks) != 0 {
+		status.RoleLinksBuilt = false
+	}
+	e.roleLinksLock.Unlock()
+
+	if e.adapter == nil {
+		return status, nil
+	}
+	if adapter, ok := e.adapter.(persist.HealthCheckAdapter); ok {
+		if err := adapter.Ping(); err != nil {
+			return status, err
+		}
+	}
+	status.AdapterReachable = true
+	return status, nil
+}
+
+// SetBreakGlassToken sets the name of a request token (like "breakglass" for r.breakglass) which overrides a deny
+// when its value is true or "true", so that emergency access is possible despite the deny rules. Such overrides are
+// reported as ReasonBreakGlass by EnforceWithReason and logged with a "break-glass" explanation.
+// Pass "" to disable it.
+func (e *Enforcer) SetBreakGlassToken(tokenName string) {
+	e.breakGlassToken = tokenName
+}
+
+// isBreakGlass determines whether the request rvals of the request type rType asks for break-glass access.
+func (e *Enforcer) isBreakGlass(rTokens map[string]int, rType string, rvals []interface{}) bool {
+	if e.breakGlassToken == "" {
+		return false
+	}
+	i, ok := rTokens[rType+"_"+e.breakGlassToken]
+	if !ok || i >= len(rvals) {
+		return false
+	}
+	switch value := rvals[i].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	default:
+		return false
+	}
+}
+
+// SetFallbackDecider sets a function to produce the decision when no policy rule matches a request.
+// It is only consulted in the default-deny case: a request that is explicitly denied by a matched rule
+// will never reach the fallback decider. Pass nil to remove the fallback decider.
+func (e *Enforcer) SetFallbackDecider(fn func(rvals []interface{}) (bool, error)) {
+	e.fallbackDecider = fn
+}
+
+// EnforceHook is called with the request, the decision, the matched policy rule if any, and the error of an enforcement.
+// disabled tells whether the request is allowed without evaluation because the enforcement is disabled.
+type EnforceHook func(rvals []interface{}, result bool, explain []string, err error, disabled bool)
+
+// SetEnforceHook sets a function called right before every enforcement returns, whatever the entry point,
+// like Enforce, EnforceEx, BatchEnforce or EnforceWithMatcher, so that the decisions can be audited in one place.
+// It is called after the model lock is released. Pass nil to remove the hook.
+func (e *Enforcer) SetEnforceHook(hook EnforceHook) {
+	e.enforceHook = hook
+}
+
+// SetLazyRoleLinks controls whether to defer building the role inheritance relations of a grouping policy type
+// until the first enforcement whose matcher uses it, or the first RBAC API call reading the roles. Once built,
+// the relations are kept until a policy change marks them dirty again. Disabling it builds the relations which
+// are still dirty.
+func (e *Enforcer) SetLazyRoleLinks(lazy bool) error {
+	if lazy {
+		e.lazyRoleLinks = true
+		return nil
+	}
+
+	err := e.buildAllDirtyRoleLinks()
+	e.lazyRoleLinks = false
+	return err
+}
+
 // BuildRoleLinks manually rebuild the role inheritance relations.
 func (e *Enforcer) BuildRoleLinks() error {
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
+	return e.buildRoleLinks()
+}
+
+// buildRoleLinks rebuilds the role inheritance relations, modelLock must be held.
+func (e *Enforcer) buildRoleLinks() error {
 	for _, rm := range e.rmMap {
 		err := rm.Clear()
 		if err != nil {
@@ -494,47 +1135,253 @@ func (e *Enforcer) BuildRoleLinks() error {
 		}
 	}
 
+	e.roleLinksLock.Lock()
+	e.dirtyRoleLinks = nil
+	e.roleLinksLock.Unlock()
 	return e.model.BuildRoleLinks(e.rmMap)
 }
 
+// BuildNamedRoleLinks manually rebuild the role inheritance relations of the grouping policy type ptype only.
+func (e *Enforcer) BuildNamedRoleLinks(ptype string) error {
+	if _, ok := e.model["g"][ptype]; !ok {
+		return fmt.Errorf("grouping policy type %s does not exist", ptype)
+	}
+
+	e.roleLinksLock.Lock()
+	defer e.roleLinksLock.Unlock()
+	return e.buildRoleLinksOf(ptype)
+}
+
 // BuildIncrementalRoleLinks provides incremental build the role inheritance relations.
+// With lazy role links, it only marks the role links of ptype dirty.
 func (e *Enforcer) BuildIncrementalRoleLinks(op model.PolicyOp, ptype string, rules [][]string) error {
 	e.invalidateMatcherMap()
+	if e.lazyRoleLinks {
+		e.roleLinksLock.Lock()
+		defer e.roleLinksLock.Unlock()
+		if e.dirtyRoleLinks == nil {
+			e.dirtyRoleLinks = make(map[string]bool)
+		}
+		e.dirtyRoleLinks[ptype] = true
+		return nil
+	}
 	return e.model.BuildIncrementalRoleLinks(e.rmMap, op, "g", ptype, rules)
 }
 
-// NewEnforceContext Create a default structure based on the suffix
-func NewEnforceContext(suffix string) EnforceContext {
-	return EnforceContext{
-		RType: "r" + suffix,
-		PType: "p" + suffix,
-		EType: "e" + suffix,
-		MType: "m" + suffix,
+// markRoleLinksDirty marks the role links of all the grouping policy types of m dirty.
+// The role managers are still set on the assertions, so that the RBAC APIs find them.
+func (e *Enforcer) markRoleLinksDirty(m model.Model) {
+	e.roleLinksLock.Lock()
+	defer e.roleLinksLock.Unlock()
+	e.dirtyRoleLinks = make(map[string]bool)
+	for ptype, ast := range m["g"] {
+		ast.RM = e.rmMap[ptype]
+		e.dirtyRoleLinks[ptype] = true
 	}
 }
 
-func (e *Enforcer) invalidateMatcherMap() {
-	e.matcherMap = sync.Map{}
+// buildDirtyRoleLinks builds the dirty role links of the grouping policy types used by expString.
+// The role managers are rebuilt under the write lock of modelLock, which must not be held,
+// so that no enforcement sees them half-built.
+func (e *Enforcer) buildDirtyRoleLinks(expString string) error {
+	return e.buildRoleLinksOfTypes(e.dirtyRoleLinksUsedBy(expString))
 }
 
-// enforce use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
-func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interface{}) (ok bool, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
-		}
-	}()
-
-	if !e.enabled {
-		return true, nil
+// buildAllDirtyRoleLinks builds the dirty role links of all the grouping policy types, for the RBAC APIs
+// which read the role managers directly. modelLock must not be held.
+func (e *Enforcer) buildAllDirtyRoleLinks() error {
+	if !e.lazyRoleLinks {
+		return nil
 	}
 
-	functions := e.fm.GetFunctions()
-	if _, ok := e.model["g"]; ok {
-		for key, ast := range e.model["g"] {
-			rm := ast.RM
-			functions[key] = util.GenerateGFunction(rm)
-		}
+	e.roleLinksLock.Lock()
+	ptypes := make([]string, 0, len(e.dirtyRoleLinks))
+	for ptype := range e.dirtyRoleLinks {
+		ptypes = append(ptypes, ptype)
+	}
+	e.roleLinksLock.Unlock()
+	return e.buildRoleLinksOfTypes(ptypes)
+}
+
+// dirtyRoleLinksUsedBy returns the grouping policy types used by expString whose role links are dirty.
+func (e *Enforcer) dirtyRoleLinksUsedBy(expString string) []string {
+	if !e.lazyRoleLinks {
+		return nil
+	}
+
+	e.roleLinksLock.Lock()
+	defer e.roleLinksLock.Unlock()
+	var ptypes []string
+	for ptype := range e.dirtyRoleLinks {
+		if callsFunction(expString, ptype) {
+			ptypes = append(ptypes, ptype)
+		}
+	}
+	return ptypes
+}
+
+// buildRoleLinksOfTypes builds the role links of ptypes which are still dirty under the write lock of modelLock.
+func (e *Enforcer) buildRoleLinksOfTypes(ptypes []string) error {
+	if len(ptypes) == 0 {
+		return nil
+	}
+
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
+	e.roleLinksLock.Lock()
+	defer e.roleLinksLock.Unlock()
+	for _, ptype := range ptypes {
+		// another call may have built them while the lock was released
+		if !e.dirtyRoleLinks[ptype] {
+			continue
+		}
+		if err := e.buildRoleLinksOf(ptype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callsFunction returns whether expString calls the function name, not one whose name only ends with it.
+func callsFunction(expString string, name string) bool {
+	call := name + "("
+	for start := 0; ; {
+		i := strings.Index(expString[start:], call)
+		if i == -1 {
+			return false
+		}
+		i += start
+		if i == 0 || !isIdentifierByte(expString[i-1]) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// buildRoleLinksOf rebuilds the role links of ptype and clears its dirty mark, roleLinksLock must be held.
+func (e *Enforcer) buildRoleLinksOf(ptype string) error {
+	rm, ok := e.rmMap[ptype]
+	if !ok {
+		delete(e.dirtyRoleLinks, ptype)
+		return nil
+	}
+	if err := rm.Clear(); err != nil {
+		return err
+	}
+	if err := e.model.BuildIncrementalRoleLinks(e.rmMap, model.PolicyAdd, "g", ptype, e.model["g"][ptype].Policy); err != nil {
+		return err
+	}
+	delete(e.dirtyRoleLinks, ptype)
+	return nil
+}
+
+// NewEnforceContext Create a default structure based on the suffix
+func NewEnforceContext(suffix string) EnforceContext {
+	return EnforceContext{
+		RType: "r" + suffix,
+		PType: "p" + suffix,
+		EType: "e" + suffix,
+		MType: "m" + suffix,
+	}
+}
+
+func (e *Enforcer) invalidateMatcherMap() {
+	e.matcherMap = sync.Map{}
+	e.evalMatcherMap = sync.Map{}
+	if e.matcherCacheSize > 0 {
+		e.matcherCache = util.NewSyncLRUCache(e.matcherCacheSize)
+	} else {
+		e.matcherCache = nil
+	}
+}
+
+// SetMatcherCacheSize bounds the number of the compiled matchers cached besides the ones of the model,
+// like the ones given to EnforceWithMatcher or rewritten for JSON requests, the least recently used ones being evicted.
+// The matchers of the model are always cached. The cache is unbounded if size is 0, which is the default.
+func (e *Enforcer) SetMatcherCacheSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	e.matcherCacheSize = size
+	e.invalidateMatcherMap()
+}
+
+// isModelMatcher returns whether expString is one of the matchers of the model.
+func (e *Enforcer) isModelMatcher(expString string) bool {
+	for _, assertion := range e.model["m"] {
+		if assertion.Value == expString {
+			return true
+		}
+	}
+	return false
+}
+
+// enforce use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
+func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interface{}) (ok bool, err error) {
+	return e.enforceWithContext(context.Background(), matcher, explains, nil, nil, nil, rvals...)
+}
+
+// enforceWithContext is the same as enforce, but stops evaluating the policy and returns the error of ctx once ctx is done.
+// The reason of the decision is stored in reason if it is not nil.
+// The matched policy rule is stored in explanation if it is not nil.
+// The evaluation statistics are stored in stats if it is not nil.
+func (e *Enforcer) enforceWithContext(ctx context.Context, matcher string, explains *[]string, reason *EnforceReason, explanation *Explanation, stats *EnforceStats, rvals ...interface{}) (ok bool, err error) {
+	// collecting is set by EnforceAllMatches, which only reports the matching rules, without any decision.
+	collecting := stats != nil && stats.matches != nil
+	// the hook is deferred first, so that it is called last with the recovered panic.
+	if hook := e.enforceHook; hook != nil && !collecting {
+		if explains == nil {
+			explains = &[]string{}
+		}
+		if reason == nil {
+			reason = new(EnforceReason)
+		}
+		request := rvals
+		defer func() {
+			hook(request, ok, *explains, err, *reason == ReasonEnforceDisabled)
+		}()
+	}
+	defer func() {
+		if e.noPanicRecovery {
+			return
+		}
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	e.modelLock.RLock()
+	defer e.modelLock.RUnlock()
+
+	if stats != nil {
+		*stats = EnforceStats{matches: stats.matches}
+		start := time.Now()
+		defer func() {
+			stats.Duration = time.Since(start)
+		}()
+	}
+	if explanation != nil {
+		*explanation = Explanation{PolicyIndex: -1}
+	}
+	if !e.enabled {
+		if reason != nil {
+			*reason = ReasonEnforceDisabled
+		}
+		if explanation != nil {
+			explanation.Reason = ReasonEnforceDisabled
+		}
+		return true, nil
+	}
+	if reason != nil {
+		*reason = ReasonEvaluated
+	}
+
+	if err = ctx.Err(); err != nil {
+		return false, err
 	}
 
 	var (
@@ -553,7 +1400,14 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 			mType = enforceContext.MType
 			rvals = rvals[1:]
 		default:
-			break
+			if matcher == "" && e.matcherSelector != nil {
+				if selected := e.matcherSelector(rvals); selected != "" {
+					if _, ok := e.model["m"][selected]; !ok {
+						return false, fmt.Errorf("the matcher %s selected by the matcher selector does not exist", selected)
+					}
+					mType = selected
+				}
+			}
 		}
 	}
 
@@ -564,6 +1418,19 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		expString = util.RemoveComments(util.EscapeAssertion(matcher))
 	}
 
+	for len(e.dirtyRoleLinksUsedBy(expString)) != 0 {
+		// the read lock is released while the role links are rebuilt under the write lock
+		e.modelLock.RUnlock()
+		err = e.buildDirtyRoleLinks(expString)
+		e.modelLock.RLock()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	functions := e.fm.GetFunctions()
+	e.addGFunctions(functions, rvals)
+
 	rTokens := make(map[string]int, len(e.model["r"][rType].Tokens))
 	for i, token := range e.model["r"][rType].Tokens {
 		rTokens[token] = i
@@ -573,30 +1440,84 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		pTokens[token] = i
 	}
 
+	// the domain of the context, or else the default domain, is injected when the request omits its domain,
+	// a request which is complete once its optional trailing tokens are padded does not omit its domain.
+	domain := e.defaultDomain
+	if e.domainContextKey != nil {
+		if ctxDomain, ok := ctx.Value(e.domainContextKey).(string); ok && ctxDomain != "" {
+			domain = ctxDomain
+		}
+	}
+	if domain != "" && len(rvals)+e.optionalRequestTokens[rType] == len(rTokens)-1 {
+		if i, ok := rTokens[rType+"_"+constant.DomainIndex]; ok {
+			withDomain := make([]interface{}, 0, len(rvals)+1)
+			withDomain = append(withDomain, rvals[:i]...)
+			withDomain = append(withDomain, domain)
+			rvals = append(withDomain, rvals[i:]...)
+		}
+	}
+
+	// the optional trailing tokens omitted by the request are empty
+	if missing := len(rTokens) - len(rvals); missing > 0 && missing <= e.optionalRequestTokens[rType] {
+		padded := make([]interface{}, len(rTokens))
+		copy(padded, rvals)
+		for i := len(rvals); i < len(padded); i++ {
+			padded[i] = ""
+		}
+		rvals = padded
+	}
+
+	// jsonReplaceCache holds the JSON-substituted policy values of the current request,
+	// so that a value shared by several policy rows is only rewritten once.
+	var jsonReplaceCache map[string]string
+	// jsonReplacer resolves the JSON fields of the request once, for the matcher and all the policy rows.
+	var jsonReplacer *jsonRequestReplacer
+	// jsonPolicyIndices holds the indices of the policy values accessed by field in the matcher.
+	var jsonPolicyIndices []int
 	if e.acceptJsonRequest {
-		expString = requestJsonReplace(expString, rTokens, rvals)
+		jsonReplacer = newJsonRequestReplacer(rTokens, rvals)
+		jsonPolicyIndices = getPolicyJsonIndices(expString, pTokens)
+		expString = policyJsonReplace(jsonReplacer.replace(expString), pTokens)
+		jsonReplaceCache = make(map[string]string)
 	}
 
+	expString = requestAttributerReplace(expString, rTokens, rvals)
+
+	e.addContextFunctions(functions, rTokens, rvals)
+
 	parameters := enforceParameters{
 		rTokens: rTokens,
 		rVals:   rvals,
 
+		pType:   pType,
 		pTokens: pTokens,
+		pIndex:  -1,
+		pCount:  len(e.model["p"][pType].Policy),
+
+		lenient: e.lenientMatching,
 	}
 
 	hasEval := util.HasEval(expString)
 	if hasEval {
-		functions["eval"] = generateEvalFunction(functions, &parameters)
+		// like the matcher, the sub-rules are not cached with the functions bound to the request.
+		var evalCache *sync.Map
+		if !e.hasRequestFunctions() {
+			evalCache = &e.evalMatcherMap
+		}
+		functions["eval"] = e.generateEvalFunction(functions, &parameters, evalCache)
 	}
-	var expression *govaluate.EvaluableExpression
-	expression, err = e.getAndStoreMatcherExpression(hasEval, expString, functions)
+	var expression Expression
+	// the g-functions built with context matching functions and the context functions hold the current request,
+	// so they must not be cached.
+	expression, err = e.getAndStoreMatcherExpression(hasEval || e.hasRequestFunctions(), expString, functions)
 	if err != nil {
 		return false, err
 	}
 
 	if len(e.model["r"][rType].Tokens) != len(rvals) {
 		return false, fmt.Errorf(
-			"invalid request size: expected %d, got %d, rvals: %v",
+			"%w: expected %d, got %d, rvals: %v",
+			Err.ErrInvalidRequestSize,
 			len(e.model["r"][rType].Tokens),
 			len(rvals),
 			rvals)
@@ -607,16 +1528,28 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 
 	var effect effector.Effect
 	var explainIndex int
+	// matched records whether any policy rule matched the request.
+	var matched bool
 
 	if policyLen := len(e.model["p"][pType].Policy); policyLen != 0 && strings.Contains(expString, pType+"_") {
 		policyEffects = make([]effector.Effect, policyLen)
 		matcherResults = make([]float64, policyLen)
 
 		for policyIndex, pvals := range e.model["p"][pType].Policy {
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			default:
+			}
+			if stats != nil {
+				stats.RulesScanned++
+			}
+
 			// log.LogPrint("Policy Rule: ", pvals)
 			if len(e.model["p"][pType].Tokens) != len(pvals) {
 				return false, fmt.Errorf(
-					"invalid policy size: expected %d, got %d, pvals: %v",
+					"%w: expected %d, got %d, pvals: %v",
+					Err.ErrInvalidPolicySize,
 					len(e.model["p"][pType].Tokens),
 					len(pvals),
 					pvals)
@@ -624,20 +1557,39 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 
 			if e.acceptJsonRequest {
 				pvalsCopy := make([]string, len(pvals))
-				copy(pvalsCopy, pvals)
-				for i, pStr := range pvalsCopy {
-					pvalsCopy[i] = requestJsonReplace(util.EscapeAssertion(pStr), rTokens, rvals)
+				for i, pStr := range pvals {
+					// a value without a dot, like most of the plain ones, accesses no field to substitute.
+					if !strings.Contains(pStr, ".") {
+						pvalsCopy[i] = pStr
+						continue
+					}
+					replaced, ok := jsonReplaceCache[pStr]
+					if !ok {
+						if isJsonDocument(pStr) {
+							// a JSON policy value is accessed by field, not evaluated
+							replaced = pStr
+						} else {
+							replaced = policyJsonReplace(jsonReplacer.replace(util.EscapeAssertion(pStr)), pTokens)
+						}
+						jsonReplaceCache[pStr] = replaced
+					}
+					pvalsCopy[i] = replaced
 				}
 				parameters.pVals = pvalsCopy
 			} else {
 				parameters.pVals = pvals
 			}
+			parameters.pIndex = policyIndex
 
-			result, err := expression.Eval(parameters)
-			// log.LogPrint("Result: ", result)
+			// a rule whose policy values accessed by field are not JSON does not match
+			var result interface{} = false
+			if isJsonPolicy(pvals, jsonPolicyIndices) {
+				result, err = expression.Eval(parameters)
+				// log.LogPrint("Result: ", result)
 
-			if err != nil {
-				return false, err
+				if err != nil {
+					return false, err
+				}
 			}
 
 			// set to no-match at first
@@ -648,12 +1600,21 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 					matcherResults[policyIndex] = 1
 				}
 			case float64:
-				if result != 0 {
-					matcherResults[policyIndex] = 1
-				}
+				// the score is kept for the effectors weighting the rules.
+				matcherResults[policyIndex] = result
 			default:
 				return false, errors.New("matcher result should be bool, int or float")
 			}
+			if matcherResults[policyIndex] != 0 {
+				matched = true
+			}
+
+			if collecting {
+				if matcherResults[policyIndex] != 0 {
+					*stats.matches = append(*stats.matches, append([]string(nil), pvals...))
+				}
+				continue
+			}
 
 			if j, ok := parameters.pTokens[pType+"_eft"]; ok {
 				eft := parameters.pVals[j]
@@ -680,7 +1641,20 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 				break
 			}
 		}
+
+		// the matching rules are collected, the decision is not made, nor logged or reported.
+		if collecting {
+			return false, nil
+		}
+
+		if e.explainStrategy == ExplainFirstMatchingRule && explainIndex != -1 {
+			explainIndex = getFirstMatchingIndex(policyEffects, matcherResults, explainIndex)
+		}
 	} else {
+		// a matcher without policy tokens matches no rule.
+		if collecting {
+			return false, nil
+		}
 
 		if hasEval && len(e.model["p"][pType].Policy) == 0 {
 			return false, errors.New("please make sure rule exists in policy when using eval() in matcher")
@@ -699,6 +1673,7 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		}
 
 		if result.(bool) {
+			matched = true
 			policyEffects[0] = effector.Allow
 		} else {
 			policyEffects[0] = effector.Indeterminate
@@ -710,6 +1685,14 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 		}
 	}
 
+	if explanation != nil {
+		explanation.PType = pType
+		if explainIndex != -1 && len(e.model["p"][pType].Policy) > explainIndex {
+			explanation.PolicyIndex = explainIndex
+			explanation.Rule = append([]string(nil), e.model["p"][pType].Policy[explainIndex]...)
+		}
+	}
+
 	var logExplains [][]string
 
 	if explains != nil {
@@ -728,63 +1711,393 @@ func (e *Enforcer) enforce(matcher string, explains *[]string, rvals ...interfac
 	if effect == effector.Allow {
 		result = true
 	}
+
+	if !result && !matched && e.fallbackDecider != nil {
+		result, err = e.fallbackDecider(rvals)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	decision := ReasonEvaluated
+	if !result && matched {
+		decision = ReasonDeniedByRule
+	} else if !result {
+		decision = ReasonNoMatchingRule
+	}
+
+	if !result && e.isBreakGlass(rTokens, rType, rvals) {
+		result = true
+		decision = ReasonBreakGlass
+		logExplains = append(logExplains, []string{"break-glass", e.breakGlassToken})
+	}
+	if reason != nil {
+		*reason = decision
+	}
+	if explanation != nil {
+		explanation.Reason = decision
+		explanation.Effect = effect
+	}
+	if stats != nil {
+		stats.Matched = matched
+	}
 	e.logger.LogEnforce(expString, rvals, result, logExplains)
 
 	return result, nil
 }
 
-var requestObjectRegex = regexp.MustCompile(`r[_.][A-Za-z_0-9]+\.[A-Za-z_0-9.]+[A-Za-z_0-9]`)
-var requestObjectRegexPrefix = regexp.MustCompile(`r[_.][A-Za-z_0-9]+\.`)
+// getFirstMatchingIndex gets the lowest index of the matching rules with the same effect as the rule at explainIndex.
+// The rules after the last evaluated one are not matching.
+func getFirstMatchingIndex(effects []effector.Effect, matches []float64, explainIndex int) int {
+	for i := 0; i < explainIndex; i++ {
+		if matches[i] != 0 && effects[i] == effects[explainIndex] {
+			return i
+		}
+	}
+	return explainIndex
+}
+
+var requestObjectRegex = regexp.MustCompile(`\br[0-9]*[_.][A-Za-z_0-9]+\.[A-Za-z_0-9.]+[A-Za-z_0-9]`)
+var requestObjectRegexPrefix = regexp.MustCompile(`r[0-9]*[_.][A-Za-z_0-9]+\.`)
 
 // requestJsonReplace used to support request parameters of type json
 // It will replace the access of the request object in matchers or policy with the actual value in the request json parameter
 // For example: request sub = `{"Owner": "alice", "Age": 30}`
 // policy: p, r.sub.Age > 18, /data1, read  ==>  p, 30 > 18, /data1, read
 // matchers: m = r.sub == r.obj.Owner  ==>  m = r.sub == "alice"
+// Nested fields and array elements are accessed by path, e.g. r.sub.profile.address.city or r.sub.roles.0.
+// Numbers are kept unquoted, any other value is quoted and a missing path yields "".
 func requestJsonReplace(str string, rTokens map[string]int, rvals []interface{}) string {
-	matches := requestObjectRegex.FindStringSubmatch(str)
-	for _, matchesStr := range matches {
-		prefix := requestObjectRegexPrefix.FindString(matchesStr)
-		jsonPath := strings.TrimPrefix(matchesStr, prefix)
-		tokenIndex := rTokens[prefix[:len(prefix)-1]]
-		if jsonStr, ok := rvals[tokenIndex].(string); ok {
-			newStr := gjson.Get(jsonStr, jsonPath).String()
-			if !util.IsNumeric(newStr) {
-				newStr = `"` + newStr + `"`
-			}
-			str = strings.Replace(str, matchesStr, newStr, -1)
+	return newJsonRequestReplacer(rTokens, rvals).replace(str)
+}
+
+// jsonRequestReplacer does the substitutions of requestJsonReplace for a request, the fields being looked up
+// in the JSON request values once, however many times they are accessed by the matcher and the policy rows.
+type jsonRequestReplacer struct {
+	rTokens  map[string]int
+	rvals    []interface{}
+	resolved map[string]string
+}
+
+func newJsonRequestReplacer(rTokens map[string]int, rvals []interface{}) *jsonRequestReplacer {
+	return &jsonRequestReplacer{rTokens: rTokens, rvals: rvals, resolved: make(map[string]string)}
+}
+
+// replace replaces the accesses of the request values in str like requestJsonReplace.
+func (r *jsonRequestReplacer) replace(str string) string {
+	return requestObjectRegex.ReplaceAllStringFunc(str, func(matchesStr string) string {
+		if resolved, ok := r.resolved[matchesStr]; ok {
+			return resolved
 		}
+		resolved := r.resolve(matchesStr)
+		r.resolved[matchesStr] = resolved
+		return resolved
+	})
+}
+
+// resolve returns the value of the request field accessed by matchesStr, like r_sub.Age, or matchesStr itself
+// if the request value is not JSON.
+func (r *jsonRequestReplacer) resolve(matchesStr string) string {
+	prefix := requestObjectRegexPrefix.FindString(matchesStr)
+	jsonPath := strings.TrimPrefix(matchesStr, prefix)
+	token := strings.Replace(prefix[:len(prefix)-1], ".", "_", 1)
+	tokenIndex, ok := r.rTokens[token]
+	if !ok || tokenIndex >= len(r.rvals) {
+		return matchesStr
+	}
+	jsonStr, ok := r.rvals[tokenIndex].(string)
+	if !ok {
+		return matchesStr
+	}
+	res := gjson.Get(jsonStr, jsonPath)
+	if res.Type == gjson.Number {
+		return res.Raw
 	}
-	return str
+	return `"` + jsonStringEscaper.Replace(res.String()) + `"`
 }
 
-func (e *Enforcer) getAndStoreMatcherExpression(hasEval bool, expString string, functions map[string]govaluate.ExpressionFunction) (*govaluate.EvaluableExpression, error) {
-	var expression *govaluate.EvaluableExpression
+// jsonStringEscaper escapes a JSON value so that it can be embedded in a govaluate string literal.
+var jsonStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+var policyObjectRegex = regexp.MustCompile(`\bp[0-9]*_[A-Za-z_0-9]+(\.[A-Za-z_0-9]+)+`)
+
+// policyJsonReplace escapes the accesses of the policy values in str, so that they are resolved
+// as a whole by enforceParameters from the JSON stored in the policy rule.
+// For example: r_sub.Age > p_constraint.minAge ==> r_sub.Age > [p_constraint.minAge]
+func policyJsonReplace(str string, pTokens map[string]int) string {
+	return policyObjectRegex.ReplaceAllStringFunc(str, func(m string) string {
+		if _, ok := pTokens[m[:strings.Index(m, ".")]]; !ok {
+			return m
+		}
+		return "[" + m + "]"
+	})
+}
+
+// getPolicyJsonIndices gets the indices of the policy values accessed by field in str, like p_constraint.minAge.
+func getPolicyJsonIndices(str string, pTokens map[string]int) []int {
+	var indices []int
+	for _, m := range policyObjectRegex.FindAllString(str, -1) {
+		if i, ok := pTokens[m[:strings.Index(m, ".")]]; ok {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// isJsonPolicy returns whether the policy values of the rule pvals at indices are all JSON documents.
+func isJsonPolicy(pvals []string, indices []int) bool {
+	for _, i := range indices {
+		if !isJsonDocument(pvals[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isJsonDocument returns whether the policy value s is a JSON object or array.
+func isJsonDocument(s string) bool {
+	s = strings.TrimSpace(s)
+	return (strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")) && gjson.Valid(s)
+}
+
+var requestAttributeRegex = regexp.MustCompile(`\br[0-9]*_[A-Za-z_0-9]+(\.[A-Za-z_0-9]+)+`)
+
+// requestAttributerReplace escapes the accesses of the request values implementing Attributer in str,
+// so that they are resolved as a whole by enforceParameters.
+// For example: r_sub.department ==> [r_sub.department]
+func requestAttributerReplace(str string, rTokens map[string]int, rvals []interface{}) string {
+	hasAttributer := false
+	for _, rval := range rvals {
+		if _, ok := rval.(Attributer); ok {
+			hasAttributer = true
+			break
+		}
+	}
+	if !hasAttributer {
+		return str
+	}
+
+	return requestAttributeRegex.ReplaceAllStringFunc(str, func(m string) string {
+		i, ok := rTokens[m[:strings.Index(m, ".")]]
+		if !ok || i >= len(rvals) {
+			return m
+		}
+		if _, ok := rvals[i].(Attributer); !ok {
+			return m
+		}
+		return "[" + m + "]"
+	})
+}
+
+func (e *Enforcer) getAndStoreMatcherExpression(hasEval bool, expString string, functions map[string]govaluate.ExpressionFunction) (Expression, error) {
+	var expression Expression
 	var err error
-	var cachedExpression, isPresent = e.matcherMap.Load(expString)
+	var cachedExpression interface{}
+	var isPresent bool
+
+	// the model matchers stay in matcherMap, only the other ones are bounded.
+	matcherCache := e.matcherCache
+	if matcherCache != nil && e.isModelMatcher(expString) {
+		matcherCache = nil
+	}
+	if matcherCache != nil {
+		cachedExpression, isPresent = matcherCache.Get(expString)
+	} else {
+		cachedExpression, isPresent = e.matcherMap.Load(expString)
+	}
 
 	if !hasEval && isPresent {
-		expression = cachedExpression.(*govaluate.EvaluableExpression)
+		atomic.AddInt64(&e.matcherCacheHits, 1)
+		expression = cachedExpression.(Expression)
 	} else {
-		expression, err = govaluate.NewEvaluableExpressionWithFunctions(expString, functions)
+		atomic.AddInt64(&e.matcherCacheMisses, 1)
+		expression, err = e.compile(expString, functions)
 		if err != nil {
 			return nil, err
 		}
-		e.matcherMap.Store(expString, expression)
+		if matcherCache != nil {
+			if matcherCache.PutAndEvict(expString, expression) {
+				atomic.AddInt64(&e.matcherCacheEvictions, 1)
+			}
+		} else {
+			e.matcherMap.Store(expString, expression)
+		}
 	}
 	return expression, nil
 }
 
+// MatcherCacheStats reports how the compiled matcher expressions are reused, as returned by GetMatcherCacheStats.
+type MatcherCacheStats struct {
+	Hits   int64
+	Misses int64
+	Size   int
+	// Evictions stays 0 as long as the cache is unbounded, see SetMatcherCacheSize.
+	Evictions int64
+}
+
+// GetMatcherCacheStats gets the hits and misses of the matcher expression cache since the last reset,
+// together with its current size. A miss means the matcher expression was compiled.
+func (e *Enforcer) GetMatcherCacheStats() MatcherCacheStats {
+	size := 0
+	e.matcherMap.Range(func(key, value interface{}) bool {
+		size++
+		return true
+	})
+	if matcherCache := e.matcherCache; matcherCache != nil {
+		size += matcherCache.Len()
+	}
+
+	return MatcherCacheStats{
+		Hits:      atomic.LoadInt64(&e.matcherCacheHits),
+		Misses:    atomic.LoadInt64(&e.matcherCacheMisses),
+		Size:      size,
+		Evictions: atomic.LoadInt64(&e.matcherCacheEvictions),
+	}
+}
+
+// ResetMatcherCacheStats resets the counters of the matcher expression cache, the cached expressions are kept.
+func (e *Enforcer) ResetMatcherCacheStats() {
+	atomic.StoreInt64(&e.matcherCacheHits, 0)
+	atomic.StoreInt64(&e.matcherCacheMisses, 0)
+	atomic.StoreInt64(&e.matcherCacheEvictions, 0)
+}
+
 // Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
 func (e *Enforcer) Enforce(rvals ...interface{}) (bool, error) {
 	return e.enforce("", nil, rvals...)
 }
 
+// EnforceWithContext decides whether a "subject" can access a "object" with the operation "action" like Enforce,
+// but stops evaluating the policy and returns the error of ctx, like context.DeadlineExceeded, once ctx is done.
+func (e *Enforcer) EnforceWithContext(ctx context.Context, rvals ...interface{}) (bool, error) {
+	return e.enforceWithContext(ctx, "", nil, nil, nil, nil, rvals...)
+}
+
+// EnforceWithDeadlineFallback decides whether a "subject" can access a "object" with the operation "action" like Enforce,
+// but returns the fallback decision together with errors.ErrEnforceFallback if ctx is done before the evaluation finishes.
+// It allows latency-critical callers to degrade to a conservative decision instead of failing.
+func (e *Enforcer) EnforceWithDeadlineFallback(ctx context.Context, fallback bool, rvals ...interface{}) (bool, error) {
+	res, err := e.enforceWithContext(ctx, "", nil, nil, nil, nil, rvals...)
+	if err != nil && (err == context.DeadlineExceeded || err == context.Canceled) {
+		return fallback, Err.ErrEnforceFallback
+	}
+	return res, err
+}
+
 // EnforceWithMatcher use a custom matcher to decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (matcher, sub, obj, act), use model matcher by default when matcher is "".
 func (e *Enforcer) EnforceWithMatcher(matcher string, rvals ...interface{}) (bool, error) {
 	return e.enforce(matcher, nil, rvals...)
 }
 
+// CombineMode is how EnforceWithMatchers combines the results of its matchers.
+type CombineMode int
+
+const (
+	// CombineAnd allows the request only if all the matchers allow it.
+	CombineAnd CombineMode = iota
+	// CombineOr allows the request if any of the matchers allows it.
+	CombineOr
+)
+
+// EnforceWithMatchers decides whether a "subject" can access a "object" with the operation "action" with several matchers,
+// whose results are combined by combine, the evaluation stops as soon as the result is known.
+// A matcher is either the name of a matcher of the model, like "m2", or a matcher expression like in EnforceWithMatcher.
+// A named matcher is evaluated with the request, policy and effect types of its suffix, like r2, p2 and e2 for m2,
+// or with r, p and e when they are not defined. A matcher expression is evaluated with r, p and e.
+// The same request is passed to all the matchers, so its size is validated against the request type of each of them
+// and must fit all of them.
+func (e *Enforcer) EnforceWithMatchers(combine CombineMode, matchers []string, rvals ...interface{}) (bool, error) {
+	if len(matchers) == 0 {
+		return false, errors.New("no matcher is given")
+	}
+	if len(rvals) != 0 {
+		if _, ok := rvals[0].(EnforceContext); ok {
+			return false, errors.New("the enforce context is resolved from the matchers and cannot be given")
+		}
+	}
+
+	for _, matcher := range matchers {
+		var res bool
+		var err error
+		if _, ok := e.model["m"][matcher]; ok {
+			res, err = e.enforce("", nil, append([]interface{}{e.getMatcherEnforceContext(matcher)}, rvals...)...)
+		} else {
+			res, err = e.enforce(matcher, nil, rvals...)
+		}
+		if err != nil {
+			return false, err
+		}
+		if combine == CombineOr && res {
+			return true, nil
+		}
+		if combine != CombineOr && !res {
+			return false, nil
+		}
+	}
+	return combine != CombineOr, nil
+}
+
+// getMatcherEnforceContext gets the enforce context of the matcher mType from its suffix,
+// the types which are not defined in the model are replaced by the default ones.
+func (e *Enforcer) getMatcherEnforceContext(mType string) EnforceContext {
+	enforceContext := NewEnforceContext(strings.TrimPrefix(mType, "m"))
+	if _, ok := e.model["r"][enforceContext.RType]; !ok {
+		enforceContext.RType = "r"
+	}
+	if _, ok := e.model["p"][enforceContext.PType]; !ok {
+		enforceContext.PType = "p"
+	}
+	if _, ok := e.model["e"][enforceContext.EType]; !ok {
+		enforceContext.EType = "e"
+	}
+	enforceContext.MType = mType
+	return enforceContext
+}
+
+// EnforceWithReason decides whether a "subject" can access a "object" with the operation "action" like Enforce,
+// and also returns the reason of the decision, so that an allow caused by disabled enforcement can be told apart,
+// as well as a denial by a matching rule from a request matching no rule. See EnforceExExplained for the rule
+// and the effect behind the decision.
+func (e *Enforcer) EnforceWithReason(rvals ...interface{}) (bool, EnforceReason, error) {
+	var reason EnforceReason
+	result, err := e.enforceWithContext(context.Background(), "", nil, &reason, nil, nil, rvals...)
+	return result, reason, err
+}
+
+// EnforceStats holds the statistics of an evaluation, as returned by EnforceWithStats.
+type EnforceStats struct {
+	// RulesScanned is the number of policy rules evaluated before the decision was made.
+	RulesScanned int
+	// Matched tells whether any of the evaluated policy rules matched the request.
+	Matched bool
+	// Duration is the time the evaluation took.
+	Duration time.Duration
+
+	// matches, when set by EnforceAllMatches, collects all the policy rules matching the request,
+	// every rule being evaluated instead of stopping at the decision.
+	matches *[][]string
+}
+
+// EnforceWithStats decides whether a "subject" can access a "object" with the operation "action" like Enforce,
+// and also returns the statistics of the evaluation, like how many policy rules were scanned.
+func (e *Enforcer) EnforceWithStats(rvals ...interface{}) (bool, EnforceStats, error) {
+	var stats EnforceStats
+	result, err := e.enforceWithContext(context.Background(), "", nil, nil, nil, &stats, rvals...)
+	return result, stats, err
+}
+
+// EnforceAllMatches returns all the policy rules matching the request, to debug over-broad policies: unlike EnforceEx,
+// the matcher is evaluated against every rule instead of stopping once the effect of the model decides the request.
+// It is meant for diagnostics only, it is slower than Enforce and the rules are returned whatever their effect.
+func (e *Enforcer) EnforceAllMatches(rvals ...interface{}) ([][]string, error) {
+	matches := [][]string{}
+	stats := EnforceStats{matches: &matches}
+	if _, err := e.enforceWithContext(context.Background(), "", nil, nil, nil, &stats, rvals...); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
 // EnforceEx explain enforcement by informing matched rules
 func (e *Enforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
 	explain := []string{}
@@ -792,6 +2105,25 @@ func (e *Enforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
 	return result, explain, err
 }
 
+// Explanation describes the policy rule which decided an enforcement, as returned by EnforceExExplained.
+// PolicyIndex is -1 and Rule is nil when no policy rule decided the enforcement.
+// Reason is the reason of the decision like with EnforceWithReason, and Effect the decision of the effector.
+type Explanation struct {
+	PType       string
+	PolicyIndex int
+	Rule        []string
+	Reason      EnforceReason
+	Effect      effector.Effect
+}
+
+// EnforceExExplained explain enforcement like EnforceEx, but also informs the policy type of the matched rule
+// and its position in the policy.
+func (e *Enforcer) EnforceExExplained(rvals ...interface{}) (bool, Explanation, error) {
+	var explanation Explanation
+	result, err := e.enforceWithContext(context.Background(), "", nil, nil, &explanation, nil, rvals...)
+	return result, explanation, err
+}
+
 // EnforceExWithMatcher use a custom matcher and explain enforcement by informing matched rules
 func (e *Enforcer) EnforceExWithMatcher(matcher string, rvals ...interface{}) (bool, []string, error) {
 	explain := []string{}
@@ -799,6 +2131,23 @@ func (e *Enforcer) EnforceExWithMatcher(matcher string, rvals ...interface{}) (b
 	return result, explain, err
 }
 
+// EvaluateAllMatchers evaluates the request against every matcher (like "m" and "m2") defined in the model,
+// and returns the decision of each, keyed by the matcher type. A matcher "m<suffix>" is evaluated with
+// the request, policy and effect definitions of the same suffix, falling back to "r", "p" and "e" when
+// they are not defined. It is a debugging aid to see which matchers a request would satisfy.
+func (e *Enforcer) EvaluateAllMatchers(rvals ...interface{}) (map[string]bool, error) {
+	res := make(map[string]bool, len(e.model["m"]))
+	for mType := range e.model["m"] {
+		enforceContext := e.getMatcherEnforceContext(mType)
+		result, err := e.enforce("", nil, append([]interface{}{enforceContext}, rvals...)...)
+		if err != nil {
+			return nil, fmt.Errorf("matcher %s: %v", mType, err)
+		}
+		res[mType] = result
+	}
+	return res, nil
+}
+
 // BatchEnforce enforce in batches
 func (e *Enforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
 	var results []bool
@@ -812,6 +2161,73 @@ func (e *Enforcer) BatchEnforce(requests [][]interface{}) ([]bool, error) {
 	return results, nil
 }
 
+// BatchEnforceAll enforce in batches like BatchEnforce, but evaluates every request instead of stopping at the first error.
+// The results and the errors keep the order of the requests, the error of a successful request being nil.
+func (e *Enforcer) BatchEnforceAll(requests [][]interface{}) ([]bool, []error) {
+	results := make([]bool, len(requests))
+	errs := make([]error, len(requests))
+	for i, request := range requests {
+		results[i], errs[i] = e.enforce("", nil, request...)
+	}
+	return results, errs
+}
+
+// BatchEnforceWithContext enforce in batches like BatchEnforce, but stops and returns the error of ctx once ctx is done.
+func (e *Enforcer) BatchEnforceWithContext(ctx context.Context, requests [][]interface{}) ([]bool, error) {
+	var results []bool
+	for _, request := range requests {
+		result, err := e.enforceWithContext(ctx, "", nil, nil, nil, nil, request...)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// BatchEnforceParallel enforce in batches like BatchEnforce, but spreads the requests over at most workers goroutines,
+// workers defaults to GOMAXPROCS when it is not positive. The results keep the order of the requests, and the first error
+// encountered is returned with no results. The policy must not be changed during the call.
+func (e *Enforcer) BatchEnforceParallel(requests [][]interface{}, workers int) ([]bool, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(requests) {
+		workers = len(requests)
+	}
+
+	results := make([]bool, len(requests))
+	indexes := make(chan int)
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result, err := e.enforce("", nil, requests[i]...)
+				if err != nil {
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+	for i := range requests {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
 // BatchEnforceWithMatcher enforce with matcher in batches
 func (e *Enforcer) BatchEnforceWithMatcher(matcher string, requests [][]interface{}) ([]bool, error) {
 	var results []bool
@@ -825,10 +2241,65 @@ func (e *Enforcer) BatchEnforceWithMatcher(matcher string, requests [][]interfac
 	return results, nil
 }
 
+// SetMatcherSelector sets a function to choose the matcher type (like "m" or "m2") of the model per request,
+// the default matcher is used when it returns "". It is not consulted when a matcher or an EnforceContext
+// is given explicitly. Pass nil to remove the matcher selector.
+func (e *Enforcer) SetMatcherSelector(fn func(rvals []interface{}) string) {
+	e.matcherSelector = fn
+}
+
+// PreserveGroupingFunction controls whether a function added by AddFunction with the same name as the grouping policy type
+// (like "g" or "g2") is kept, instead of being shadowed by the g-function generated from the role manager.
+func (e *Enforcer) PreserveGroupingFunction(ptype string, preserve bool) {
+	e.invalidateMatcherMap()
+	if e.preservedGFunctions == nil {
+		e.preservedGFunctions = make(map[string]bool)
+	}
+	e.preservedGFunctions[ptype] = preserve
+}
+
+// addGFunctions adds the g-functions of all the grouping policy types to functions,
+// skipping the ones preserved by PreserveGroupingFunction. rvals is the request
+// passed to the context matching functions, it can be nil.
+func (e *Enforcer) addGFunctions(functions map[string]govaluate.ExpressionFunction, rvals []interface{}) {
+	for key, ast := range e.model["g"] {
+		if _, ok := functions[key]; ok && e.preservedGFunctions[key] {
+			continue
+		}
+		if fn, ok := e.contextMatchingFuncs[key]; ok {
+			functions[key] = util.GenerateContextGFunction(ast.RM, fn, rvals)
+		} else {
+			functions[key] = util.GenerateGFunction(ast.RM)
+		}
+		if guest, ok := e.guestRoles[key]; ok {
+			functions[key] = util.GenerateGuestGFunction(ast.RM, guest, functions[key])
+		}
+	}
+}
+
+// SetGuestRole sets the guest role of the grouping policy type ptype, like "g": the users without any role
+// are then given the guest role by the matchers, so g(r.sub, p.sub) holds for the rules of the guest and of
+// the roles it inherits. The RBAC APIs, like GetRolesForUser, are not affected. Pass "" to remove the guest role.
+func (e *Enforcer) SetGuestRole(ptype string, role string) {
+	e.invalidateMatcherMap()
+	if role == "" {
+		delete(e.guestRoles, ptype)
+		return
+	}
+	if e.guestRoles == nil {
+		e.guestRoles = make(map[string]string)
+	}
+	e.guestRoles[ptype] = role
+}
+
 // AddNamedMatchingFunc add MatchingFunc by ptype RoleManager
 func (e *Enforcer) AddNamedMatchingFunc(ptype, name string, fn rbac.MatchingFunc) bool {
 	if rm, ok := e.rmMap[ptype]; ok {
 		rm.AddMatchingFunc(name, fn)
+		if e.matchingFuncs == nil {
+			e.matchingFuncs = make(map[string]rbac.MatchingFunc)
+		}
+		e.matchingFuncs[ptype] = fn
 		return true
 	}
 	return false
@@ -838,20 +2309,89 @@ func (e *Enforcer) AddNamedMatchingFunc(ptype, name string, fn rbac.MatchingFunc
 func (e *Enforcer) AddNamedDomainMatchingFunc(ptype, name string, fn rbac.MatchingFunc) bool {
 	if rm, ok := e.rmMap[ptype]; ok {
 		rm.AddDomainMatchingFunc(name, fn)
+		if e.namedDomainMatchingFuncs == nil {
+			e.namedDomainMatchingFuncs = make(map[string]rbac.MatchingFunc)
+		}
+		e.namedDomainMatchingFuncs[ptype] = fn
 		return true
 	}
 	return false
 }
 
+// SetDomainMatchingFunc declares the domain matching function of the grouping policy type ptype, like util.KeyMatch
+// for the domain patterns, instead of detecting keyMatch(r.dom, p.dom) in the matcher. Unlike AddNamedDomainMatchingFunc,
+// the declaration is kept for the role managers created when the model is reloaded or the enforcer cloned.
+// A nil fn declares that the domains match exactly, disabling the detection.
+func (e *Enforcer) SetDomainMatchingFunc(ptype string, fn rbac.MatchingFunc) {
+	e.invalidateMatcherMap()
+	if e.domainMatchingFuncs == nil {
+		e.domainMatchingFuncs = make(map[string]rbac.MatchingFunc)
+	}
+	e.domainMatchingFuncs[ptype] = fn
+	e.AddNamedDomainMatchingFunc(ptype, "g", fn)
+}
+
+// AddNamedContextMatchingFunc adds a domain matching function by ptype which also receives the request.
+// fn is called with the domain of the request, the domain of a grouping policy, and then the values of the request,
+// so that whether a domain matches can depend on the request itself. name is only kept for symmetry with
+// AddNamedDomainMatchingFunc. The matching functions added by AddNamedDomainMatchingFunc keep working.
+func (e *Enforcer) AddNamedContextMatchingFunc(ptype, name string, fn rbac.ContextMatchingFunc) bool {
+	if _, ok := e.rmMap[ptype]; !ok {
+		return false
+	}
+	e.invalidateMatcherMap()
+	if e.contextMatchingFuncs == nil {
+		e.contextMatchingFuncs = make(map[string]rbac.ContextMatchingFunc)
+	}
+	e.contextMatchingFuncs[ptype] = fn
+	return true
+}
+
+// hasRequestFunctions returns whether the matcher functions are bound to the request being enforced.
+func (e *Enforcer) hasRequestFunctions() bool {
+	return len(e.contextMatchingFuncs) != 0 || len(e.contextFunctions) != 0
+}
+
+// addContextFunctions adds the functions added by AddFunctionWithContext to functions,
+// bound to the request rvals.
+func (e *Enforcer) addContextFunctions(functions map[string]govaluate.ExpressionFunction, rTokens map[string]int, rvals []interface{}) {
+	if len(e.contextFunctions) == 0 {
+		return
+	}
+
+	request := make(map[string]interface{}, len(rTokens))
+	for token, i := range rTokens {
+		if i < len(rvals) {
+			request[token] = rvals[i]
+		}
+	}
+	for name, fn := range e.contextFunctions {
+		fn := fn
+		functions[name] = func(args ...interface{}) (interface{}, error) {
+			return fn(request, args...)
+		}
+	}
+}
+
 // assumes bounds have already been checked
 type enforceParameters struct {
 	rTokens map[string]int
 	rVals   []interface{}
 
+	pType   string
 	pTokens map[string]int
 	pVals   []string
+	// pIndex and pCount are exposed as the built-in parameters p_index and p_count (like p2_index for p2),
+	// the index of the current policy rule and the number of policy rules, unless they are real policy tokens.
+	pIndex int
+	pCount int
+
+	// lenient makes the unknown request and policy tokens resolve to "", see SetLenientMatching.
+	lenient bool
 }
 
+var definitionTokenRegex = regexp.MustCompile(`^[rp][0-9]*_`)
+
 // implements govaluate.Parameters
 func (p enforceParameters) Get(name string) (interface{}, error) {
 	if name == "" {
@@ -862,13 +2402,27 @@ func (p enforceParameters) Get(name string) (interface{}, error) {
 	case 'p':
 		i, ok := p.pTokens[name]
 		if !ok {
+			if dot := strings.Index(name, "."); dot != -1 {
+				if i, ok := p.pTokens[name[:dot]]; ok && i < len(p.pVals) {
+					return getPolicyJsonValue(p.pVals[i], name[dot+1:]), nil
+				}
+			}
+			switch name {
+			case p.pType + "_index":
+				return float64(p.pIndex), nil
+			case p.pType + "_count":
+				return float64(p.pCount), nil
+			}
+			if p.lenient && definitionTokenRegex.MatchString(name) {
+				return "", nil
+			}
 			return nil, errors.New("No parameter '" + name + "' found.")
 		}
 		return p.pVals[i], nil
 	case 'r':
 		i, ok := p.rTokens[name]
 		if !ok {
-			return nil, errors.New("No parameter '" + name + "' found.")
+			return p.getAttribute(name)
 		}
 		return p.rVals[i], nil
 	default:
@@ -876,7 +2430,33 @@ func (p enforceParameters) Get(name string) (interface{}, error) {
 	}
 }
 
-func generateEvalFunction(functions map[string]govaluate.ExpressionFunction, parameters *enforceParameters) govaluate.ExpressionFunction {
+// getAttribute resolves an access like r_sub.department on a request value implementing Attributer.
+func (p enforceParameters) getAttribute(name string) (interface{}, error) {
+	if dot := strings.Index(name, "."); dot != -1 {
+		if i, ok := p.rTokens[name[:dot]]; ok && i < len(p.rVals) {
+			if attributer, ok := p.rVals[i].(Attributer); ok {
+				return attributer.GetAttribute(name[dot+1:])
+			}
+		}
+	}
+	if p.lenient && definitionTokenRegex.MatchString(name) {
+		return "", nil
+	}
+	return nil, errors.New("No parameter '" + name + "' found.")
+}
+
+// getPolicyJsonValue gets the field at path in the JSON policy value pVal, numbers are returned as float64
+// and any other value as a string. A missing field or a plain (non-JSON) policy value yields "".
+func getPolicyJsonValue(pVal string, path string) interface{} {
+	res := gjson.Get(pVal, path)
+	if res.Type == gjson.Number {
+		return res.Float()
+	}
+	return res.String()
+}
+
+// generateEvalFunction generates the eval() function of a request, the compiled sub-rules are stored in cache if it is not nil.
+func (e *Enforcer) generateEvalFunction(functions map[string]govaluate.ExpressionFunction, parameters *enforceParameters, cache *sync.Map) govaluate.ExpressionFunction {
 	return func(args ...interface{}) (interface{}, error) {
 		if len(args) != 1 {
 			return nil, fmt.Errorf("function eval(subrule string) expected %d arguments, but got %d", 1, len(args))
@@ -886,11 +2466,24 @@ func generateEvalFunction(functions map[string]govaluate.ExpressionFunction, par
 		if !ok {
 			return nil, errors.New("argument of eval(subrule string) must be a string")
 		}
-		expression = util.EscapeAssertion(expression)
-		expr, err := govaluate.NewEvaluableExpressionWithFunctions(expression, functions)
+		expression = requestAttributerReplace(util.EscapeAssertion(expression), parameters.rTokens, parameters.rVals)
+		// a nested eval() is bound to the parameters of the current request, so it must not be cached.
+		if cache == nil || util.HasEval(expression) {
+			expr, err := e.compile(expression, functions)
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing eval parameter: %s, %s", expression, err.Error())
+			}
+			return expr.Eval(parameters)
+		}
+
+		if cached, ok := cache.Load(expression); ok {
+			return cached.(Expression).Eval(parameters)
+		}
+		expr, err := e.compile(expression, functions)
 		if err != nil {
 			return nil, fmt.Errorf("error while parsing eval parameter: %s, %s", expression, err.Error())
 		}
+		cache.Store(expression, expr)
 		return expr.Eval(parameters)
 	}
 }
@@ -100,6 +100,8 @@ type IEnforcer interface {
 	GetFilteredPolicy(fieldIndex int, fieldValues ...string) [][]string
 	GetNamedPolicy(ptype string) [][]string
 	GetFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) [][]string
+	GetFilteredPolicyWithIndices(fieldIndex int, fieldValues ...string) ([][]string, []int, error)
+	GetFilteredNamedPolicyWithIndices(ptype string, fieldIndex int, fieldValues ...string) ([][]string, []int, error)
 	GetGroupingPolicy() [][]string
 	GetFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) [][]string
 	GetNamedGroupingPolicy(ptype string) [][]string
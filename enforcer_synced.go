@@ -15,6 +15,7 @@
 package casbin
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -122,6 +123,20 @@ func (e *SyncedEnforcer) LoadPolicy() error {
 	return e.Enforcer.LoadPolicy()
 }
 
+// GetModelText renders the current model into the text of a model file, for diagnostics.
+func (e *SyncedEnforcer) GetModelText() string {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetModelText()
+}
+
+// LoadPolicyStream reloads the policy from a streaming adapter, keeping the current policy if loading fails.
+func (e *SyncedEnforcer) LoadPolicyStream() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.LoadPolicyStream()
+}
+
 // LoadPolicyFast is not blocked when adapter calls LoadPolicy.
 func (e *SyncedEnforcer) LoadPolicyFast() error {
 	e.m.RLock()
@@ -183,6 +198,13 @@ func (e *SyncedEnforcer) SavePolicy() error {
 	return e.Enforcer.SavePolicy()
 }
 
+// SaveFilteredPolicy saves the current policy, loaded with LoadFilteredPolicy, back to the adapter.
+func (e *SyncedEnforcer) SaveFilteredPolicy(filter interface{}) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.SaveFilteredPolicy(filter)
+}
+
 // BuildRoleLinks manually rebuild the role inheritance relations.
 func (e *SyncedEnforcer) BuildRoleLinks() error {
 	e.m.Lock()
@@ -190,6 +212,13 @@ func (e *SyncedEnforcer) BuildRoleLinks() error {
 	return e.Enforcer.BuildRoleLinks()
 }
 
+// BuildNamedRoleLinks manually rebuild the role inheritance relations of the grouping policy type ptype only.
+func (e *SyncedEnforcer) BuildNamedRoleLinks(ptype string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.BuildNamedRoleLinks(ptype)
+}
+
 // Enforce decides whether a "subject" can access a "object" with the operation "action", input parameters are usually: (sub, obj, act).
 func (e *SyncedEnforcer) Enforce(rvals ...interface{}) (bool, error) {
 	e.m.RLock()
@@ -204,6 +233,45 @@ func (e *SyncedEnforcer) EnforceWithMatcher(matcher string, rvals ...interface{}
 	return e.Enforcer.EnforceWithMatcher(matcher, rvals...)
 }
 
+// EnforceWithMatchers decides whether a "subject" can access a "object" with the operation "action" with several matchers,
+// whose results are combined by combine.
+func (e *SyncedEnforcer) EnforceWithMatchers(combine CombineMode, matchers []string, rvals ...interface{}) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceWithMatchers(combine, matchers, rvals...)
+}
+
+// EnforceWithReason decides whether a "subject" can access a "object" with the operation "action",
+// and also returns the reason of the decision.
+func (e *SyncedEnforcer) EnforceWithReason(rvals ...interface{}) (bool, EnforceReason, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceWithReason(rvals...)
+}
+
+// EnforceWithStats decides whether a "subject" can access a "object" with the operation "action",
+// and also returns the statistics of the evaluation.
+func (e *SyncedEnforcer) EnforceWithStats(rvals ...interface{}) (bool, EnforceStats, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceWithStats(rvals...)
+}
+
+// EnforceWithContext decides whether a "subject" can access a "object" with the operation "action",
+// but stops and returns the error of ctx once ctx is done.
+func (e *SyncedEnforcer) EnforceWithContext(ctx context.Context, rvals ...interface{}) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceWithContext(ctx, rvals...)
+}
+
+// EnforceAllMatches returns all the policy rules matching the request, evaluating every rule, for diagnostics.
+func (e *SyncedEnforcer) EnforceAllMatches(rvals ...interface{}) ([][]string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceAllMatches(rvals...)
+}
+
 // EnforceEx explain enforcement by informing matched rules
 func (e *SyncedEnforcer) EnforceEx(rvals ...interface{}) (bool, []string, error) {
 	e.m.RLock()
@@ -211,6 +279,13 @@ func (e *SyncedEnforcer) EnforceEx(rvals ...interface{}) (bool, []string, error)
 	return e.Enforcer.EnforceEx(rvals...)
 }
 
+// EnforceExExplained explain enforcement by informing the matched rule together with its policy type and position.
+func (e *SyncedEnforcer) EnforceExExplained(rvals ...interface{}) (bool, Explanation, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.EnforceExExplained(rvals...)
+}
+
 // EnforceExWithMatcher use a custom matcher and explain enforcement by informing matched rules
 func (e *SyncedEnforcer) EnforceExWithMatcher(matcher string, rvals ...interface{}) (bool, []string, error) {
 	e.m.RLock()
@@ -225,6 +300,27 @@ func (e *SyncedEnforcer) BatchEnforce(requests [][]interface{}) ([]bool, error)
 	return e.Enforcer.BatchEnforce(requests)
 }
 
+// BatchEnforceAll enforce in batches, evaluating every request and reporting the error of each one.
+func (e *SyncedEnforcer) BatchEnforceAll(requests [][]interface{}) ([]bool, []error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.BatchEnforceAll(requests)
+}
+
+// BatchEnforceWithContext enforce in batches, but stops and returns the error of ctx once ctx is done.
+func (e *SyncedEnforcer) BatchEnforceWithContext(ctx context.Context, requests [][]interface{}) ([]bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.BatchEnforceWithContext(ctx, requests)
+}
+
+// BatchEnforceParallel enforce in batches over at most workers goroutines, keeping the order of the requests.
+func (e *SyncedEnforcer) BatchEnforceParallel(requests [][]interface{}, workers int) ([]bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.BatchEnforceParallel(requests, workers)
+}
+
 // BatchEnforceWithMatcher enforce with matcher in batches
 func (e *SyncedEnforcer) BatchEnforceWithMatcher(matcher string, requests [][]interface{}) ([]bool, error) {
 	e.m.RLock()
@@ -302,6 +398,20 @@ func (e *SyncedEnforcer) GetFilteredPolicy(fieldIndex int, fieldValues ...string
 	return e.Enforcer.GetFilteredPolicy(fieldIndex, fieldValues...)
 }
 
+// GetFilteredPolicyWithIndices gets all the authorization rules in the policy together with their indices, field filters can be specified.
+func (e *SyncedEnforcer) GetFilteredPolicyWithIndices(fieldIndex int, fieldValues ...string) ([][]string, []int, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetFilteredPolicyWithIndices(fieldIndex, fieldValues...)
+}
+
+// GetFilteredNamedPolicyWithIndices gets all the authorization rules in the named policy together with their indices, field filters can be specified.
+func (e *SyncedEnforcer) GetFilteredNamedPolicyWithIndices(ptype string, fieldIndex int, fieldValues ...string) ([][]string, []int, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetFilteredNamedPolicyWithIndices(ptype, fieldIndex, fieldValues...)
+}
+
 // GetNamedPolicy gets all the authorization rules in the named policy.
 func (e *SyncedEnforcer) GetNamedPolicy(ptype string) [][]string {
 	e.m.RLock()
@@ -309,6 +419,49 @@ func (e *SyncedEnforcer) GetNamedPolicy(ptype string) [][]string {
 	return e.Enforcer.GetNamedPolicy(ptype)
 }
 
+// RangePolicy calls fn for each rule of the named policy or grouping policy ptype until fn returns false,
+// without copying the rules. The read lock is held during the iteration, so fn must not change the policy.
+func (e *SyncedEnforcer) RangePolicy(ptype string, fn func(rule []string) bool) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	e.Enforcer.RangePolicy(ptype, fn)
+}
+
+// CountPolicy returns the number of rules of the ptype in the sec section in the storage, without loading them.
+func (e *SyncedEnforcer) CountPolicy(sec string, ptype string) (int, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.CountPolicy(sec, ptype)
+}
+
+// CountLoadedPolicy returns the number of rules of the ptype in the sec section in the loaded policy.
+func (e *SyncedEnforcer) CountLoadedPolicy(sec string, ptype string) int {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.CountLoadedPolicy(sec, ptype)
+}
+
+// GetPolicySortedByPriority gets all the authorization rules in the named policy in their effective priority order.
+func (e *SyncedEnforcer) GetPolicySortedByPriority(ptype string) ([][]string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetPolicySortedByPriority(ptype)
+}
+
+// GetSubjectHierarchyOrder gets the indices of the authorization rules in the named policy in their subjectPriority order.
+func (e *SyncedEnforcer) GetSubjectHierarchyOrder(ptype string) ([]int, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetSubjectHierarchyOrder(ptype)
+}
+
+// GetSubjectHierarchyDepths gets the depth of each subject in the role hierarchy used by the subjectPriority effect.
+func (e *SyncedEnforcer) GetSubjectHierarchyDepths() (map[string]int, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetSubjectHierarchyDepths()
+}
+
 // GetFilteredNamedPolicy gets all the authorization rules in the named policy, field filters can be specified.
 func (e *SyncedEnforcer) GetFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) [][]string {
 	e.m.RLock()
@@ -323,6 +476,13 @@ func (e *SyncedEnforcer) GetGroupingPolicy() [][]string {
 	return e.Enforcer.GetGroupingPolicy()
 }
 
+// GetAllGroupingPolicies gets the role inheritance rules of all the grouping policy types, keyed by ptype.
+func (e *SyncedEnforcer) GetAllGroupingPolicies() map[string][][]string {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetAllGroupingPolicies()
+}
+
 // GetFilteredGroupingPolicy gets all the role inheritance rules in the policy, field filters can be specified.
 func (e *SyncedEnforcer) GetFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) [][]string {
 	e.m.RLock()
@@ -492,6 +652,28 @@ func (e *SyncedEnforcer) RemoveFilteredNamedPolicy(ptype string, fieldIndex int,
 	return e.Enforcer.RemoveFilteredNamedPolicy(ptype, fieldIndex, fieldValues...)
 }
 
+// RemoveFilteredNamedPolicyReturnsEffects removes authorization rules from the current named policy, field filters can be specified.
+// The removed rules are returned as well.
+func (e *SyncedEnforcer) RemoveFilteredNamedPolicyReturnsEffects(ptype string, fieldIndex int, fieldValues ...string) (bool, [][]string, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.RemoveFilteredNamedPolicyReturnsEffects(ptype, fieldIndex, fieldValues...)
+}
+
+// RemoveFilteredPolicyMatch removes the authorization rules whose field at fieldIndex satisfies matcher.
+func (e *SyncedEnforcer) RemoveFilteredPolicyMatch(fieldIndex int, matcher func(string) bool) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.RemoveFilteredPolicyMatch(fieldIndex, matcher)
+}
+
+// RemoveFilteredNamedPolicyMatch removes the authorization rules of the named policy whose field at fieldIndex satisfies matcher.
+func (e *SyncedEnforcer) RemoveFilteredNamedPolicyMatch(ptype string, fieldIndex int, matcher func(string) bool) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.RemoveFilteredNamedPolicyMatch(ptype, fieldIndex, matcher)
+}
+
 // HasGroupingPolicy determines whether a role inheritance rule exists.
 func (e *SyncedEnforcer) HasGroupingPolicy(params ...interface{}) bool {
 	e.m.RLock()
@@ -633,6 +815,20 @@ func (e *SyncedEnforcer) AddFunction(name string, function govaluate.ExpressionF
 	e.Enforcer.AddFunction(name, function)
 }
 
+// AddFunctionWithContext adds a customized function which also receives the whole request.
+func (e *SyncedEnforcer) AddFunctionWithContext(name string, function ContextFunction) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.AddFunctionWithContext(name, function)
+}
+
+// SetExpressionCompiler sets the compiler of the matcher expressions.
+func (e *SyncedEnforcer) SetExpressionCompiler(compiler ExpressionCompiler) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.Enforcer.SetExpressionCompiler(compiler)
+}
+
 func (e *SyncedEnforcer) SelfAddPolicy(sec string, ptype string, rule []string) (bool, error) {
 	e.m.Lock()
 	defer e.m.Unlock()
@@ -680,3 +876,66 @@ func (e *SyncedEnforcer) SelfUpdatePolicies(sec string, ptype string, oldRules,
 	defer e.m.Unlock()
 	return e.Enforcer.SelfUpdatePolicies(sec, ptype, oldRules, newRules)
 }
+
+// AdapterAddPolicy adds a rule to the adapter and notifies the watcher, without changing the current policy.
+func (e *SyncedEnforcer) AdapterAddPolicy(sec string, ptype string, rule []string) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.AdapterAddPolicy(sec, ptype, rule)
+}
+
+// AdapterAddPolicies adds rules to the adapter and notifies the watcher, without changing the current policy.
+func (e *SyncedEnforcer) AdapterAddPolicies(sec string, ptype string, rules [][]string) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.AdapterAddPolicies(sec, ptype, rules)
+}
+
+// AdapterRemovePolicy removes a rule from the adapter and notifies the watcher, without changing the current policy.
+func (e *SyncedEnforcer) AdapterRemovePolicy(sec string, ptype string, rule []string) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.AdapterRemovePolicy(sec, ptype, rule)
+}
+
+// AdapterRemovePolicies removes rules from the adapter and notifies the watcher, without changing the current policy.
+func (e *SyncedEnforcer) AdapterRemovePolicies(sec string, ptype string, rules [][]string) (bool, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.AdapterRemovePolicies(sec, ptype, rules)
+}
+
+// DryRun previews the changes of the management calls made by fn on a clone of the enforcer, see Enforcer.DryRun.
+func (e *SyncedEnforcer) DryRun(fn func(c *Enforcer) error) (*PolicyChangeSet, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.DryRun(fn)
+}
+
+// Validate checks the model and the policy statically, see Enforcer.Validate.
+func (e *SyncedEnforcer) Validate() error {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.Validate()
+}
+
+// Undo reverts the last management operation recorded by the operation journal, see Enforcer.Undo.
+func (e *SyncedEnforcer) Undo() (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.Undo()
+}
+
+// Redo replays the last management operation reverted by Undo, see Enforcer.Redo.
+func (e *SyncedEnforcer) Redo() (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.Redo()
+}
+
+// UpdateMatcher replaces a matcher of the model while keeping the policy in memory, see Enforcer.UpdateMatcher.
+func (e *SyncedEnforcer) UpdateMatcher(mType string, expr string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.UpdateMatcher(mType, expr)
+}
@@ -15,12 +15,28 @@
 package casbin
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/casbin/casbin/v2/effector"
+	Err "github.com/casbin/casbin/v2/errors"
 	"github.com/casbin/casbin/v2/model"
 	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	stringadapter "github.com/casbin/casbin/v2/persist/string-adapter"
+	defaultrolemanager "github.com/casbin/casbin/v2/rbac/default-role-manager"
 	"github.com/casbin/casbin/v2/util"
+
+	"github.com/Knetic/govaluate"
 )
 
 func TestKeyMatchModelInMemory(t *testing.T) {
@@ -274,6 +290,110 @@ func TestEnableEnforce(t *testing.T) {
 	testEnforce(t, e, "bob", "data2", "write", true)
 }
 
+func testEnforceWithReason(t *testing.T, e *Enforcer, sub interface{}, obj interface{}, act string, res bool, reason EnforceReason) {
+	t.Helper()
+	myRes, myReason, err := e.EnforceWithReason(sub, obj, act)
+	if err != nil {
+		t.Errorf("Enforce Error: %s", err)
+		return
+	}
+	if myRes != res || myReason != reason {
+		t.Errorf("%s, %v, %s: %t, %d, supposed to be %t, %d", sub, obj, act, myRes, myReason, res, reason)
+	}
+}
+
+func TestEnforceWithReason(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceWithReason(t, e, "alice", "data1", "read", true, ReasonEvaluated)
+	testEnforceWithReason(t, e, "alice", "data1", "write", false, ReasonNoMatchingRule)
+
+	e, _ = NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+	testEnforceWithReason(t, e, "alice", "data2", "write", false, ReasonDeniedByRule)
+	testEnforceWithReason(t, e, "alice", "data2", "read", true, ReasonEvaluated)
+	testEnforceWithReason(t, e, "bob", "data1", "read", false, ReasonNoMatchingRule)
+
+	e.EnableEnforce(false)
+	testEnforceWithReason(t, e, "alice", "data1", "read", true, ReasonEnforceDisabled)
+	testEnforceWithReason(t, e, "alice", "data1", "write", true, ReasonEnforceDisabled)
+}
+
+func TestEnforceAllMatches(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+	_, _ = e.AddPolicy("alice", "data2", "write", "allow")
+
+	testEnforceAllMatches := func(sub, obj, act string, res [][]string) {
+		t.Helper()
+		matches, err := e.EnforceAllMatches(sub, obj, act)
+		if err != nil || !util.Array2DEquals(matches, res) {
+			t.Errorf("%s, %s, %s: %v, %v, supposed to be %v", sub, obj, act, matches, err, res)
+		}
+	}
+	// the evaluation does not stop at the deny rule deciding the request.
+	testEnforceAllMatches("alice", "data2", "write", [][]string{
+		{"data2_admin", "data2", "write", "allow"},
+		{"alice", "data2", "write", "deny"},
+		{"alice", "data2", "write", "allow"},
+	})
+	testEnforceAllMatches("alice", "data2", "read", [][]string{{"data2_admin", "data2", "read", "allow"}})
+	testEnforceAllMatches("bob", "data1", "read", [][]string{})
+
+	// the normal enforcement is unchanged.
+	testEnforce(t, e, "alice", "data2", "write", false)
+	res, explain, _ := e.EnforceEx("alice", "data2", "write")
+	if res || !util.ArrayEquals(explain, []string{"alice", "data2", "write", "deny"}) {
+		t.Errorf("EnforceEx: %t, %v, supposed to be denied by the deny rule", res, explain)
+	}
+
+	if _, err := e.EnforceAllMatches("alice", "data2"); err == nil {
+		t.Error("EnforceAllMatches() should fail on an invalid request")
+	}
+
+	// neither the enforce hook nor the fallback decider is called for the diagnostics.
+	hooked, fallbacks := 0, 0
+	e.SetEnforceHook(func(rvals []interface{}, result bool, explain []string, err error, disabled bool) {
+		hooked++
+	})
+	e.SetFallbackDecider(func(rvals []interface{}) (bool, error) {
+		fallbacks++
+		return true, nil
+	})
+	testEnforceAllMatches("bob", "data1", "read", [][]string{})
+	testEnforceAllMatches("alice", "data2", "read", [][]string{{"data2_admin", "data2", "read", "allow"}})
+	if hooked != 0 || fallbacks != 0 {
+		t.Errorf("EnforceAllMatches() called the hook %d times and the fallback decider %d times", hooked, fallbacks)
+	}
+	testEnforce(t, e, "bob", "data1", "read", true)
+	if hooked != 1 || fallbacks != 1 {
+		t.Errorf("Enforce() called the hook %d times and the fallback decider %d times, supposed to be once", hooked, fallbacks)
+	}
+}
+
+func TestEnforceWithStats(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforceWithStats := func(sub, obj, act string, res bool, stats EnforceStats) {
+		t.Helper()
+		myRes, myStats, err := e.EnforceWithStats(sub, obj, act)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if myRes != res || myStats.RulesScanned != stats.RulesScanned || myStats.Matched != stats.Matched || myStats.Duration < 0 {
+			t.Errorf("%s, %s, %s: %t, %+v, supposed to be %t, %+v", sub, obj, act, myRes, myStats, res, stats)
+		}
+	}
+
+	// the evaluation stops at the first matching allow rule.
+	testEnforceWithStats("alice", "data1", "read", true, EnforceStats{RulesScanned: 1, Matched: true})
+	testEnforceWithStats("bob", "data2", "write", true, EnforceStats{RulesScanned: 2, Matched: true})
+	testEnforceWithStats("alice", "data2", "write", false, EnforceStats{RulesScanned: 2, Matched: false})
+
+	e.EnableEnforce(false)
+	if _, stats, _ := e.EnforceWithStats("alice", "data2", "write"); stats.RulesScanned != 0 {
+		t.Errorf("EnforceWithStats() with enforcement disabled: %+v", stats)
+	}
+}
+
 func TestEnableLog(t *testing.T) {
 	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv", true)
 	// The log is enabled by default, so the above is the same with:
@@ -351,6 +471,149 @@ func TestInitWithAdapter(t *testing.T) {
 	testEnforce(t, e, "bob", "data2", "write", true)
 }
 
+func TestInitWithModelReader(t *testing.T) {
+	modelText, _ := ioutil.ReadFile("examples/basic_model.conf")
+	e, err := NewEnforcer(strings.NewReader(string(modelText)), fileadapter.NewAdapter("examples/basic_policy.csv"))
+	if err != nil {
+		t.Fatalf("unexpected error in NewEnforcer: %v", err)
+	}
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data1", "write", false)
+	testEnforce(t, e, "bob", "data2", "write", true)
+
+	e, _ = NewEnforcer(strings.NewReader(string(modelText)))
+	_, _ = e.AddPolicy("alice", "data1", "read")
+	testEnforce(t, e, "alice", "data1", "read", true)
+}
+
+func TestUpdateMatcher(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	testEnforce(t, e, "alice", "data2", "read", true)
+
+	// the policy and the role links are kept.
+	if err := e.UpdateMatcher("m", "r.sub == p.sub && r.obj == p.obj && r.act == p.act"); err != nil {
+		t.Fatalf("UpdateMatcher(): %v", err)
+	}
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data2", "read", false)
+	if err := e.UpdateMatcher("m", "g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act # with the roles"); err != nil {
+		t.Fatalf("UpdateMatcher() with g(): %v", err)
+	}
+	testEnforce(t, e, "alice", "data2", "read", true)
+
+	// an invalid matcher is rejected and the current one is kept.
+	for _, expr := range []string{
+		"r.sub == p.subject",
+		"r2.sub == p.sub",
+		"r.sub == p.sub &&",
+		"unknownFunction(r.sub, p.sub)",
+		"",
+	} {
+		if err := e.UpdateMatcher("m", expr); err == nil {
+			t.Errorf("UpdateMatcher(%q) should fail", expr)
+		}
+	}
+	testEnforce(t, e, "alice", "data2", "read", true)
+
+	if err := e.UpdateMatcher("m2", "r.sub == p.sub"); err == nil {
+		t.Error("UpdateMatcher() should fail with an unknown matcher")
+	}
+
+	e.SetLenientMatching(true)
+	if err := e.UpdateMatcher("m", "g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act && p.subject == ''"); err != nil {
+		t.Errorf("UpdateMatcher() with lenient matching: %v", err)
+	}
+	testEnforce(t, e, "alice", "data2", "read", true)
+}
+
+func TestValidate(t *testing.T) {
+	newModel := func(policyDef, effect, matcher string) model.Model {
+		m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = ` + policyDef + `
+
+[policy_effect]
+e = ` + effect + `
+
+[matchers]
+m = ` + matcher + `
+`)
+		return m
+	}
+	allow := "some(where (p.eft == allow))"
+
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate(): %v", err)
+	}
+
+	// the tokens in strings and the built-in policy parameters are not checked.
+	e, _ = NewEnforcer(newModel("sub, obj, act", allow, "r.sub == p.sub && r.obj == 'p.dom' && p_index >= 0"))
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate(): %v", err)
+	}
+
+	for _, m := range []model.Model{
+		newModel("sub, obj, act", allow, "r.sub == p.sub && r.dom == p.obj"),
+		newModel("sub, obj, act", allow, "r.sub == p.sub && p.dom == 'domain1'"),
+		newModel("sub, obj, act", allow, "r.sub == p2.sub"),
+		newModel("sub, obj, act", "some(where (p.eft == allow)) || true", "r.sub == p.sub"),
+	} {
+		e, _ = NewEnforcer(m)
+		if err := e.Validate(); err == nil {
+			t.Errorf("Validate() of the matcher %s with the effect %s should fail", m["m"]["m"].Value, m["e"]["e"].Value)
+		}
+	}
+
+	e, _ = NewEnforcer(newModel("sub, obj, act", allow, "r.sub == p.sub && r.dom == p.obj"))
+	e.SetLenientMatching(true)
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate() with lenient matching: %v", err)
+	}
+
+	e, _ = NewEnforcer(newModel("sub, obj, act", allow, "r.sub == p.sub"))
+	e.GetModel().AddPolicy("p", "p", []string{"alice", "data1"})
+	if err := e.Validate(); err == nil {
+		t.Error("Validate() with a policy rule missing a field should fail")
+	}
+}
+
+func TestAdapterWithSeparator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.csv")
+	policy := "p|alice|data1|read\np|bob|\"data|2\"|write\n# p|carol|data1|read\ng|alice|data2_admin"
+	if err = ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEnforcer("examples/rbac_model.conf", fileadapter.NewAdapterWithSeparator(path, '|'))
+	if err != nil {
+		t.Fatalf("unexpected error in NewEnforcer: %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data|2", "write"}})
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "data2_admin"}})
+
+	_, _ = e.AddPolicy("carol", "a, \"b\"|c", "read")
+	if err = e.SavePolicy(); err != nil {
+		t.Fatalf("unexpected error in SavePolicy: %v", err)
+	}
+	saved, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(saved), `p|carol|"a, ""b""|c"|read`) {
+		t.Errorf("SavePolicy() with separator '|': %q", saved)
+	}
+
+	e, _ = NewEnforcer("examples/rbac_model.conf", fileadapter.NewAdapterWithSeparator(path, '|'))
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data|2", "write"}, {"carol", "a, \"b\"|c", "read"}})
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "data2_admin"}})
+}
+
 func TestRoleLinks(t *testing.T) {
 	e, _ := NewEnforcer("examples/rbac_model.conf")
 	e.EnableAutoBuildRoleLinks(false)
@@ -358,6 +621,54 @@ func TestRoleLinks(t *testing.T) {
 	_, _ = e.Enforce("user501", "data9", "read")
 }
 
+func TestBuildIncrementalRoleLinksWithMultipleGroupingTypes(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_resource_roles_model.conf", "examples/rbac_with_resource_roles_policy.csv")
+
+	_, _ = e.AddNamedGroupingPolicy("g2", "data3", "data_group")
+	if ok, _ := e.GetNamedRoleManager("g2").HasLink("data3", "data_group"); !ok {
+		t.Error("the g2 rule should be added to the role manager of g2")
+	}
+	if ok, _ := e.GetRoleManager().HasLink("data3", "data_group"); ok {
+		t.Error("the g2 rule should not be added to the role manager of g")
+	}
+	if ok, _ := e.GetRoleManager().HasLink("alice", "data_group_admin"); !ok {
+		t.Error("the role links of g should be kept")
+	}
+	testEnforce(t, e, "alice", "data3", "write", true)
+
+	_, _ = e.RemoveNamedGroupingPolicy("g2", "data1", "data_group")
+	testEnforce(t, e, "alice", "data1", "write", false)
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data2", "write", true)
+
+	if err := e.BuildIncrementalRoleLinks(model.PolicyAdd, "g3", [][]string{{"data4", "data_group"}}); err == nil {
+		t.Error("BuildIncrementalRoleLinks() should fail for an unknown grouping policy type")
+	}
+}
+
+func TestBuildNamedRoleLinks(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_resource_roles_model.conf", "examples/rbac_with_resource_roles_policy.csv")
+
+	// change the policy behind the role managers
+	e.GetModel().AddPolicy("g", "g", []string{"bob", "data_group_admin"})
+	e.GetModel().AddPolicy("g", "g2", []string{"data3", "data_group"})
+
+	if err := e.BuildNamedRoleLinks("g2"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := e.GetNamedRoleManager("g2").HasLink("data3", "data_group"); !ok {
+		t.Error("the role links of g2 should be rebuilt")
+	}
+	if ok, _ := e.GetRoleManager().HasLink("bob", "data_group_admin"); ok {
+		t.Error("the role links of g should not be rebuilt")
+	}
+	testEnforce(t, e, "alice", "data3", "write", true)
+
+	if err := e.BuildNamedRoleLinks("g3"); err == nil {
+		t.Error("BuildNamedRoleLinks() should fail for an unknown grouping policy type")
+	}
+}
+
 func TestEnforceConcurrency(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -393,6 +704,23 @@ func TestGetAndSetModel(t *testing.T) {
 	testEnforce(t, e, "root", "data1", "read", true)
 }
 
+func TestSetModelWithValidation(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	m, _ := model.NewModelFromFile("examples/rbac_with_domains_model.conf")
+	if err := e.SetModelWithValidation(m); err == nil {
+		t.Error("SetModelWithValidation() should fail when the loaded policy does not fit the model")
+	}
+	// the current model is kept
+	testEnforce(t, e, "alice", "data1", "read", true)
+
+	m, _ = model.NewModelFromFile("examples/basic_with_root_model.conf")
+	if err := e.SetModelWithValidation(m); err != nil {
+		t.Errorf("SetModelWithValidation() should succeed for a compatible model: %s", err)
+	}
+	testEnforce(t, e, "root", "data2", "write", true)
+}
+
 func TestGetAndSetAdapterInMem(t *testing.T) {
 	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
 	e2, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_inverse_policy.csv")
@@ -516,6 +844,88 @@ func TestBatchEnforce(t *testing.T) {
 	testBatchEnforce(t, e, [][]interface{}{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"jack", "data3", "read"}}, results)
 }
 
+func TestBatchEnforceAll(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	requests := [][]interface{}{
+		{"alice", "data1", "read"},
+		{"alice", "data1"},
+		{"bob", "data2", "write"},
+		{"jack", "data3", "read"},
+	}
+
+	// BatchEnforce stops at the invalid request.
+	if results, err := e.BatchEnforce(requests); err == nil || len(results) != 1 {
+		t.Errorf("BatchEnforce(): %v, %v, supposed to stop at the invalid request", results, err)
+	}
+
+	results, errs := e.BatchEnforceAll(requests)
+	if !reflect.DeepEqual(results, []bool{true, false, true, false}) {
+		t.Errorf("BatchEnforceAll(): %v, supposed to be %v", results, []bool{true, false, true, false})
+	}
+	if len(errs) != len(requests) || errs[0] != nil || !errors.Is(errs[1], Err.ErrInvalidRequestSize) || errs[2] != nil || errs[3] != nil {
+		t.Errorf("BatchEnforceAll(): %v, supposed to fail on the invalid request only", errs)
+	}
+}
+
+func TestBatchEnforceParallel(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	var requests [][]interface{}
+	var expected []bool
+	for i := 0; i < 100; i++ {
+		requests = append(requests,
+			[]interface{}{"alice", "data2", "read"},
+			[]interface{}{"bob", "data1", "read"},
+			[]interface{}{"bob", "data2", "write"})
+		expected = append(expected, true, false, true)
+	}
+
+	for _, workers := range []int{0, 1, 4, 1000} {
+		results, err := e.BatchEnforceParallel(requests, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Errorf("BatchEnforceParallel with %d workers: %v, supposed to be %v", workers, results, expected)
+		}
+	}
+
+	requests = append(requests, []interface{}{"alice", "data1"})
+	if _, err := e.BatchEnforceParallel(requests, 4); err == nil {
+		t.Error("BatchEnforceParallel() should fail on an invalid request")
+	}
+}
+
+func TestLoadPolicyWithConcurrentEnforce(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				testEnforce(t, e, "alice", "data2", "read", true)
+				testEnforce(t, e, "bob", "data1", "read", false)
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := e.LoadPolicy(); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
 func TestSubjectPriority(t *testing.T) {
 	e, _ := NewEnforcer("examples/subject_priority_model.conf", "examples/subject_priority_policy.csv")
 	testBatchEnforce(t, e, [][]interface{}{
@@ -555,6 +965,64 @@ func TestSubjectPriorityInFilter(t *testing.T) {
 	})
 }
 
+func TestGetSubjectHierarchyOrder(t *testing.T) {
+	e, _ := NewEnforcer("examples/subject_priority_model.conf", "examples/subject_priority_policy.csv")
+
+	// the policy is already sorted when loaded.
+	order, err := e.GetSubjectHierarchyOrder("p")
+	if err != nil || !reflect.DeepEqual(order, []int{0, 1, 2, 3, 4, 5}) {
+		t.Errorf("GetSubjectHierarchyOrder(): %v, %v", order, err)
+	}
+
+	// the rules added afterwards are not sorted.
+	_, _ = e.AddGroupingPolicy("tom", "jane")
+	_, _ = e.AddPolicy("tom", "data1", "read", "deny")
+	order, _ = e.GetSubjectHierarchyOrder("p")
+	if !reflect.DeepEqual(order, []int{6, 0, 1, 2, 3, 4, 5}) {
+		t.Errorf("GetSubjectHierarchyOrder() after AddPolicy: %v", order)
+	}
+	depths, err := e.GetSubjectHierarchyDepths()
+	if err != nil || !reflect.DeepEqual(depths, map[string]int{
+		"root": 0, "admin": 1, "editor": 2, "subscriber": 2, "jane": 3, "alice": 3, "tom": 4,
+	}) {
+		t.Errorf("GetSubjectHierarchyDepths(): %v, %v", depths, err)
+	}
+
+	if _, err = e.GetSubjectHierarchyOrder("p2"); err == nil {
+		t.Error("GetSubjectHierarchyOrder() should fail with an unknown policy type")
+	}
+
+	e, _ = NewEnforcer("examples/subject_priority_model_with_domain.conf", "examples/subject_priority_policy_with_domain.csv")
+	depths, _ = e.GetSubjectHierarchyDepths()
+	if depths["domain1::alice"] != 1 || depths["domain1::admin"] != 0 {
+		t.Errorf("GetSubjectHierarchyDepths() with domains: %v", depths)
+	}
+
+	// the original order is kept without a role definition.
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[policy_effect]
+e = subjectPriority(p.eft) || deny
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewEnforcer(m)
+	_, _ = e.AddPolicies([][]string{{"alice", "data1", "read", "allow"}, {"bob", "data1", "read", "deny"}})
+	if err = e.GetModel().SortPoliciesBySubjectHierarchy(); err != nil {
+		t.Errorf("SortPoliciesBySubjectHierarchy() without a role definition: %v", err)
+	}
+	order, err = e.GetSubjectHierarchyOrder("p")
+	if err != nil || !reflect.DeepEqual(order, []int{0, 1}) {
+		t.Errorf("GetSubjectHierarchyOrder() without a role definition: %v, %v", order, err)
+	}
+}
+
 func TestMultiplePolicyDefinitions(t *testing.T) {
 	e, _ := NewEnforcer("examples/multiple_policy_definitions_model.conf", "examples/multiple_policy_definitions_policy.csv")
 	enforceContext := NewEnforceContext("2")
@@ -568,55 +1036,456 @@ func TestMultiplePolicyDefinitions(t *testing.T) {
 	})
 }
 
-func TestPriorityExplicit(t *testing.T) {
-	e, _ := NewEnforcer("examples/priority_model_explicit.conf", "examples/priority_policy_explicit.csv")
-	testBatchEnforce(t, e, [][]interface{}{
-		{"alice", "data1", "write"},
-		{"alice", "data1", "read"},
-		{"bob", "data2", "read"},
-		{"bob", "data2", "write"},
-		{"data1_deny_group", "data1", "read"},
-		{"data1_deny_group", "data1", "write"},
-		{"data2_allow_group", "data2", "read"},
-		{"data2_allow_group", "data2", "write"},
-	}, []bool{
-		true, true, false, true, false, false, true, true,
-	})
+func TestEnforceWithMatchers(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+r2 = sub, obj
 
-	_, err := e.AddPolicy("1", "bob", "data2", "write", "deny")
-	if err != nil {
-		t.Fatalf("Add Policy: %v", err)
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+m2 = r2.obj == 'data1'
+m3 = r.act == 'read'
+`)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy("alice", "data1", "read")
+	_, _ = e.AddPolicy("alice", "data1", "write")
+
+	testEnforceWithMatchers := func(combine CombineMode, matchers []string, sub, obj, act string, res bool) {
+		t.Helper()
+		myRes, err := e.EnforceWithMatchers(combine, matchers, sub, obj, act)
+		if err != nil || myRes != res {
+			t.Errorf("%v %v, %s, %s, %s: %t, %v, supposed to be %t", combine, matchers, sub, obj, act, myRes, err, res)
+		}
 	}
 
-	testBatchEnforce(t, e, [][]interface{}{
-		{"alice", "data1", "write"},
-		{"alice", "data1", "read"},
-		{"bob", "data2", "read"},
-		{"bob", "data2", "write"},
-		{"data1_deny_group", "data1", "read"},
-		{"data1_deny_group", "data1", "write"},
-		{"data2_allow_group", "data2", "read"},
-		{"data2_allow_group", "data2", "write"},
-	}, []bool{
-		true, true, false, false, false, false, true, true,
-	})
-}
+	// m3 is evaluated with r, p and e as r3, p3 and e3 are not defined.
+	both := []string{"m", "m3"}
+	testEnforceWithMatchers(CombineAnd, both, "alice", "data1", "read", true)
+	testEnforceWithMatchers(CombineAnd, both, "alice", "data1", "write", false)
+	testEnforceWithMatchers(CombineAnd, both, "bob", "data1", "read", false)
+	testEnforceWithMatchers(CombineOr, both, "alice", "data1", "write", true)
+	testEnforceWithMatchers(CombineOr, both, "bob", "data1", "read", true)
+	testEnforceWithMatchers(CombineOr, both, "bob", "data1", "write", false)
+
+	// a matcher expression is evaluated like with EnforceWithMatcher.
+	testEnforceWithMatchers(CombineAnd, []string{"m3", "r.sub == 'bob'"}, "bob", "data1", "read", true)
+	testEnforceWithMatchers(CombineAnd, []string{"m3", "r.sub == 'bob'"}, "alice", "data1", "read", false)
+
+	// m2 uses r2, whose size doesn't fit the request.
+	if _, err := e.EnforceWithMatchers(CombineAnd, []string{"m", "m2"}, "alice", "data1", "read"); !errors.Is(err, Err.ErrInvalidRequestSize) {
+		t.Errorf("EnforceWithMatchers() with different request sizes: %v, supposed to be %v", err, Err.ErrInvalidRequestSize)
+	}
+	// the evaluation stops once the result is known.
+	testEnforceWithMatchers(CombineOr, []string{"m", "m2"}, "alice", "data1", "read", true)
 
-func TestFailedToLoadPolicy(t *testing.T) {
-	e, _ := NewEnforcer("examples/rbac_with_pattern_model.conf", "examples/rbac_with_pattern_policy.csv")
-	e.AddNamedMatchingFunc("g2", "matchingFunc", util.KeyMatch2)
-	testEnforce(t, e, "alice", "/book/1", "GET", true)
-	testEnforce(t, e, "bob", "/pen/3", "GET", true)
-	e.SetAdapter(fileadapter.NewAdapter("not found"))
-	_ = e.LoadPolicy()
-	testEnforce(t, e, "alice", "/book/1", "GET", true)
-	testEnforce(t, e, "bob", "/pen/3", "GET", true)
+	if _, err := e.EnforceWithMatchers(CombineAnd, nil, "alice", "data1", "read"); err == nil {
+		t.Error("EnforceWithMatchers() without matchers should fail")
+	}
+	if _, err := e.EnforceWithMatchers(CombineAnd, both, NewEnforceContext(""), "alice", "data1", "read"); err == nil {
+		t.Error("EnforceWithMatchers() with an enforce context should fail")
+	}
+
+	// m2 is evaluated with r2 and p2, and with e as e2 is not defined.
+	e, _ = NewEnforcer("examples/multiple_policy_definitions_model.conf", "examples/multiple_policy_definitions_policy.csv")
+	for _, tc := range []struct {
+		age int
+		res bool
+	}{{30, true}, {70, false}} {
+		res, err := e.EnforceWithMatchers(CombineAnd, []string{"m2"}, struct{ Age int }{Age: tc.age}, "/data1", "read")
+		if err != nil || res != tc.res {
+			t.Errorf("EnforceWithMatchers() with m2 and age %d: %t, %v, supposed to be %t", tc.age, res, err, tc.res)
+		}
+	}
 }
 
-func TestReloadPolicyWithFunc(t *testing.T) {
-	e, _ := NewEnforcer("examples/rbac_with_pattern_model.conf", "examples/rbac_with_pattern_policy.csv")
-	e.AddNamedMatchingFunc("g2", "matchingFunc", util.KeyMatch2)
-	testEnforce(t, e, "alice", "/book/1", "GET", true)
+func TestCaseInsensitiveMatching(t *testing.T) {
+	e, _ := NewEnforcer("examples/keymatch_model.conf", "examples/keymatch_policy.csv")
+	testEnforce(t, e, "Alice", "/alice_data/resource1", "GET", false)
+
+	e.EnableCaseInsensitiveMatching(true)
+	testEnforce(t, e, "Alice", "/alice_data/resource1", "GET", true)
+	testEnforce(t, e, "ALICE", "/alice_data/resource1", "POST", true)
+	// the arguments of keyMatch and regexMatch are not folded.
+	testEnforce(t, e, "Alice", "/Alice_data/resource1", "GET", false)
+	testEnforce(t, e, "Alice", "/alice_data/resource1", "get", false)
+
+	e.EnableCaseInsensitiveMatching(false)
+	testEnforce(t, e, "Alice", "/alice_data/resource1", "GET", false)
+
+	// the comparisons with literals, attributes and JSON fields are folded, not the ones inside literals.
+	e, _ = NewEnforcer("examples/abac_model.conf")
+	e.EnableCaseInsensitiveMatching(true)
+	testEnforceWithMatcher(t, e, "r.sub != 'BOB' && r.obj == 'Data1'", "alice", "data1", "read", true)
+	testEnforceWithMatcher(t, e, "r.sub != 'BOB' && r.obj == 'Data1'", "bob", "data1", "read", false)
+	testEnforceWithMatcher(t, e, "r.sub == r.obj.Owner", "Alice", testAttributer{"Owner": "alice"}, "read", true)
+	testEnforceWithMatcher(t, e, "r.act == 'r.sub == READ'", "alice", "data1", "r.sub == read", false)
+	e.EnableAcceptJsonRequest(true)
+	testEnforce(t, e, "alice", `{"Owner": "ALICE"}`, "read", true)
+	testEnforce(t, e, "alice", `{"owner": "alice"}`, "read", false)
+
+	if err := e.UpdateMatcher("m", "r.sub == r.obj.Owner || r.sub == 'Admin' || r.act in ('READ')"); err != nil {
+		t.Fatalf("UpdateMatcher(): %v", err)
+	}
+	testEnforce(t, e, "ADMIN", `{"Owner": "bob"}`, "write", true)
+	// the in operator is not folded.
+	testEnforce(t, e, "bob", `{"Owner": "alice"}`, "read", false)
+}
+
+func TestLenientMatching(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	matcher := "r.sub == p.sub && r.obj == p.obj && r.act == p.act && r.tenant == p.tenant"
+
+	if _, err := e.EnforceWithMatcher(matcher, "alice", "data1", "read"); err == nil {
+		t.Error("EnforceWithMatcher() with unknown tokens should fail by default")
+	}
+
+	e.SetLenientMatching(true)
+	testEnforceWithMatcher(t, e, matcher, "alice", "data1", "read", true)
+	testEnforceWithMatcher(t, e, matcher, "alice", "data1", "write", false)
+	testEnforceWithMatcher(t, e, "r.sub == p.sub && r2_tenant == ''", "alice", "data1", "read", true)
+
+	// a name which is not a request or policy token is still an error.
+	if _, err := e.EnforceWithMatcher("r.sub == p.sub && tenant == ''", "alice", "data1", "read"); err == nil {
+		t.Error("EnforceWithMatcher() with an unknown parameter should fail")
+	}
+
+	e.SetLenientMatching(false)
+	if _, err := e.EnforceWithMatcher(matcher, "alice", "data1", "read"); err == nil {
+		t.Error("EnforceWithMatcher() with unknown tokens should fail after SetLenientMatching(false)")
+	}
+}
+
+func TestEnforceExExplained(t *testing.T) {
+	e, _ := NewEnforcer("examples/multiple_policy_definitions_model.conf", "examples/multiple_policy_definitions_policy.csv")
+	enforceContext := NewEnforceContext("2")
+	enforceContext.EType = "e"
+
+	testEnforceExExplained := func(rvals []interface{}, res bool, explanation Explanation) {
+		t.Helper()
+		myRes, myExplanation, err := e.EnforceExExplained(rvals...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if myRes != res || !reflect.DeepEqual(myExplanation, explanation) {
+			t.Errorf("%v: %t, %+v, supposed to be %t, %+v", rvals, myRes, myExplanation, res, explanation)
+		}
+	}
+
+	testEnforceExExplained([]interface{}{"alice", "data2", "read"}, true,
+		Explanation{PType: "p", PolicyIndex: 0, Rule: []string{"data2_admin", "data2", "read"}})
+	testEnforceExExplained([]interface{}{"alice", "data2", "write"}, false,
+		Explanation{PType: "p", PolicyIndex: -1, Reason: ReasonNoMatchingRule, Effect: effector.Indeterminate})
+	testEnforceExExplained([]interface{}{enforceContext, struct{ Age int }{Age: 30}, "/data1", "read"}, true,
+		Explanation{PType: "p2", PolicyIndex: 0, Rule: []string{"r2.sub.Age > 18 && r2.sub.Age < 60", "/data1", "read", "allow"}})
+	testEnforceExExplained([]interface{}{enforceContext, struct{ Age int }{Age: 70}, "/data1", "read"}, false,
+		Explanation{PType: "p2", PolicyIndex: -1, Reason: ReasonDeniedByRule, Effect: effector.Indeterminate})
+	testEnforceExExplained([]interface{}{enforceContext, struct{ Age int }{Age: 10}, "/data1", "read"}, false,
+		Explanation{PType: "p2", PolicyIndex: -1, Reason: ReasonNoMatchingRule, Effect: effector.Indeterminate})
+}
+
+func TestEvaluateAllMatchers(t *testing.T) {
+	text :=
+		`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+p2 = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+m2 = r.sub == p2.sub && keyMatch(r.obj, p2.obj) && r.act == p2.act
+`
+	m, _ := model.NewModelFromString(text)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddNamedPolicy("p", "alice", "/data/1", "read")
+	_, _ = e.AddNamedPolicy("p2", "alice", "/data/*", "read")
+
+	testEvaluateAllMatchers := func(obj string, res map[string]bool) {
+		t.Helper()
+		myRes, err := e.EvaluateAllMatchers("alice", obj, "read")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, myRes) {
+			t.Errorf("Matchers for %s: %v, supposed to be %v", obj, myRes, res)
+		}
+	}
+	testEvaluateAllMatchers("/data/1", map[string]bool{"m": true, "m2": true})
+	testEvaluateAllMatchers("/data/2", map[string]bool{"m": false, "m2": true})
+	testEvaluateAllMatchers("/file/1", map[string]bool{"m": false, "m2": false})
+
+	if _, err := e.EvaluateAllMatchers("alice", "/data/1"); err == nil {
+		t.Error("EvaluateAllMatchers() should fail for an invalid request")
+	}
+}
+
+func TestPriorityExplicit(t *testing.T) {
+	e, _ := NewEnforcer("examples/priority_model_explicit.conf", "examples/priority_policy_explicit.csv")
+	testBatchEnforce(t, e, [][]interface{}{
+		{"alice", "data1", "write"},
+		{"alice", "data1", "read"},
+		{"bob", "data2", "read"},
+		{"bob", "data2", "write"},
+		{"data1_deny_group", "data1", "read"},
+		{"data1_deny_group", "data1", "write"},
+		{"data2_allow_group", "data2", "read"},
+		{"data2_allow_group", "data2", "write"},
+	}, []bool{
+		true, true, false, true, false, false, true, true,
+	})
+
+	_, err := e.AddPolicy("1", "bob", "data2", "write", "deny")
+	if err != nil {
+		t.Fatalf("Add Policy: %v", err)
+	}
+
+	testBatchEnforce(t, e, [][]interface{}{
+		{"alice", "data1", "write"},
+		{"alice", "data1", "read"},
+		{"bob", "data2", "read"},
+		{"bob", "data2", "write"},
+		{"data1_deny_group", "data1", "read"},
+		{"data1_deny_group", "data1", "write"},
+		{"data2_allow_group", "data2", "read"},
+		{"data2_allow_group", "data2", "write"},
+	}, []bool{
+		true, true, false, false, false, false, true, true,
+	})
+}
+
+func TestPriorityEffector(t *testing.T) {
+	e, _ := NewEnforcer("examples/priority_model_explicit.conf", "examples/priority_policy_explicit.csv")
+	e.SetEffector(effector.NewPriorityEffector())
+	testBatchEnforce(t, e, [][]interface{}{
+		{"alice", "data1", "write"},
+		{"alice", "data1", "read"},
+		{"bob", "data2", "read"},
+		{"bob", "data2", "write"},
+		{"data1_deny_group", "data1", "read"},
+		{"data2_allow_group", "data2", "read"},
+		{"carol", "data2", "read"},
+	}, []bool{
+		true, true, false, true, false, true, false,
+	})
+
+	// with the same priority, the rule coming first in the policy wins.
+	_, _ = e.AddPolicy("5", "carol", "data3", "read", "deny")
+	_, _ = e.AddPolicy("5", "carol", "data3", "read", "allow")
+	_, _ = e.AddPolicy("5", "carol", "data3", "write", "allow")
+	_, _ = e.AddPolicy("5", "carol", "data3", "write", "deny")
+	testEnforce(t, e, "carol", "data3", "read", false)
+	testEnforce(t, e, "carol", "data3", "write", true)
+
+	// a higher priority (lower value) rule overrides them.
+	_, _ = e.AddPolicy("2", "carol", "data3", "read", "allow")
+	testEnforce(t, e, "carol", "data3", "read", true)
+
+	res, explain, _ := e.EnforceEx("carol", "data3", "write")
+	if !res || !util.ArrayEquals(explain, []string{"5", "carol", "data3", "write", "allow"}) {
+		t.Errorf("EnforceEx: %t, %v, supposed to be allowed by the first rule of priority 5", res, explain)
+	}
+
+	e, _ = NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+	e.SetEffector(effector.NewPriorityEffector())
+	if _, err := e.Enforce("alice", "data1", "read"); err == nil {
+		t.Error("PriorityEffector should not support the allow-and-deny effect")
+	}
+}
+
+// countingEffector counts the merges of the effector it wraps, that is the policy rules evaluated.
+type countingEffector struct {
+	effector.Effector
+	merges int
+}
+
+func (e *countingEffector) MergeEffects(expr string, effects []effector.Effect, matches []float64, policyIndex int, policyLength int) (effector.Effect, int, error) {
+	e.merges++
+	return e.Effector.MergeEffects(expr, effects, matches, policyIndex, policyLength)
+}
+
+func TestDenyOverrideEffector(t *testing.T) {
+	rules := [][]string{
+		{"alice", "data1", "read", "allow"},
+		{"alice", "data1", "read", "deny"},
+		{"alice", "data1", "write", "allow"},
+		{"bob", "data2", "read", "deny"},
+		{"bob", "data2", "read", "allow"},
+		{"carol", "data3", "read", "deny"},
+	}
+	reversed := make([][]string, 0, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		reversed = append(reversed, rules[i])
+	}
+
+	// the decisions do not depend on the order of the rules.
+	for _, policy := range [][][]string{rules, reversed} {
+		e, _ := NewEnforcer("examples/rbac_with_deny_model.conf")
+		e.SetEffector(effector.NewDenyOverrideEffector())
+		_, _ = e.AddPolicies(policy)
+		testBatchEnforce(t, e, [][]interface{}{
+			{"alice", "data1", "read"},
+			{"alice", "data1", "write"},
+			{"bob", "data2", "read"},
+			{"carol", "data3", "read"},
+			{"dave", "data1", "read"},
+		}, []bool{
+			false, true, false, false, false,
+		})
+
+		res, explain, _ := e.EnforceEx("alice", "data1", "read")
+		if res || !util.ArrayEquals(explain, []string{"alice", "data1", "read", "deny"}) {
+			t.Errorf("EnforceEx: %t, %v, supposed to be denied by the deny rule", res, explain)
+		}
+	}
+
+	// the rules after the first matched deny rule are not evaluated.
+	e, _ := NewEnforcer("examples/rbac_with_deny_model.conf")
+	eft := &countingEffector{Effector: effector.NewDenyOverrideEffector()}
+	e.SetEffector(eft)
+	_, _ = e.AddPolicies(rules)
+	testEnforce(t, e, "bob", "data2", "read", false)
+	if eft.merges != 4 {
+		t.Errorf("%d rules evaluated, supposed to stop at the deny rule 4", eft.merges)
+	}
+	eft.merges = 0
+	testEnforce(t, e, "alice", "data1", "write", true)
+	if eft.merges != len(rules) {
+		t.Errorf("%d rules evaluated, supposed to be all the %d rules", eft.merges, len(rules))
+	}
+
+	// without an allow condition, a request matching no deny rule is allowed.
+	e, _ = NewEnforcer("examples/rbac_with_not_deny_model.conf")
+	e.SetEffector(effector.NewDenyOverrideEffector())
+	_, _ = e.AddPolicies(rules)
+	testBatchEnforce(t, e, [][]interface{}{
+		{"alice", "data1", "read"},
+		{"alice", "data1", "write"},
+		{"bob", "data2", "read"},
+		{"carol", "data3", "read"},
+		{"dave", "data1", "read"},
+	}, []bool{
+		false, true, false, false, true,
+	})
+
+	e, _ = NewEnforcer("examples/priority_model_explicit.conf", "examples/priority_policy_explicit.csv")
+	e.SetEffector(effector.NewDenyOverrideEffector())
+	if _, err := e.Enforce("alice", "data1", "read"); err == nil {
+		t.Error("DenyOverrideEffector should not support the priority effect")
+	}
+}
+
+func TestWeightedEffector(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj
+
+[policy_definition]
+p = sub, obj, weight
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && keyMatch(r.obj, p.obj) ? weight(p.weight) : 0
+`)
+	e, _ := NewEnforcer(m)
+	e.AddFunction("weight", func(args ...interface{}) (interface{}, error) {
+		return strconv.ParseFloat(args[0].(string), 64)
+	})
+	_, _ = e.AddPolicies([][]string{
+		{"alice", "/data/*", "0.5"},
+		{"alice", "/data/report", "0.25"},
+		{"alice", "/data/report/*", "0.5"},
+		{"bob", "/data/*", "2"},
+	})
+
+	// the default effector allows any rule with a non-zero score.
+	testEnforce2 := func(sub, obj string, res bool) {
+		t.Helper()
+		if myRes, err := e.Enforce(sub, obj); err != nil || myRes != res {
+			t.Errorf("%s, %s: %t, %v, supposed to be %t", sub, obj, myRes, err, res)
+		}
+	}
+	testEnforce2("alice", "/data/report", true)
+	testEnforce2("alice", "/other", false)
+
+	e.SetEffector(effector.NewWeightedEffector(0.7))
+	testEnforce2("alice", "/data/report", true)
+	testEnforce2("alice", "/data/report/1", true)
+	testEnforce2("alice", "/data/other", false)
+	testEnforce2("bob", "/data/other", true)
+	testEnforce2("carol", "/data/other", false)
+
+	res, explain, _ := e.EnforceEx("alice", "/data/report")
+	if !res || !util.ArrayEquals(explain, []string{"alice", "/data/report", "0.25"}) {
+		t.Errorf("EnforceEx: %t, %v, supposed to be allowed by the rule reaching the threshold", res, explain)
+	}
+}
+
+// lastMatchEffector allows the request after evaluating all the rules, explaining the last matching one.
+type lastMatchEffector struct{}
+
+func (lastMatchEffector) MergeEffects(expr string, effects []effector.Effect, matches []float64, policyIndex int, policyLength int) (effector.Effect, int, error) {
+	if policyIndex < policyLength-1 {
+		return effector.Indeterminate, -1, nil
+	}
+	for i := policyLength - 1; i >= 0; i-- {
+		if matches[i] != 0 && effects[i] == effector.Allow {
+			return effector.Allow, i, nil
+		}
+	}
+	return effector.Deny, -1, nil
+}
+
+func TestExplainStrategy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	_, _ = e.AddPolicy("alice", "data2", "read")
+	e.SetEffector(lastMatchEffector{})
+
+	// alice matches both data2_admin's rule through her role and her own rule added last.
+	testEnforceEx(t, e, "alice", "data2", "read", []string{"alice", "data2", "read"})
+
+	e.SetExplainStrategy(ExplainFirstMatchingRule)
+	testEnforceEx(t, e, "alice", "data2", "read", []string{"data2_admin", "data2", "read"})
+	testEnforceEx(t, e, "alice", "data1", "read", []string{"alice", "data1", "read"})
+	testEnforceEx(t, e, "bob", "data1", "read", []string{})
+
+	e, _ = NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+	e.SetExplainStrategy(ExplainFirstMatchingRule)
+	// the deny rule is explained, not the allow rule coming first.
+	testEnforceEx(t, e, "alice", "data2", "write", []string{"alice", "data2", "write", "deny"})
+}
+
+func TestFailedToLoadPolicy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_pattern_model.conf", "examples/rbac_with_pattern_policy.csv")
+	e.AddNamedMatchingFunc("g2", "matchingFunc", util.KeyMatch2)
+	testEnforce(t, e, "alice", "/book/1", "GET", true)
+	testEnforce(t, e, "bob", "/pen/3", "GET", true)
+	e.SetAdapter(fileadapter.NewAdapter("not found"))
+	_ = e.LoadPolicy()
+	testEnforce(t, e, "alice", "/book/1", "GET", true)
+	testEnforce(t, e, "bob", "/pen/3", "GET", true)
+}
+
+func TestReloadPolicyWithFunc(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_pattern_model.conf", "examples/rbac_with_pattern_policy.csv")
+	e.AddNamedMatchingFunc("g2", "matchingFunc", util.KeyMatch2)
+	testEnforce(t, e, "alice", "/book/1", "GET", true)
 	testEnforce(t, e, "bob", "/pen/3", "GET", true)
 	_ = e.LoadPolicy()
 	testEnforce(t, e, "alice", "/book/1", "GET", true)
@@ -629,3 +1498,828 @@ func TestEvalPriority(t *testing.T) {
 	testEnforce(t, e, "admin", "none", "write", false)
 	testEnforce(t, e, "user", "users", "write", false)
 }
+
+func TestEvalWithCustomFunction(t *testing.T) {
+	e, _ := NewEnforcer("examples/abac_rule_model.conf")
+	_, _ = e.AddPolicy("regexMatch1(r.obj, '^/data[0-9]$')", "/data1", "read")
+	_, _ = e.AddPolicy("regexMatch1(r.obj, '^/data[0-9]$')", "/data10", "read")
+
+	if _, err := e.Enforce("alice", "/data1", "read"); err == nil {
+		t.Error("Enforce() should fail when the function called by eval() is not added")
+	}
+
+	e.AddFunction("regexMatch1", func(args ...interface{}) (interface{}, error) {
+		return regexp.MatchString(args[1].(string), args[0].(string))
+	})
+	testEnforce(t, e, "alice", "/data1", "read", true)
+	testEnforce(t, e, "alice", "/data1", "write", false)
+	testEnforce(t, e, "alice", "/data10", "read", false)
+}
+
+func TestFallbackDecider(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+
+	var consulted [][]interface{}
+	e.SetFallbackDecider(func(rvals []interface{}) (bool, error) {
+		consulted = append(consulted, rvals)
+		return rvals[0] == "cathy", nil
+	})
+
+	// matched rules are not affected by the fallback decider
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data2", "write", false)
+	if len(consulted) != 0 {
+		t.Errorf("fallback decider should not be consulted for matched requests, got %v", consulted)
+	}
+
+	// unmatched requests are decided by the fallback decider
+	testEnforce(t, e, "cathy", "data3", "read", true)
+	testEnforce(t, e, "bob", "data3", "read", false)
+	if len(consulted) != 2 {
+		t.Errorf("fallback decider should be consulted twice, got %v", consulted)
+	}
+
+	e.SetFallbackDecider(func(rvals []interface{}) (bool, error) {
+		return false, errors.New("fallback unavailable")
+	})
+	if _, err := e.Enforce("cathy", "data3", "read"); err == nil {
+		t.Error("the error of the fallback decider should be returned")
+	}
+
+	e.SetFallbackDecider(nil)
+	testEnforce(t, e, "cathy", "data3", "read", false)
+}
+
+type enforceHookCall struct {
+	rvals    []interface{}
+	result   bool
+	explain  []string
+	err      error
+	disabled bool
+}
+
+func TestEnforceHook(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	var calls []enforceHookCall
+	e.SetEnforceHook(func(rvals []interface{}, result bool, explain []string, err error, disabled bool) {
+		calls = append(calls, enforceHookCall{rvals, result, explain, err, disabled})
+	})
+
+	_, _ = e.Enforce("alice", "data1", "read")
+	_, explain, _ := e.EnforceEx("bob", "data2", "write")
+	_, _ = e.BatchEnforce([][]interface{}{{"alice", "data2", "read"}, {"bob", "data2", "write"}})
+	_, _ = e.EnforceWithMatcher("r.sub == p.sub", "alice", "data1", "write")
+	_, _ = e.Enforce("alice", "data1")
+	e.EnableEnforce(false)
+	_, _ = e.Enforce("alice", "data2", "read")
+
+	expected := []enforceHookCall{
+		{[]interface{}{"alice", "data1", "read"}, true, []string{"alice", "data1", "read"}, nil, false},
+		{[]interface{}{"bob", "data2", "write"}, true, []string{"bob", "data2", "write"}, nil, false},
+		{[]interface{}{"alice", "data2", "read"}, false, []string{}, nil, false},
+		{[]interface{}{"bob", "data2", "write"}, true, []string{"bob", "data2", "write"}, nil, false},
+		{[]interface{}{"alice", "data1", "write"}, true, []string{"alice", "data1", "read"}, nil, false},
+		{[]interface{}{"alice", "data1"}, false, []string{}, nil, false},
+		{[]interface{}{"alice", "data2", "read"}, true, []string{}, nil, true},
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("enforce hook calls: %v, supposed to be %d", calls, len(expected))
+	}
+	for i, call := range calls {
+		if i == 5 {
+			if call.err == nil || call.result {
+				t.Errorf("enforce hook call %d: %v, supposed to report the error", i, call)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(call, expected[i]) {
+			t.Errorf("enforce hook call %d: %v, supposed to be %v", i, call, expected[i])
+		}
+	}
+	if !reflect.DeepEqual(explain, []string{"bob", "data2", "write"}) {
+		t.Errorf("EnforceEx() explain with the hook: %v", explain)
+	}
+
+	e.SetEnforceHook(nil)
+	_, _ = e.Enforce("alice", "data1", "read")
+	if len(calls) != len(expected) {
+		t.Error("the enforce hook should not be called once removed")
+	}
+}
+
+func TestPreserveGroupingFunction(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	// a customized g-function treating everyone as a member of every role
+	e.AddFunction("g", func(args ...interface{}) (interface{}, error) {
+		return true, nil
+	})
+
+	// by default the generated g-function shadows the customized one
+	testEnforce(t, e, "bob", "data2", "read", false)
+
+	e.PreserveGroupingFunction("g", true)
+	testEnforce(t, e, "bob", "data2", "read", true)
+	testEnforce(t, e, "bob", "data1", "read", true)
+
+	e.PreserveGroupingFunction("g", false)
+	testEnforce(t, e, "bob", "data2", "read", false)
+	testEnforce(t, e, "bob", "data1", "read", false)
+}
+
+func TestAddFunctionInvalidatesMatchers(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	// the matcher is compiled with the generated g-function, as no function is added for g yet.
+	e.PreserveGroupingFunction("g", true)
+	testEnforce(t, e, "bob", "data2", "read", false)
+
+	e.AddFunction("g", func(args ...interface{}) (interface{}, error) {
+		return true, nil
+	})
+	testEnforce(t, e, "bob", "data2", "read", true)
+}
+
+func TestAddFunctionConcurrently(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if ok, err := e.Enforce("alice", "data2", "read"); !ok || err != nil {
+					t.Errorf("Enforce() while adding functions: %t, %v", ok, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		e.AddFunction("customMatch"+strconv.Itoa(i), func(args ...interface{}) (interface{}, error) {
+			return true, nil
+		})
+	}
+	wg.Wait()
+}
+
+func TestEnforceWithDeadlineFallback(t *testing.T) {
+	m := model.NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act")
+	m.AddDef("e", "e", "some(where (p.eft == allow))")
+	m.AddDef("m", "m", "slowMatch(r.sub, p.sub) && r.obj == p.obj && r.act == p.act")
+
+	e, _ := NewEnforcer(m)
+	e.AddFunction("slowMatch", func(args ...interface{}) (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return args[0] == args[1], nil
+	})
+	_, _ = e.AddPolicy("alice", "data1", "read")
+	_, _ = e.AddPolicy("bob", "data2", "write")
+	_, _ = e.AddPolicy("cathy", "data3", "read")
+	_, _ = e.AddPolicy("david", "data4", "write")
+
+	res, err := e.EnforceWithDeadlineFallback(context.Background(), false, "david", "data4", "write")
+	if err != nil || !res {
+		t.Errorf("EnforceWithDeadlineFallback without deadline: %v, %v, supposed to be true, nil", res, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	res, err = e.EnforceWithDeadlineFallback(ctx, false, "david", "data4", "write")
+	if err != Err.ErrEnforceFallback || res {
+		t.Errorf("EnforceWithDeadlineFallback with expired deadline: %v, %v, supposed to be false, %v", res, err, Err.ErrEnforceFallback)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	res, err = e.EnforceWithDeadlineFallback(ctx, true, "alice", "data4", "write")
+	if err != Err.ErrEnforceFallback || !res {
+		t.Errorf("EnforceWithDeadlineFallback with canceled context: %v, %v, supposed to be true, %v", res, err, Err.ErrEnforceFallback)
+	}
+}
+
+func TestEnforceWithContext(t *testing.T) {
+	m := model.NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act")
+	m.AddDef("e", "e", "some(where (p.eft == allow))")
+	m.AddDef("m", "m", "slowMatch(r.sub, p.sub) && r.obj == p.obj && r.act == p.act")
+
+	e, _ := NewEnforcer(m)
+	e.AddFunction("slowMatch", func(args ...interface{}) (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return args[0] == args[1], nil
+	})
+	_, _ = e.AddPolicy("alice", "data1", "read")
+	_, _ = e.AddPolicy("bob", "data2", "write")
+	_, _ = e.AddPolicy("cathy", "data3", "read")
+	_, _ = e.AddPolicy("david", "data4", "write")
+
+	res, err := e.EnforceWithContext(context.Background(), "david", "data4", "write")
+	if err != nil || !res {
+		t.Errorf("EnforceWithContext without deadline: %v, %v, supposed to be true, nil", res, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	res, err = e.EnforceWithContext(ctx, "david", "data4", "write")
+	if err != context.DeadlineExceeded || res {
+		t.Errorf("EnforceWithContext with expired deadline: %v, %v, supposed to be false, %v", res, err, context.DeadlineExceeded)
+	}
+
+	results, err := e.BatchEnforceWithContext(context.Background(), [][]interface{}{{"alice", "data1", "read"}, {"bob", "data1", "read"}})
+	if err != nil || !reflect.DeepEqual(results, []bool{true, false}) {
+		t.Errorf("BatchEnforceWithContext without deadline: %v, %v, supposed to be [true false], nil", results, err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	results, err = e.BatchEnforceWithContext(ctx, [][]interface{}{{"alice", "data1", "read"}, {"bob", "data1", "read"}})
+	if err != context.Canceled || len(results) != 0 {
+		t.Errorf("BatchEnforceWithContext with canceled context: %v, %v, supposed to be [], %v", results, err, context.Canceled)
+	}
+}
+
+func TestAddNamedContextMatchingFunc(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domain_pattern_model.conf", "examples/rbac_with_domain_pattern_policy.csv")
+
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "read", false)
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "write", false)
+
+	// the roles of domain2 also apply to the other domains, but only for reading
+	ok := e.AddNamedContextMatchingFunc("g", "readFromDomain2", func(args ...string) bool {
+		return args[1] == "domain2" && args[len(args)-1] == "read"
+	})
+	if !ok {
+		t.Fatal("AddNamedContextMatchingFunc() should succeed for ptype g")
+	}
+
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "read", true)
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "write", false)
+	testDomainEnforce(t, e, "bob", "domain2", "data2", "write", true)
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "read", true)
+
+	if e.AddNamedContextMatchingFunc("g2", "readFromDomain2", func(args ...string) bool { return true }) {
+		t.Error("AddNamedContextMatchingFunc() should fail for an unknown ptype")
+	}
+}
+
+func TestLazyRoleLinks(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if err := e.SetLazyRoleLinks(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.LoadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the role links are not built by LoadPolicy
+	if ok, _ := e.GetRoleManager().HasLink("alice", "data2_admin"); ok {
+		t.Error("role links should not be built before the first enforcement")
+	}
+
+	testEnforce(t, e, "alice", "data2", "read", true)
+	if ok, _ := e.GetRoleManager().HasLink("alice", "data2_admin"); !ok {
+		t.Error("role links should be built by the first enforcement")
+	}
+
+	// a policy change marks the role links dirty
+	_, _ = e.AddGroupingPolicy("bob", "data2_admin")
+	testEnforce(t, e, "bob", "data2", "read", true)
+	_, _ = e.RemoveGroupingPolicy("alice", "data2_admin")
+	testEnforce(t, e, "alice", "data2", "read", false)
+
+	// a function whose name only ends with the ptype doesn't build its role links
+	e.AddFunction("isOrg", func(args ...interface{}) (interface{}, error) { return true, nil })
+	_, _ = e.AddGroupingPolicy("alice", "data2_admin")
+	if _, err := e.EnforceWithMatcher("isOrg(r.sub) && r.obj == p.obj", "alice", "data2", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := e.GetRoleManager().HasLink("alice", "data2_admin"); ok {
+		t.Error("role links should not be built by a matcher not calling g")
+	}
+
+	if err := e.SetLazyRoleLinks(false); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := e.GetRoleManager().HasLink("alice", "data2_admin"); !ok {
+		t.Error("role links should be built when lazy role links are disabled")
+	}
+}
+
+func TestMatcherSelector(t *testing.T) {
+	text :=
+		`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+m2 = r.sub == p.sub && keyMatch(r.obj, p.obj) && r.act == p.act
+`
+	m, _ := model.NewModelFromString(text)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy("alice", "/data/*", "read")
+
+	testEnforce(t, e, "alice", "/data/1", "read", false)
+
+	e.SetMatcherSelector(func(rvals []interface{}) string {
+		if obj, ok := rvals[1].(string); ok && strings.HasPrefix(obj, "/data/") {
+			return "m2"
+		}
+		return ""
+	})
+	testEnforce(t, e, "alice", "/data/1", "read", true)
+	testEnforce(t, e, "alice", "/data/1", "write", false)
+	testEnforce(t, e, "alice", "/data/*", "read", true)
+	testEnforce(t, e, "alice", "/file/1", "read", false)
+	if _, ok := e.matcherMap.Load(e.model["m"]["m2"].Value); !ok {
+		t.Error("the expression of the selected matcher should be cached")
+	}
+
+	e.SetMatcherSelector(func(rvals []interface{}) string { return "m3" })
+	if _, err := e.Enforce("alice", "/data/1", "read"); err == nil {
+		t.Error("Enforce() should fail when the selected matcher does not exist")
+	}
+
+	e.SetMatcherSelector(nil)
+	testEnforce(t, e, "alice", "/data/1", "read", false)
+}
+
+func TestTrimPolicyFields(t *testing.T) {
+	line := "p, alice , data1 , read \np, data2_admin, data2, read\ng, bob , data2_admin "
+	e, _ := NewEnforcer("examples/rbac_model.conf", stringadapter.NewAdapter(line))
+
+	// the surrounding whitespace is kept by default
+	testEnforce(t, e, "alice", "data1", "read", false)
+	testEnforce(t, e, "bob", "data2", "read", false)
+
+	e.EnableTrimPolicyFields(true)
+	if err := e.LoadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "bob", "data2", "read", true)
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}})
+
+	e.EnableAutoSave(false)
+	_, _ = e.AddPolicy(" bob", "data1 ", " write ")
+	testEnforce(t, e, "bob", "data1", "write", true)
+	if ok, _ := e.AddPolicy("alice ", "data1", "read"); ok {
+		t.Error("AddPolicy() should not add a rule which only differs in whitespace")
+	}
+	// the rules given to look up, update or remove policies are trimmed as well
+	testHasPolicy(t, e, []string{" bob ", "data1", "write"}, true)
+	if !e.HasGroupingPolicy("bob ", " data2_admin") {
+		t.Error("HasGroupingPolicy() should find the trimmed rule")
+	}
+	if ok, err := e.UpdatePolicy([]string{" bob", "data1", "write"}, []string{"bob", " data3 ", "write"}); !ok || err != nil {
+		t.Errorf("UpdatePolicy(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	if ok, err := e.RemovePolicy("bob ", "data3", "write"); !ok || err != nil {
+		t.Errorf("RemovePolicy(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	if ok, err := e.RemoveFilteredGroupingPolicy(0, " bob"); !ok || err != nil {
+		t.Errorf("RemoveFilteredGroupingPolicy(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}})
+	testGetGroupingPolicy(t, e, [][]string{})
+}
+
+type pingAdapter struct {
+	*fileadapter.Adapter
+	err error
+}
+
+func (a *pingAdapter) Ping() error {
+	return a.err
+}
+
+func TestHealthCheck(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	status, err := e.HealthCheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := HealthStatus{
+		PolicyLoaded:        true,
+		RoleLinksBuilt:      true,
+		AdapterReachable:    true,
+		EnforcementEnabled:  true,
+		PolicyCount:         4,
+		GroupingPolicyCount: 1,
+	}
+	if status != expected {
+		t.Errorf("Health status: %+v, supposed to be %+v", status, expected)
+	}
+
+	e.EnableEnforce(false)
+	_ = e.SetLazyRoleLinks(true)
+	_, _ = e.AddGroupingPolicy("bob", "data2_admin")
+	e.SetAdapter(&pingAdapter{fileadapter.NewAdapter("examples/rbac_policy.csv"), errors.New("unreachable")})
+	status, err = e.HealthCheck()
+	if err == nil {
+		t.Error("HealthCheck() should return the error of the adapter ping")
+	}
+	expected = HealthStatus{
+		PolicyLoaded:        true,
+		PolicyCount:         4,
+		GroupingPolicyCount: 2,
+	}
+	if status != expected {
+		t.Errorf("Health status: %+v, supposed to be %+v", status, expected)
+	}
+
+	e, _ = NewEnforcer("examples/rbac_model.conf")
+	status, _ = e.HealthCheck()
+	if status.PolicyLoaded {
+		t.Errorf("Health status: %+v, supposed to have no policy", status)
+	}
+}
+
+func TestBreakGlassToken(t *testing.T) {
+	text :=
+		`
+[request_definition]
+r = sub, obj, act, breakglass
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[policy_effect]
+e = some(where (p.eft == allow)) && !some(where (p.eft == deny))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+	m, _ := model.NewModelFromString(text)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy("alice", "data1", "read", "allow")
+	_, _ = e.AddPolicy("alice", "data1", "write", "deny")
+
+	testEnforceBreakGlass := func(act string, breakGlass interface{}, res bool, reason EnforceReason) {
+		t.Helper()
+		myRes, myReason, err := e.EnforceWithReason("alice", "data1", act, breakGlass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if myRes != res || myReason != reason {
+			t.Errorf("%s, %v: %t, %d, supposed to be %t, %d", act, breakGlass, myRes, myReason, res, reason)
+		}
+	}
+
+	// the token is ignored until it is set
+	testEnforceBreakGlass("write", true, false, ReasonDeniedByRule)
+
+	e.SetBreakGlassToken("breakglass")
+	testEnforceBreakGlass("write", true, true, ReasonBreakGlass)
+	testEnforceBreakGlass("write", "true", true, ReasonBreakGlass)
+	testEnforceBreakGlass("write", false, false, ReasonDeniedByRule)
+	testEnforceBreakGlass("read", false, true, ReasonEvaluated)
+	testEnforceBreakGlass("read", true, true, ReasonEvaluated)
+
+	e.SetBreakGlassToken("")
+	testEnforceBreakGlass("write", true, false, ReasonDeniedByRule)
+}
+
+func TestOptionalRequestTokens(t *testing.T) {
+	text :=
+		`
+[request_definition]
+r = sub, obj, act, ip
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act && (r.ip == "" || r.ip == "127.0.0.1")
+`
+	m, _ := model.NewModelFromString(text)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy("alice", "data1", "read")
+
+	if _, err := e.Enforce("alice", "data1", "read"); err == nil {
+		t.Error("Enforce() should fail on a request omitting a required token")
+	}
+
+	if err := e.SetOptionalRequestTokens("r", []string{"act"}); err == nil {
+		t.Error("SetOptionalRequestTokens() should fail on a token which is not trailing")
+	}
+	if err := e.SetOptionalRequestTokens("r2", []string{"ip"}); err == nil {
+		t.Error("SetOptionalRequestTokens() should fail on a nonexistent request definition")
+	}
+	if err := e.SetOptionalRequestTokens("r", []string{"ip"}); err != nil {
+		t.Fatal(err)
+	}
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "alice", "data1", "write", false)
+	if res, _ := e.Enforce("alice", "data1", "read", "127.0.0.1"); !res {
+		t.Error("Enforce() with the optional token should be allowed")
+	}
+	if res, _ := e.Enforce("alice", "data1", "read", "10.0.0.1"); res {
+		t.Error("Enforce() with the optional token should be denied")
+	}
+	if _, err := e.Enforce("alice", "data1"); err == nil {
+		t.Error("Enforce() should fail on a request omitting a required token")
+	}
+
+	_ = e.SetOptionalRequestTokens("r", nil)
+	if _, err := e.Enforce("alice", "data1", "read"); err == nil {
+		t.Error("Enforce() should fail on a request omitting a required token")
+	}
+}
+
+func TestGetMatcherCacheStats(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "bob", "data2", "write", true)
+	_, _ = e.EnforceWithMatcher("r.sub == p.sub", "alice", "data1", "read")
+
+	stats := e.GetMatcherCacheStats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Size != 2 || stats.Evictions != 0 {
+		t.Errorf("Matcher cache stats: %+v, supposed to be 1 hit, 2 misses and size 2", stats)
+	}
+
+	e.ResetMatcherCacheStats()
+	testEnforce(t, e, "alice", "data1", "read", true)
+	stats = e.GetMatcherCacheStats()
+	if stats.Hits != 1 || stats.Misses != 0 || stats.Size != 2 {
+		t.Errorf("Matcher cache stats: %+v, supposed to be 1 hit, 0 misses and size 2", stats)
+	}
+}
+
+// countingCompiler compiles the expressions with govaluate, counting them, and fails to compile fail.
+type countingCompiler struct {
+	compiled []string
+	fail     string
+}
+
+func (c *countingCompiler) Compile(expression string, functions map[string]govaluate.ExpressionFunction) (Expression, error) {
+	c.compiled = append(c.compiled, expression)
+	if expression == c.fail {
+		return nil, errors.New("cannot compile " + expression)
+	}
+	return govaluate.NewEvaluableExpressionWithFunctions(expression, functions)
+}
+
+func TestSetExpressionCompiler(t *testing.T) {
+	e, _ := NewEnforcer("examples/abac_rule_model.conf", "examples/abac_rule_policy.csv")
+	testEnforce(t, e, struct{ Age int }{Age: 30}, "/data1", "read", true)
+
+	compiler := &countingCompiler{fail: "r_sub.Age < 60"}
+	e.SetExpressionCompiler(compiler)
+	testEnforce(t, e, struct{ Age int }{Age: 30}, "/data1", "read", true)
+	// the matcher and the sub-rule of eval() are compiled with the compiler.
+	if len(compiler.compiled) != 2 || compiler.compiled[1] != "r_sub.Age > 18" {
+		t.Errorf("compiled expressions: %q", compiler.compiled)
+	}
+
+	if _, err := e.GetFilteredNamedPolicyWithMatcher("p", "p.obj == '/data2'"); err != nil || len(compiler.compiled) != 3 {
+		t.Errorf("GetFilteredNamedPolicyWithMatcher(): %v, compiled expressions: %q", err, compiler.compiled)
+	}
+	if _, err := e.EnforceWithMatcher("r.sub.Age < 60", struct{ Age int }{Age: 30}, "/data1", "read"); err == nil {
+		t.Error("EnforceWithMatcher() should fail when the matcher cannot be compiled")
+	}
+
+	e.SetExpressionCompiler(nil)
+	testEnforceWithMatcher(t, e, "r.sub.Age < 60", struct{ Age int }{Age: 30}, "/data1", "read", true)
+}
+
+func TestSetMatcherCacheSize(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	e.SetMatcherCacheSize(2)
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	matchers := []string{
+		"r.sub == p.sub",
+		"r.obj == p.obj",
+		"r.act == p.act",
+	}
+	for _, matcher := range matchers {
+		_, _ = e.EnforceWithMatcher(matcher, "alice", "data1", "read")
+	}
+	// the first matcher was evicted, while the matcher of the model is kept.
+	_, _ = e.EnforceWithMatcher(matchers[2], "alice", "data1", "read")
+	_, _ = e.EnforceWithMatcher(matchers[0], "alice", "data1", "read")
+	testEnforce(t, e, "bob", "data2", "write", true)
+
+	stats := e.GetMatcherCacheStats()
+	if stats.Hits != 2 || stats.Misses != 5 || stats.Size != 3 || stats.Evictions != 2 {
+		t.Errorf("Matcher cache stats: %+v, supposed to be 2 hits, 5 misses, size 3 and 2 evictions", stats)
+	}
+
+	e.invalidateMatcherMap()
+	if stats = e.GetMatcherCacheStats(); stats.Size != 0 {
+		t.Errorf("Matcher cache size: %d, supposed to be 0 after the invalidation", stats.Size)
+	}
+
+	e.SetMatcherCacheSize(0)
+	for _, matcher := range matchers {
+		_, _ = e.EnforceWithMatcher(matcher, "alice", "data1", "read")
+	}
+	if stats = e.GetMatcherCacheStats(); stats.Size != 3 || stats.Evictions != 2 {
+		t.Errorf("Matcher cache stats: %+v, supposed to be size 3 without new eviction", stats)
+	}
+}
+
+func TestClone(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e.EnableAcceptJsonRequest(true)
+
+	c, err := e.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testEnforce(t, c, "alice", "data2", "read", true)
+	testEnforce(t, c, "bob", "data2", "write", true)
+	if !c.acceptJsonRequest || c.autoSave {
+		t.Error("The clone should keep the flags of the enforcer and not save automatically")
+	}
+
+	_, _ = c.RemovePolicy("bob", "data2", "write")
+	_, _ = c.DeleteRoleForUser("alice", "data2_admin")
+	_, _ = c.AddRoleForUser("bob", "data2_admin")
+	testEnforce(t, c, "alice", "data2", "read", false)
+	testEnforce(t, c, "bob", "data2", "write", true)
+	testEnforce(t, c, "bob", "data2", "read", true)
+
+	testEnforce(t, e, "alice", "data2", "read", true)
+	testEnforce(t, e, "bob", "data2", "read", false)
+	testGetPolicy(t, e, [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"}})
+	testGetRoles(t, e, []string{"data2_admin"}, "alice")
+	testGetRoles(t, e, []string{}, "bob")
+
+	// the policy of the original is not saved by the clone
+	_ = c.LoadPolicy()
+	testGetPolicy(t, c, [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"}})
+	// the matching functions of the role managers are kept
+	e, _ = NewEnforcer("examples/rbac_with_pattern_model.conf", "examples/rbac_with_pattern_policy.csv")
+	e.AddNamedMatchingFunc("g2", "KeyMatch2", util.KeyMatch2)
+	e.AddNamedMatchingFunc("g", "KeyMatch2", util.KeyMatch2)
+	if c, err = e.Clone(); err != nil {
+		t.Fatal(err)
+	}
+	testEnforce(t, c, "alice", "/book/2", "GET", true)
+	testEnforce(t, c, "any_user", "/pen3/1", "GET", true)
+
+	e, _ = NewEnforcer("examples/rbac_with_domain_pattern_model.conf", "examples/rbac_with_domain_pattern_policy.csv")
+	e.AddNamedDomainMatchingFunc("g", "KeyMatch2", util.KeyMatch2)
+	if c, err = e.Clone(); err != nil {
+		t.Fatal(err)
+	}
+	testDomainEnforce(t, c, "alice", "domain2", "data2", "read", true)
+
+	// a role manager set on the enforcer cannot be cloned
+	e.SetRoleManager(defaultrolemanager.NewRoleManager(10))
+	if _, err = e.Clone(); err == nil {
+		t.Error("Clone() should fail with a role manager set by SetRoleManager")
+	}
+}
+
+// streamingAdapter is a streaming adapter sending the rules of a file adapter one by one.
+type streamingAdapter struct {
+	*fileadapter.Adapter
+	rules [][]string
+	err   error
+}
+
+func (a *streamingAdapter) LoadPolicyStream(rules chan<- []string) error {
+	for _, rule := range a.rules {
+		rules <- rule
+	}
+	return a.err
+}
+
+func TestLoadPolicyStream(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if err := e.LoadPolicyStream(); err == nil {
+		t.Error("LoadPolicyStream() should fail with an adapter not supporting streaming")
+	}
+
+	a := &streamingAdapter{Adapter: fileadapter.NewAdapter("examples/rbac_policy.csv")}
+	a.rules = [][]string{
+		{"p", "alice", "data1", "read"},
+		{"g", "bob", "data2_admin"},
+		{"p", "data2_admin", "data2", "read"},
+		{"p", "alice", "data1", "read"},
+	}
+	e.SetAdapter(a)
+	if err := e.LoadPolicyStream(); err != nil {
+		t.Fatal(err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}})
+	testGetGroupingPolicy(t, e, [][]string{{"bob", "data2_admin"}})
+	testEnforce(t, e, "alice", "data2", "read", false)
+	testEnforce(t, e, "bob", "data2", "read", true)
+
+	a.rules = append(a.rules, []string{"p2", "alice", "data3", "read"})
+	for i := 0; i < 2000; i++ {
+		a.rules = append(a.rules, []string{"p", "alice", "data1", "read"})
+	}
+	if err := e.LoadPolicyStream(); err == nil {
+		t.Error("LoadPolicyStream() should fail on an invalid policy type")
+	}
+	// the current policy is kept when loading fails.
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}})
+	testEnforce(t, e, "bob", "data2", "read", true)
+
+	a.rules = [][]string{{"p", "alice", "data1", "read"}}
+	a.err = errors.New("connection lost")
+	if err := e.LoadPolicyStream(); err != a.err {
+		t.Errorf("LoadPolicyStream(): %v, supposed to be %v", err, a.err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}})
+	testEnforce(t, e, "bob", "data2", "read", true)
+
+	// the role links are rebuilt lazily from the loaded policy.
+	a.rules = [][]string{{"g", "alice", "data2_admin"}, {"p", "data2_admin", "data2", "read"}}
+	a.err = nil
+	_ = e.SetLazyRoleLinks(true)
+	if err := e.LoadPolicyStream(); err != nil {
+		t.Fatal(err)
+	}
+	testEnforce(t, e, "alice", "data2", "read", true)
+	testEnforce(t, e, "bob", "data2", "read", false)
+}
+
+func TestEnableEnforcePanicRecovery(t *testing.T) {
+	m := model.NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act")
+	m.AddDef("e", "e", "some(where (p.eft == allow))")
+	m.AddDef("m", "m", "panicMatch(r.sub, p.sub) && r.obj == p.obj && r.act == p.act")
+
+	e, _ := NewEnforcer(m)
+	e.AddFunction("panicMatch", func(args ...interface{}) (interface{}, error) {
+		panic("panicMatch")
+	})
+	_, _ = e.AddPolicy("alice", "data1", "read")
+
+	if _, err := e.Enforce("alice", "data1", "read"); err == nil || !strings.HasPrefix(err.Error(), "panic: panicMatch") {
+		t.Errorf("Enforce() should return the recovered panic, got %v", err)
+	}
+
+	e.EnableEnforcePanicRecovery(false)
+	defer func() {
+		if r := recover(); r != "panicMatch" {
+			t.Errorf("Enforce() should panic with panicMatch, got %v", r)
+		}
+	}()
+	_, _ = e.Enforce("alice", "data1", "read")
+	t.Error("Enforce() should panic")
+}
+
+func TestGetModelText(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e.AddFunction("customMatch", func(args ...interface{}) (interface{}, error) {
+		return true, nil
+	})
+
+	text := e.GetModelText()
+	for _, line := range []string{
+		"r = sub, obj, act\n",
+		"m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act\n",
+		"# r = r_sub, r_obj, r_act\n",
+		"# p = p_sub, p_obj, p_act\n",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("GetModelText(): %s, supposed to contain %q", text, line)
+		}
+	}
+	if !strings.Contains(text, "customMatch") || !strings.Contains(text, "keyMatch") {
+		t.Errorf("GetModelText(): %s, supposed to list the functions", text)
+	}
+
+	// the text can be loaded back as the same model.
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		t.Fatalf("NewModelFromString(): %v", err)
+	}
+	e2, _ := NewEnforcer(m, fileadapter.NewAdapter("examples/rbac_policy.csv"))
+	testEnforce(t, e2, "alice", "data2", "read", true)
+	testEnforce(t, e2, "bob", "data1", "read", false)
+}
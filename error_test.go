@@ -15,8 +15,10 @@
 package casbin
 
 import (
+	"errors"
 	"testing"
 
+	Err "github.com/casbin/casbin/v2/errors"
 	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
 )
 
@@ -88,6 +90,26 @@ func TestEnforceError(t *testing.T) {
 	}
 }
 
+func TestSizeError(t *testing.T) {
+	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	_, err := e.Enforce("alice", "data1")
+	if !errors.Is(err, Err.ErrInvalidRequestSize) || errors.Is(err, Err.ErrInvalidPolicySize) {
+		t.Errorf("Error: %v, supposed to be %v", err, Err.ErrInvalidRequestSize)
+	}
+	if err.Error() != "invalid request size: expected 3, got 2, rvals: [alice data1]" {
+		t.Errorf("Error message: %s", err.Error())
+	}
+
+	e.GetModel()["p"]["p"].Policy = append(e.GetModel()["p"]["p"].Policy, []string{"bob", "data3"})
+	_, err = e.Enforce("bob", "data3", "read")
+	if !errors.Is(err, Err.ErrInvalidPolicySize) {
+		t.Errorf("Error: %v, supposed to be %v", err, Err.ErrInvalidPolicySize)
+	}
+	if err.Error() != "invalid policy size: expected 3, got 2, pvals: [bob data3]" {
+		t.Errorf("Error message: %s", err.Error())
+	}
+}
+
 func TestNoError(t *testing.T) {
 	e, _ := NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
 
@@ -0,0 +1,27 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import "errors"
+
+// Global errors for enforce defined here
+var (
+	ErrEnforceFallback = errors.New("error: enforce did not finish in time, the fallback decision is returned")
+
+	// ErrInvalidRequestSize and ErrInvalidPolicySize are wrapped by the errors of the requests and the policy rules
+	// whose number of fields does not fit their definition, use errors.Is to detect them.
+	ErrInvalidRequestSize = errors.New("invalid request size")
+	ErrInvalidPolicySize  = errors.New("invalid policy size")
+)
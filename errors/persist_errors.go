@@ -0,0 +1,27 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import "errors"
+
+// Global errors for persist defined here
+var (
+	// ErrCannotSaveFilteredPolicy is returned when saving the whole policy while only a filtered part of it is loaded,
+	// SaveFilteredPolicy saves the filtered part instead.
+	ErrCannotSaveFilteredPolicy = errors.New("cannot save a filtered policy")
+	// ErrCountingNotSupported is returned when counting the policy rules in the storage with an adapter not
+	// implementing persist.CountingAdapter, CountLoadedPolicy counts the rules in the model instead.
+	ErrCountingNotSupported = errors.New("counting policies is not supported by this adapter")
+)
@@ -23,6 +23,8 @@ var (
 	ErrLinkNotFound                = errors.New("error: link between name1 and name2 does not exist")
 	ErrUseDomainParameter          = errors.New("error: useDomain should be 1 parameter")
 	ErrInvalidFieldValuesParameter = errors.New("fieldValues requires at least one parameter")
+	ErrNoDomainInPolicy            = errors.New("error: the policy definition has no dom token")
+	ErrGroupingTypeNotFound        = errors.New("error: the grouping policy type is not defined in the model")
 
 	// GetAllowedObjectConditions errors
 	ErrObjCondition   = errors.New("need to meet the prefix required by the object condition")
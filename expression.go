@@ -0,0 +1,100 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package casbin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+)
+
+// Expression is a compiled matcher expression, evaluated with the request and policy parameters.
+// *govaluate.EvaluableExpression is an Expression.
+type Expression interface {
+	Eval(parameters govaluate.Parameters) (interface{}, error)
+}
+
+// ExpressionCompiler compiles the matcher expressions, like "r_sub == p_sub && keyMatch(r_obj, p_obj)",
+// with the functions they can call. The tokens are given with an underscore, as r_sub for r.sub.
+type ExpressionCompiler interface {
+	Compile(expression string, functions map[string]govaluate.ExpressionFunction) (Expression, error)
+}
+
+// govaluateCompiler is the default ExpressionCompiler, backed by govaluate.
+type govaluateCompiler struct{}
+
+func (govaluateCompiler) Compile(expression string, functions map[string]govaluate.ExpressionFunction) (Expression, error) {
+	expr, err := govaluate.NewEvaluableExpressionWithFunctions(expression, functions)
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// SetExpressionCompiler sets the compiler of the matcher expressions, in place of the default one backed by govaluate,
+// so that another expression engine can be used. The compiled matchers are invalidated. Pass nil to restore the default.
+func (e *Enforcer) SetExpressionCompiler(compiler ExpressionCompiler) {
+	e.compiler = compiler
+	e.invalidateMatcherMap()
+}
+
+// compile compiles expression with the compiler of the enforcer.
+func (e *Enforcer) compile(expression string, functions map[string]govaluate.ExpressionFunction) (Expression, error) {
+	if e.caseInsensitive {
+		expression = foldCaseComparisons(expression)
+		functions[caseFoldFunction] = caseFold
+	}
+	if e.compiler == nil {
+		return govaluateCompiler{}.Compile(expression, functions)
+	}
+	return e.compiler.Compile(expression, functions)
+}
+
+// caseFoldFunction is the name of the function folding the operands of the comparisons
+// when the case-insensitive matching is enabled.
+const caseFoldFunction = "casbinCaseFold"
+
+// comparisonOperand matches a string literal, or a token like r_sub, r_obj.Owner or [r_obj.Owner] for an Attributer.
+const comparisonOperand = `'[^']*'|"[^"]*"|\[[rp][0-9]*_[^\]]*\]|\b[rp][0-9]*_[A-Za-z0-9_]+(?:\.[A-Za-z0-9_]+)*`
+
+// comparisonRegex matches a == or != comparison between two operands. The string literals are matched alone as well,
+// so that the comparisons inside them are skipped.
+var comparisonRegex = regexp.MustCompile(
+	`(` + comparisonOperand + `)\s*(==|!=)\s*(` + comparisonOperand + `)|'[^']*'|"[^"]*"`)
+
+// foldCaseComparisons rewrites the comparisons of expression, like r_sub == p_sub, to compare their operands
+// with the case folded, like casbinCaseFold(r_sub) == casbinCaseFold(p_sub).
+func foldCaseComparisons(expression string) string {
+	return comparisonRegex.ReplaceAllStringFunc(expression, func(match string) string {
+		groups := comparisonRegex.FindStringSubmatch(match)
+		if groups[1] == "" {
+			return match
+		}
+		return caseFoldFunction + "(" + groups[1] + ") " + groups[2] + " " + caseFoldFunction + "(" + groups[3] + ")"
+	})
+}
+
+// caseFold returns its argument in lower case if it is a string, and as it is otherwise.
+func caseFold(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expected 1 argument, but got %d", caseFoldFunction, len(args))
+	}
+	if s, ok := args[0].(string); ok {
+		return strings.ToLower(s), nil
+	}
+	return args[0], nil
+}
@@ -15,8 +15,14 @@
 package casbin
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	Err "github.com/casbin/casbin/v2/errors"
 	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
 	"github.com/casbin/casbin/v2/util"
 )
@@ -58,11 +64,63 @@ func TestLoadFilteredPolicy(t *testing.T) {
 	testHasPolicy(t, e, []string{"admin", "domain1", "data1", "read"}, true)
 	testHasPolicy(t, e, []string{"admin", "domain2", "data2", "read"}, false)
 
-	if err := e.SavePolicy(); err == nil {
-		t.Errorf("enforcer did not prevent saving filtered policy")
+	if err := e.SavePolicy(); !errors.Is(err, Err.ErrCannotSaveFilteredPolicy) {
+		t.Errorf("enforcer did not prevent saving filtered policy: %v", err)
 	}
-	if err := e.GetAdapter().SavePolicy(e.GetModel()); err == nil {
-		t.Errorf("adapter did not prevent saving filtered policy")
+	if err := e.GetAdapter().SavePolicy(e.GetModel()); !errors.Is(err, Err.ErrCannotSaveFilteredPolicy) {
+		t.Errorf("adapter did not prevent saving filtered policy: %v", err)
+	}
+}
+
+func TestSaveFilteredPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	policy, err := ioutil.ReadFile("examples/rbac_with_domains_policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "policy.csv")
+	if err = ioutil.WriteFile(path, policy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := NewEnforcer()
+	_ = e.InitWithAdapter("examples/rbac_with_domains_model.conf", fileadapter.NewFilteredAdapter(path))
+	filter := &fileadapter.Filter{
+		P: []string{"", "domain1"},
+		G: []string{"", "", "domain1"},
+	}
+	if err = e.LoadFilteredPolicy(filter); err != nil {
+		t.Fatalf("unexpected error in LoadFilteredPolicy: %v", err)
+	}
+
+	_, _ = e.RemovePolicy("admin", "domain1", "data1", "write")
+	_, _ = e.AddPolicy("admin", "domain1", "data3", "read")
+	_, _ = e.AddGroupingPolicy("carol", "admin", "domain1")
+	if err = e.SaveFilteredPolicy(filter); err != nil {
+		t.Fatalf("unexpected error in SaveFilteredPolicy: %v", err)
+	}
+
+	// the rules of domain2, which were not loaded, are kept.
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", path)
+	testGetPolicy(t, e, [][]string{
+		{"admin", "domain2", "data2", "read"},
+		{"admin", "domain2", "data2", "write"},
+		{"admin", "domain1", "data1", "read"},
+		{"admin", "domain1", "data3", "read"},
+	})
+	testGetGroupingPolicy(t, e, [][]string{
+		{"bob", "admin", "domain2"},
+		{"alice", "admin", "domain1"},
+		{"carol", "admin", "domain1"},
+	})
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	if err = e.SaveFilteredPolicy(filter); err == nil {
+		t.Error("SaveFilteredPolicy should fail with an adapter not supporting it")
 	}
 }
 
@@ -207,3 +265,46 @@ func TestFilteredAdapterInvalidFilepath(t *testing.T) {
 		t.Errorf("expected error in LoadFilteredPolicy, but got nil")
 	}
 }
+
+func TestSaveFilteredPolicyWithSeparator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "policy.csv")
+	policy := "p|admin|domain1|data1|read\np|admin|domain2|\"data|2\"|read\ng|alice|admin|domain1\ng|bob|admin|domain2"
+	if err = ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ := NewEnforcer()
+	_ = e.InitWithAdapter("examples/rbac_with_domains_model.conf", fileadapter.NewFilteredAdapterWithSeparator(path, '|'))
+	filter := &fileadapter.Filter{
+		P: []string{"", "domain1"},
+		G: []string{"", "", "domain1"},
+	}
+	if err = e.LoadFilteredPolicy(filter); err != nil {
+		t.Fatalf("unexpected error in LoadFilteredPolicy: %v", err)
+	}
+	testGetPolicy(t, e, [][]string{{"admin", "domain1", "data1", "read"}})
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "admin", "domain1"}})
+
+	_, _ = e.AddPolicy("admin", "domain1", "a, \"b\"|c", "write")
+	if err = e.SaveFilteredPolicy(filter); err != nil {
+		t.Fatalf("unexpected error in SaveFilteredPolicy: %v", err)
+	}
+	saved, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(saved), `p|admin|domain1|"a, ""b""|c"|write`) {
+		t.Errorf("SaveFilteredPolicy() with separator '|': %q", saved)
+	}
+
+	// the saved policy is loaded back, the rules which were not loaded being kept once.
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", fileadapter.NewAdapterWithSeparator(path, '|'))
+	testGetPolicy(t, e, [][]string{
+		{"admin", "domain2", "data|2", "read"},
+		{"admin", "domain1", "data1", "read"},
+		{"admin", "domain1", "a, \"b\"|c", "write"},
+	})
+	testGetGroupingPolicy(t, e, [][]string{{"bob", "admin", "domain2"}, {"alice", "admin", "domain1"}})
+}
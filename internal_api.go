@@ -16,6 +16,7 @@ package casbin
 
 import (
 	"fmt"
+	"strings"
 
 	Err "github.com/casbin/casbin/v2/errors"
 	"github.com/casbin/casbin/v2/model"
@@ -34,6 +35,31 @@ func (e *Enforcer) shouldNotify() bool {
 	return e.watcher != nil && e.autoNotifyWatcher
 }
 
+// trimRule returns a copy of rule with the surrounding whitespace of each field trimmed
+// if EnableTrimPolicyFields is enabled, or rule itself otherwise.
+func (e *Enforcer) trimRule(rule []string) []string {
+	if !e.trimPolicyFields {
+		return rule
+	}
+	trimmed := make([]string, len(rule))
+	for i, field := range rule {
+		trimmed[i] = strings.TrimSpace(field)
+	}
+	return trimmed
+}
+
+// trimRules returns the rules trimmed like trimRule does.
+func (e *Enforcer) trimRules(rules [][]string) [][]string {
+	if !e.trimPolicyFields {
+		return rules
+	}
+	trimmed := make([][]string, len(rules))
+	for i, rule := range rules {
+		trimmed[i] = e.trimRule(rule)
+	}
+	return trimmed
+}
+
 // addPolicy adds a rule to the current policy.
 func (e *Enforcer) addPolicyWithoutNotify(sec string, ptype string, rule []string) (bool, error) {
 	if e.dispatcher != nil && e.autoNotifyDispatcher {
@@ -77,25 +103,63 @@ func (e *Enforcer) addPoliciesWithoutNotify(sec string, ptype string, rules [][]
 	}
 
 	if e.shouldPersist() {
-		if err := e.adapter.(persist.BatchAdapter).AddPolicies(sec, ptype, rules); err != nil {
+		if err := e.addPoliciesToAdapter(sec, ptype, rules); err != nil {
 			if err.Error() != notImplemented {
 				return false, err
 			}
 		}
 	}
 
-	e.model.AddPolicies(sec, ptype, rules)
+	affected := e.model.AddPoliciesWithAffected(sec, ptype, rules)
 
 	if sec == "g" {
-		err := e.BuildIncrementalRoleLinks(model.PolicyAdd, ptype, rules)
+		err := e.BuildIncrementalRoleLinks(model.PolicyAdd, ptype, affected)
 		if err != nil {
-			return true, err
+			// the rules are added all or none, in the storage as well
+			e.model.RemovePolicies(sec, ptype, affected)
+			_ = e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, affected)
+			if e.shouldPersist() {
+				_ = e.removePoliciesFromAdapter(sec, ptype, affected)
+			}
+			return false, err
 		}
 	}
 
 	return true, nil
 }
 
+// addPoliciesToAdapter adds rules with the batch API of the adapter if it is available, or one by one otherwise.
+// In the latter case, the rules already added are removed again when adding a rule fails.
+func (e *Enforcer) addPoliciesToAdapter(sec string, ptype string, rules [][]string) error {
+	if batchAdapter, ok := e.adapter.(persist.BatchAdapter); ok {
+		return batchAdapter.AddPolicies(sec, ptype, rules)
+	}
+
+	for i, rule := range rules {
+		if err := e.adapter.AddPolicy(sec, ptype, rule); err != nil {
+			for _, added := range rules[:i] {
+				_ = e.adapter.RemovePolicy(sec, ptype, added)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// removePoliciesFromAdapter removes rules with the batch API of the adapter if it is available, or one by one otherwise.
+func (e *Enforcer) removePoliciesFromAdapter(sec string, ptype string, rules [][]string) error {
+	if batchAdapter, ok := e.adapter.(persist.BatchAdapter); ok {
+		return batchAdapter.RemovePolicies(sec, ptype, rules)
+	}
+
+	for _, rule := range rules {
+		if err := e.adapter.RemovePolicy(sec, ptype, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // removePolicy removes a rule from the current policy.
 func (e *Enforcer) removePolicyWithoutNotify(sec string, ptype string, rule []string) (bool, error) {
 	if e.dispatcher != nil && e.autoNotifyDispatcher {
@@ -130,8 +194,12 @@ func (e *Enforcer) updatePolicyWithoutNotify(sec string, ptype string, oldRule [
 		return true, e.dispatcher.UpdatePolicy(sec, ptype, oldRule, newRule)
 	}
 
+	if !e.model.HasPolicy(sec, ptype, oldRule) {
+		return false, nil
+	}
+
 	if e.shouldPersist() {
-		if err := e.adapter.(persist.UpdatableAdapter).UpdatePolicy(sec, ptype, oldRule, newRule); err != nil {
+		if err := e.updatePolicyInAdapter(sec, ptype, oldRule, newRule); err != nil {
 			if err.Error() != notImplemented {
 				return false, err
 			}
@@ -156,6 +224,23 @@ func (e *Enforcer) updatePolicyWithoutNotify(sec string, ptype string, oldRule [
 	return ruleUpdated, nil
 }
 
+// updatePolicyInAdapter updates a rule with the UpdatePolicy of the adapter if it is available,
+// or adds the new rule and removes the old one otherwise, the new rule being removed again if removing the old one fails.
+func (e *Enforcer) updatePolicyInAdapter(sec string, ptype string, oldRule []string, newRule []string) error {
+	if updatableAdapter, ok := e.adapter.(persist.UpdatableAdapter); ok {
+		return updatableAdapter.UpdatePolicy(sec, ptype, oldRule, newRule)
+	}
+
+	if err := e.adapter.AddPolicy(sec, ptype, newRule); err != nil {
+		return err
+	}
+	if err := e.adapter.RemovePolicy(sec, ptype, oldRule); err != nil {
+		_ = e.adapter.RemovePolicy(sec, ptype, newRule)
+		return err
+	}
+	return nil
+}
+
 func (e *Enforcer) updatePoliciesWithoutNotify(sec string, ptype string, oldRules [][]string, newRules [][]string) (bool, error) {
 	if len(newRules) != len(oldRules) {
 		return false, fmt.Errorf("the length of oldRules should be equal to the length of newRules, but got the length of oldRules is %d, the length of newRules is %d", len(oldRules), len(newRules))
@@ -165,8 +250,15 @@ func (e *Enforcer) updatePoliciesWithoutNotify(sec string, ptype string, oldRule
 		return true, e.dispatcher.UpdatePolicies(sec, ptype, oldRules, newRules)
 	}
 
+	// the rules are updated all or none
+	for _, oldRule := range oldRules {
+		if !e.model.HasPolicy(sec, ptype, oldRule) {
+			return false, nil
+		}
+	}
+
 	if e.shouldPersist() {
-		if err := e.adapter.(persist.UpdatableAdapter).UpdatePolicies(sec, ptype, oldRules, newRules); err != nil {
+		if err := e.updatePoliciesInAdapter(sec, ptype, oldRules, newRules); err != nil {
 			if err.Error() != notImplemented {
 				return false, err
 			}
@@ -180,18 +272,42 @@ func (e *Enforcer) updatePoliciesWithoutNotify(sec string, ptype string, oldRule
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, oldRules) // remove the old rules
-		if err != nil {
-			return ruleUpdated, err
+		if err == nil {
+			err = e.BuildIncrementalRoleLinks(model.PolicyAdd, ptype, newRules) // add the new rules
 		}
-		err = e.BuildIncrementalRoleLinks(model.PolicyAdd, ptype, newRules) // add the new rules
 		if err != nil {
-			return ruleUpdated, err
+			// the rules are restored in the storage as well
+			e.model.UpdatePolicies(sec, ptype, newRules, oldRules)
+			_ = e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, newRules)
+			_ = e.BuildIncrementalRoleLinks(model.PolicyAdd, ptype, oldRules)
+			if e.shouldPersist() {
+				_ = e.updatePoliciesInAdapter(sec, ptype, newRules, oldRules)
+			}
+			return false, err
 		}
 	}
 
 	return ruleUpdated, nil
 }
 
+// updatePoliciesInAdapter updates rules with the UpdatePolicies of the adapter if it is available,
+// or one by one otherwise. In the latter case, the rules already updated are restored when updating a rule fails.
+func (e *Enforcer) updatePoliciesInAdapter(sec string, ptype string, oldRules [][]string, newRules [][]string) error {
+	if updatableAdapter, ok := e.adapter.(persist.UpdatableAdapter); ok {
+		return updatableAdapter.UpdatePolicies(sec, ptype, oldRules, newRules)
+	}
+
+	for i := range oldRules {
+		if err := e.updatePolicyInAdapter(sec, ptype, oldRules[i], newRules[i]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				_ = e.updatePolicyInAdapter(sec, ptype, newRules[j], oldRules[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // removePolicies removes rules from the current policy.
 func (e *Enforcer) removePoliciesWithoutNotify(sec string, ptype string, rules [][]string) (bool, error) {
 	if !e.model.HasPolicies(sec, ptype, rules) {
@@ -224,37 +340,43 @@ func (e *Enforcer) removePoliciesWithoutNotify(sec string, ptype string, rules [
 	return rulesRemoved, nil
 }
 
-// removeFilteredPolicy removes rules based on field filters from the current policy.
-func (e *Enforcer) removeFilteredPolicyWithoutNotify(sec string, ptype string, fieldIndex int, fieldValues []string) (bool, error) {
+// removeFilteredPolicyWithoutNotify removes rules based on field filters from the current policy,
+// the removed rules are returned as well.
+func (e *Enforcer) removeFilteredPolicyWithoutNotify(sec string, ptype string, fieldIndex int, fieldValues []string) (bool, [][]string, error) {
 	if len(fieldValues) == 0 {
-		return false, Err.ErrInvalidFieldValuesParameter
+		return false, nil, Err.ErrInvalidFieldValuesParameter
 	}
 
 	if e.dispatcher != nil && e.autoNotifyDispatcher {
-		return true, e.dispatcher.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+		// the rules are removed once dispatched, so the ones to be removed are reported.
+		var effects [][]string
+		if _, ok := e.model[sec][ptype]; ok {
+			effects = e.model.GetFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+		}
+		return true, effects, e.dispatcher.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
 	}
 
 	if e.shouldPersist() {
 		if err := e.adapter.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...); err != nil {
 			if err.Error() != notImplemented {
-				return false, err
+				return false, nil, err
 			}
 		}
 	}
 
 	ruleRemoved, effects := e.model.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
 	if !ruleRemoved {
-		return ruleRemoved, nil
+		return ruleRemoved, nil, nil
 	}
 
 	if sec == "g" {
 		err := e.BuildIncrementalRoleLinks(model.PolicyRemove, ptype, effects)
 		if err != nil {
-			return ruleRemoved, err
+			return ruleRemoved, effects, err
 		}
 	}
 
-	return ruleRemoved, nil
+	return ruleRemoved, effects, nil
 }
 
 func (e *Enforcer) updateFilteredPoliciesWithoutNotify(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
@@ -304,6 +426,7 @@ func (e *Enforcer) updateFilteredPoliciesWithoutNotify(sec string, ptype string,
 
 // addPolicy adds a rule to the current policy.
 func (e *Enforcer) addPolicy(sec string, ptype string, rule []string) (bool, error) {
+	rule = e.trimRule(rule)
 	ok, err := e.addPolicyWithoutNotify(sec, ptype, rule)
 	if !ok || err != nil {
 		return ok, err
@@ -311,14 +434,17 @@ func (e *Enforcer) addPolicy(sec string, ptype string, rule []string) (bool, err
 
 	if e.shouldNotify() {
 		var err error
-		if watcher, ok := e.watcher.(persist.WatcherEx); ok {
+		if watcher, ok := e.watcher.(persist.IncrementalWatcher); ok {
 			err = watcher.UpdateForAddPolicy(sec, ptype, rule...)
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, nil, [][]string{rule}, false)
 	return true, nil
 }
 
@@ -326,6 +452,11 @@ func (e *Enforcer) addPolicy(sec string, ptype string, rule []string) (bool, err
 // If autoRemoveRepeat == true, existing rules are automatically filtered
 // Otherwise, false is returned directly
 func (e *Enforcer) addPolicies(sec string, ptype string, rules [][]string, autoRemoveRepeat bool) (bool, error) {
+	rules = e.trimRules(rules)
+	var added [][]string
+	if e.journaling() {
+		added = e.missingRules(sec, ptype, rules)
+	}
 	ok, err := e.addPoliciesWithoutNotify(sec, ptype, rules, autoRemoveRepeat)
 	if !ok || err != nil {
 		return ok, err
@@ -338,14 +469,18 @@ func (e *Enforcer) addPolicies(sec string, ptype string, rules [][]string, autoR
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, nil, added, false)
 	return true, nil
 }
 
 // removePolicy removes a rule from the current policy.
 func (e *Enforcer) removePolicy(sec string, ptype string, rule []string) (bool, error) {
+	rule = e.trimRule(rule)
 	ok, err := e.removePolicyWithoutNotify(sec, ptype, rule)
 	if !ok || err != nil {
 		return ok, err
@@ -353,19 +488,22 @@ func (e *Enforcer) removePolicy(sec string, ptype string, rule []string) (bool,
 
 	if e.shouldNotify() {
 		var err error
-		if watcher, ok := e.watcher.(persist.WatcherEx); ok {
+		if watcher, ok := e.watcher.(persist.IncrementalWatcher); ok {
 			err = watcher.UpdateForRemovePolicy(sec, ptype, rule...)
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
-
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, [][]string{rule}, nil, false)
 	return true, nil
 }
 
 func (e *Enforcer) updatePolicy(sec string, ptype string, oldRule []string, newRule []string) (bool, error) {
+	oldRule, newRule = e.trimRule(oldRule), e.trimRule(newRule)
 	ok, err := e.updatePolicyWithoutNotify(sec, ptype, oldRule, newRule)
 	if !ok || err != nil {
 		return ok, err
@@ -378,13 +516,17 @@ func (e *Enforcer) updatePolicy(sec string, ptype string, oldRule []string, newR
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, [][]string{oldRule}, [][]string{newRule}, true)
 	return true, nil
 }
 
 func (e *Enforcer) updatePolicies(sec string, ptype string, oldRules [][]string, newRules [][]string) (bool, error) {
+	oldRules, newRules = e.trimRules(oldRules), e.trimRules(newRules)
 	ok, err := e.updatePoliciesWithoutNotify(sec, ptype, oldRules, newRules)
 	if !ok || err != nil {
 		return ok, err
@@ -397,14 +539,22 @@ func (e *Enforcer) updatePolicies(sec string, ptype string, oldRules [][]string,
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, oldRules, newRules, true)
 	return true, nil
 }
 
 // removePolicies removes rules from the current policy.
 func (e *Enforcer) removePolicies(sec string, ptype string, rules [][]string) (bool, error) {
+	rules = e.trimRules(rules)
+	var removed [][]string
+	if e.journaling() {
+		removed = e.presentRules(sec, ptype, rules)
+	}
 	ok, err := e.removePoliciesWithoutNotify(sec, ptype, rules)
 	if !ok || err != nil {
 		return ok, err
@@ -417,17 +567,73 @@ func (e *Enforcer) removePolicies(sec string, ptype string, rules [][]string) (b
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, removed, nil, false)
+	return true, nil
+}
+
+// policyRemoval is the rules of a policy type removed by removePoliciesOfTypes.
+type policyRemoval struct {
+	sec   string
+	ptype string
+	rules [][]string
+}
+
+// removePoliciesOfTypes removes the rules of several policy types as a single operation: the role links of each grouping
+// policy type are updated once, the rules already removed are restored if a removal fails, and the watcher is notified once.
+func (e *Enforcer) removePoliciesOfTypes(removals []policyRemoval) (bool, error) {
+	var done []policyRemoval
+	for _, removal := range removals {
+		if len(removal.rules) == 0 {
+			continue
+		}
+		ok, err := e.removePoliciesWithoutNotify(removal.sec, removal.ptype, removal.rules)
+		if err != nil {
+			for _, d := range done {
+				if _, restoreErr := e.addPoliciesWithoutNotify(d.sec, d.ptype, d.rules, false); restoreErr != nil {
+					err = fmt.Errorf("%v, and restoring the rules of %s failed: %v", err, d.ptype, restoreErr)
+				}
+			}
+			return false, err
+		}
+		if ok {
+			done = append(done, removal)
+		}
+	}
+	if len(done) == 0 {
+		return false, nil
+	}
+	if e.shouldNotify() {
+		if err := e.notifySavePolicy(); err != nil {
+			return true, err
+		}
+	}
+
+	op := make(journalOperation, 0, len(done))
+	for _, d := range done {
+		op = append(op, journalChange{sec: d.sec, ptype: d.ptype, removed: d.rules})
+	}
+	e.recordChanges(op)
 	return true, nil
 }
 
 // removeFilteredPolicy removes rules based on field filters from the current policy.
 func (e *Enforcer) removeFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues []string) (bool, error) {
-	ok, err := e.removeFilteredPolicyWithoutNotify(sec, ptype, fieldIndex, fieldValues)
+	ok, _, err := e.removeFilteredPolicyReturnsEffects(sec, ptype, fieldIndex, fieldValues)
+	return ok, err
+}
+
+// removeFilteredPolicyReturnsEffects removes rules based on field filters from the current policy,
+// the removed rules are returned as well.
+func (e *Enforcer) removeFilteredPolicyReturnsEffects(sec string, ptype string, fieldIndex int, fieldValues []string) (bool, [][]string, error) {
+	fieldValues = e.trimRule(fieldValues)
+	ok, effects, err := e.removeFilteredPolicyWithoutNotify(sec, ptype, fieldIndex, fieldValues)
 	if !ok || err != nil {
-		return ok, err
+		return ok, effects, err
 	}
 
 	if e.shouldNotify() {
@@ -437,13 +643,17 @@ func (e *Enforcer) removeFilteredPolicy(sec string, ptype string, fieldIndex int
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, effects, err
+		}
 	}
 
-	return true, nil
+	e.recordOperation(sec, ptype, effects, nil, false)
+	return true, effects, nil
 }
 
 func (e *Enforcer) updateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) (bool, error) {
+	newRules, fieldValues = e.trimRules(newRules), e.trimRule(fieldValues)
 	oldRules, err := e.updateFilteredPoliciesWithoutNotify(sec, ptype, newRules, fieldIndex, fieldValues...)
 	ok := len(oldRules) != 0
 	if !ok || err != nil {
@@ -457,9 +667,12 @@ func (e *Enforcer) updateFilteredPolicies(sec string, ptype string, newRules [][
 		} else {
 			err = e.watcher.Update()
 		}
-		return true, err
+		if err != nil {
+			return true, err
+		}
 	}
 
+	e.recordOperation(sec, ptype, oldRules, newRules, false)
 	return true, nil
 }
 
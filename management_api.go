@@ -17,40 +17,79 @@ package casbin
 import (
 	"errors"
 	"fmt"
-	"strings"
+	"sort"
 
 	"github.com/Knetic/govaluate"
+	"github.com/casbin/casbin/v2/constant"
+	Err "github.com/casbin/casbin/v2/errors"
+	"github.com/casbin/casbin/v2/persist"
 	"github.com/casbin/casbin/v2/util"
 )
 
-// GetAllSubjects gets the list of subjects that show up in the current policy.
+// GetAllSubjects gets the list of subjects that show up in the current policy of all ptypes.
 func (e *Enforcer) GetAllSubjects() []string {
-	return e.model.GetValuesForFieldInPolicyAllTypes("p", 0)
+	return e.getAllFieldValues(constant.SubjectIndex, 0)
 }
 
 // GetAllNamedSubjects gets the list of subjects that show up in the current named policy.
+// The position of the field is resolved from the policy definition of ptype, and the values are sorted.
 func (e *Enforcer) GetAllNamedSubjects(ptype string) []string {
-	return e.model.GetValuesForFieldInPolicy("p", ptype, 0)
+	return e.getAllNamedFieldValues(ptype, constant.SubjectIndex, 0)
 }
 
-// GetAllObjects gets the list of objects that show up in the current policy.
+// GetAllObjects gets the list of objects that show up in the current policy of all ptypes.
 func (e *Enforcer) GetAllObjects() []string {
-	return e.model.GetValuesForFieldInPolicyAllTypes("p", 1)
+	return e.getAllFieldValues(constant.ObjectIndex, 1)
 }
 
 // GetAllNamedObjects gets the list of objects that show up in the current named policy.
+// The position of the field is resolved from the policy definition of ptype, and the values are sorted.
 func (e *Enforcer) GetAllNamedObjects(ptype string) []string {
-	return e.model.GetValuesForFieldInPolicy("p", ptype, 1)
+	return e.getAllNamedFieldValues(ptype, constant.ObjectIndex, 1)
 }
 
-// GetAllActions gets the list of actions that show up in the current policy.
+// GetAllActions gets the list of actions that show up in the current policy of all ptypes.
 func (e *Enforcer) GetAllActions() []string {
-	return e.model.GetValuesForFieldInPolicyAllTypes("p", 2)
+	return e.getAllFieldValues(constant.ActionIndex, 2)
 }
 
 // GetAllNamedActions gets the list of actions that show up in the current named policy.
+// The position of the field is resolved from the policy definition of ptype, and the values are sorted.
 func (e *Enforcer) GetAllNamedActions(ptype string) []string {
-	return e.model.GetValuesForFieldInPolicy("p", ptype, 2)
+	return e.getAllNamedFieldValues(ptype, constant.ActionIndex, 2)
+}
+
+// getAllNamedFieldValues gets the sorted distinct values of a field in the named policy.
+// The index of the field is looked up in the tokens of the policy definition, defaultIndex is used if it is not found.
+func (e *Enforcer) getAllNamedFieldValues(ptype string, field string, defaultIndex int) []string {
+	assertion, ok := e.model["p"][ptype]
+	if !ok {
+		return []string{}
+	}
+
+	index, err := e.GetFieldIndex(ptype, field)
+	if err != nil {
+		index = defaultIndex
+	}
+	if index >= len(assertion.Tokens) {
+		return []string{}
+	}
+
+	values := e.model.GetValuesForFieldInPolicy("p", ptype, index)
+	sort.Strings(values)
+	return values
+}
+
+// getAllFieldValues gets the distinct values of a field in the policy of all ptypes,
+// the index of the field is resolved for each ptype like in getAllNamedFieldValues.
+func (e *Enforcer) getAllFieldValues(field string, defaultIndex int) []string {
+	values := []string{}
+	for ptype := range e.model["p"] {
+		values = append(values, e.getAllNamedFieldValues(ptype, field, defaultIndex)...)
+	}
+
+	util.ArrayRemoveDuplicates(&values)
+	return values
 }
 
 // GetAllRoles gets the list of roles that show up in the current policy.
@@ -58,9 +97,11 @@ func (e *Enforcer) GetAllRoles() []string {
 	return e.model.GetValuesForFieldInPolicyAllTypes("g", 1)
 }
 
-// GetAllNamedRoles gets the list of roles that show up in the current named policy.
+// GetAllNamedRoles gets the list of roles that show up in the current named policy, the values are sorted.
 func (e *Enforcer) GetAllNamedRoles(ptype string) []string {
-	return e.model.GetValuesForFieldInPolicy("g", ptype, 1)
+	values := e.model.GetValuesForFieldInPolicy("g", ptype, 1)
+	sort.Strings(values)
+	return values
 }
 
 // GetPolicy gets all the authorization rules in the policy.
@@ -78,22 +119,112 @@ func (e *Enforcer) GetNamedPolicy(ptype string) [][]string {
 	return e.model.GetPolicy("p", ptype)
 }
 
+// RangePolicy calls fn for each rule of the named policy or grouping policy ptype, like "p" or "g2", in order,
+// until fn returns false. The rules are not copied like GetNamedPolicy does: fn must not modify rule nor keep it
+// after returning. The model lock is held for reading during the iteration, so fn must not load the policy or
+// change the model, and the iteration blocks the reloads of the policy.
+func (e *Enforcer) RangePolicy(ptype string, fn func(rule []string) bool) {
+	e.modelLock.RLock()
+	defer e.modelLock.RUnlock()
+
+	if ptype == "" {
+		return
+	}
+	ast, ok := e.model[ptype[:1]][ptype]
+	if !ok {
+		return
+	}
+	for _, rule := range ast.Policy {
+		if !fn(rule) {
+			return
+		}
+	}
+}
+
+// CountPolicy returns the number of rules of the ptype in the sec section ("p" or "g") in the storage, without
+// loading them. The adapter must implement persist.CountingAdapter, otherwise Err.ErrCountingNotSupported is returned.
+func (e *Enforcer) CountPolicy(sec string, ptype string) (int, error) {
+	adapter, ok := e.adapter.(persist.CountingAdapter)
+	if !ok {
+		return 0, Err.ErrCountingNotSupported
+	}
+	return adapter.CountPolicy(sec, ptype)
+}
+
+// CountLoadedPolicy returns the number of rules of the ptype in the sec section ("p" or "g") in the loaded policy.
+func (e *Enforcer) CountLoadedPolicy(sec string, ptype string) int {
+	return e.model.CountPolicy(sec, ptype)
+}
+
+// GetPolicySortedByPriority gets all the authorization rules in the named policy in their effective priority order,
+// which is the order the rules are evaluated in.
+func (e *Enforcer) GetPolicySortedByPriority(ptype string) ([][]string, error) {
+	return e.model.GetPolicySortedByPriority(ptype)
+}
+
+// GetSubjectHierarchyOrder gets the indices of the authorization rules in the named policy in the order they are
+// sorted by the subjectPriority effect, the rules of the deepest subjects of the role hierarchy coming first.
+// The original order is returned if the policy effect is not subjectPriority, or if the model has no role definition.
+func (e *Enforcer) GetSubjectHierarchyOrder(ptype string) ([]int, error) {
+	return e.model.GetSubjectHierarchyOrder(ptype)
+}
+
+// GetSubjectHierarchyDepths gets the depth of each subject in the role hierarchy used by the subjectPriority effect,
+// the subjects with a domain being given as "domain::subject".
+func (e *Enforcer) GetSubjectHierarchyDepths() (map[string]int, error) {
+	return e.model.GetSubjectHierarchyDepths()
+}
+
 // GetFilteredNamedPolicy gets all the authorization rules in the named policy, field filters can be specified.
 func (e *Enforcer) GetFilteredNamedPolicy(ptype string, fieldIndex int, fieldValues ...string) [][]string {
 	return e.model.GetFilteredPolicy("p", ptype, fieldIndex, fieldValues...)
 }
 
+// GetFilteredPolicyWithIndices gets all the authorization rules in the policy, field filters can be specified.
+// The positions of the matched rules in the policy are returned as well, so they can be used to update the exact rows.
+func (e *Enforcer) GetFilteredPolicyWithIndices(fieldIndex int, fieldValues ...string) ([][]string, []int, error) {
+	return e.GetFilteredNamedPolicyWithIndices("p", fieldIndex, fieldValues...)
+}
+
+// GetFilteredNamedPolicyWithIndices gets all the authorization rules in the named policy together with their indices, field filters can be specified.
+func (e *Enforcer) GetFilteredNamedPolicyWithIndices(ptype string, fieldIndex int, fieldValues ...string) ([][]string, []int, error) {
+	assertion, ok := e.model["p"][ptype]
+	if !ok {
+		return nil, nil, fmt.Errorf("policy type %s does not exist", ptype)
+	}
+	if fieldIndex < 0 || fieldIndex+len(fieldValues) > len(assertion.Tokens) {
+		return nil, nil, fmt.Errorf("invalid field index: %d, the policy only has %d fields", fieldIndex, len(assertion.Tokens))
+	}
+
+	rules, indices := e.model.GetFilteredPolicyWithIndices("p", ptype, fieldIndex, fieldValues...)
+	return rules, indices, nil
+}
+
 // GetGroupingPolicy gets all the role inheritance rules in the policy.
 func (e *Enforcer) GetGroupingPolicy() [][]string {
 	return e.GetNamedGroupingPolicy("g")
 }
 
+// GetAllGroupingPolicies gets copies of the role inheritance rules of all the grouping policy types, keyed by ptype (like "g" or "g2").
+func (e *Enforcer) GetAllGroupingPolicies() map[string][][]string {
+	res := make(map[string][][]string, len(e.model["g"]))
+	for ptype, ast := range e.model["g"] {
+		rules := make([][]string, 0, len(ast.Policy))
+		for _, rule := range ast.Policy {
+			rules = append(rules, deepCopyPolicy(rule))
+		}
+		res[ptype] = rules
+	}
+	return res
+}
+
 // GetFilteredGroupingPolicy gets all the role inheritance rules in the policy, field filters can be specified.
 func (e *Enforcer) GetFilteredGroupingPolicy(fieldIndex int, fieldValues ...string) [][]string {
 	return e.GetFilteredNamedGroupingPolicy("g", fieldIndex, fieldValues...)
 }
 
-// GetNamedGroupingPolicy gets all the role inheritance rules in the policy.
+// GetNamedGroupingPolicy gets all the role inheritance rules of the grouping policy type ptype, like "g2".
+// The result is empty if ptype is not defined in the model.
 func (e *Enforcer) GetNamedGroupingPolicy(ptype string) [][]string {
 	return e.model.GetPolicy("g", ptype)
 }
@@ -103,17 +234,15 @@ func (e *Enforcer) GetFilteredNamedGroupingPolicy(ptype string, fieldIndex int,
 	return e.model.GetFilteredPolicy("g", ptype, fieldIndex, fieldValues...)
 }
 
-// GetFilteredNamedPolicyWithMatcher gets rules based on matcher from the policy.
+// GetFilteredNamedPolicyWithMatcher gets copies of the rules of the named policy for which matcher, an expression
+// of the policy tokens like "keyMatch(p.obj, '/admin/*')", evaluates to true.
 func (e *Enforcer) GetFilteredNamedPolicyWithMatcher(ptype string, matcher string) ([][]string, error) {
 	var res [][]string
 	var err error
 
-	functions := e.fm.GetFunctions()
-	if _, ok := e.model["g"]; ok {
-		for key, ast := range e.model["g"] {
-			rm := ast.RM
-			functions[key] = util.GenerateGFunction(rm)
-		}
+	assertion, ok := e.model["p"][ptype]
+	if !ok {
+		return res, fmt.Errorf("policy type %s does not exist", ptype)
 	}
 
 	var expString string
@@ -123,15 +252,22 @@ func (e *Enforcer) GetFilteredNamedPolicyWithMatcher(ptype string, matcher strin
 		expString = util.RemoveComments(util.EscapeAssertion(matcher))
 	}
 
-	var expression *govaluate.EvaluableExpression
+	if err = e.buildDirtyRoleLinks(expString); err != nil {
+		return res, err
+	}
+
+	functions := e.fm.GetFunctions()
+	e.addGFunctions(functions, nil)
+
+	var expression Expression
 
-	expression, err = govaluate.NewEvaluableExpressionWithFunctions(expString, functions)
+	expression, err = e.compile(expString, functions)
 	if err != nil {
 		return res, err
 	}
 
-	pTokens := make(map[string]int, len(e.model["p"][ptype].Tokens))
-	for i, token := range e.model["p"][ptype].Tokens {
+	pTokens := make(map[string]int, len(assertion.Tokens))
+	for i, token := range assertion.Tokens {
 		pTokens[token] = i
 	}
 
@@ -139,41 +275,108 @@ func (e *Enforcer) GetFilteredNamedPolicyWithMatcher(ptype string, matcher strin
 		pTokens: pTokens,
 	}
 
-	if policyLen := len(e.model["p"][ptype].Policy); policyLen != 0 && strings.Contains(expString, ptype+"_") {
-		for _, pvals := range e.model["p"][ptype].Policy {
-			if len(e.model["p"][ptype].Tokens) != len(pvals) {
-				return res, fmt.Errorf(
-					"invalid policy size: expected %d, got %d, pvals: %v",
-					len(e.model["p"][ptype].Tokens),
-					len(pvals),
-					pvals)
-			}
+	for _, pvals := range assertion.Policy {
+		if len(assertion.Tokens) != len(pvals) {
+			return res, fmt.Errorf(
+				"%w: expected %d, got %d, pvals: %v",
+				Err.ErrInvalidPolicySize,
+				len(assertion.Tokens),
+				len(pvals),
+				pvals)
+		}
 
-			parameters.pVals = pvals
+		parameters.pVals = pvals
 
-			result, err := expression.Eval(parameters)
+		result, err := expression.Eval(parameters)
 
-			if err != nil {
-				return res, err
+		if err != nil {
+			return res, err
+		}
+
+		switch result := result.(type) {
+		case bool:
+			if result {
+				res = append(res, deepCopyPolicy(pvals))
+			}
+		case float64:
+			if result != 0 {
+				res = append(res, deepCopyPolicy(pvals))
 			}
+		default:
+			return res, errors.New("matcher result should be bool, int or float")
+		}
+	}
+	return res, nil
+}
 
-			switch result := result.(type) {
-			case bool:
-				if result {
-					res = append(res, pvals)
-				}
-			case float64:
-				if result != 0 {
-					res = append(res, pvals)
-				}
-			default:
-				return res, errors.New("matcher result should be bool, int or float")
+// FindConflictingPolicies finds the pairs of "p" rules with opposite effects that may match a common request,
+// each pair is returned as {allow rule, deny rule}. Two values overlap when they are equal, when one matches
+// the other by keyMatch (so "*" and "/data/*" are handled), or, for the subject, when one inherits the other
+// through the role manager of "g". It is a static analysis, so it can report pairs that no real request hits.
+// The rules returned are copies, and the rules without an effect are skipped.
+func (e *Enforcer) FindConflictingPolicies() ([][2][]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	ast, ok := e.model["p"]["p"]
+	if !ok {
+		return nil, errors.New("the policy type p does not exist")
+	}
+	eftIndex := -1
+	for i, token := range ast.Tokens {
+		if token == "p_eft" {
+			eftIndex = i
+			break
+		}
+	}
+	res := [][2][]string{}
+	if eftIndex == -1 {
+		return res, nil
+	}
+	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
+	if err != nil {
+		subIndex = 0
+	}
+
+	for _, allow := range ast.Policy {
+		if len(allow) <= eftIndex || allow[eftIndex] != "allow" {
+			continue
+		}
+		for _, deny := range ast.Policy {
+			if len(deny) <= eftIndex || deny[eftIndex] != "deny" {
+				continue
+			}
+			if e.policiesOverlap(allow, deny, eftIndex, subIndex) {
+				res = append(res, [2][]string{deepCopyPolicy(allow), deepCopyPolicy(deny)})
 			}
 		}
 	}
 	return res, nil
 }
 
+// policiesOverlap determines whether all the fields of rule1 and rule2 except the effect overlap.
+func (e *Enforcer) policiesOverlap(rule1, rule2 []string, eftIndex, subIndex int) bool {
+	for i := range rule1 {
+		if i == eftIndex || i >= len(rule2) {
+			continue
+		}
+		v1, v2 := rule1[i], rule2[i]
+		if v1 == v2 || util.KeyMatch(v1, v2) || util.KeyMatch(v2, v1) {
+			continue
+		}
+		if rm, ok := e.rmMap["g"]; ok && i == subIndex {
+			if ok, _ := rm.HasLink(v1, v2); ok {
+				continue
+			}
+			if ok, _ := rm.HasLink(v2, v1); ok {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
+
 // HasPolicy determines whether an authorization rule exists.
 func (e *Enforcer) HasPolicy(params ...interface{}) bool {
 	return e.HasNamedPolicy("p", params...)
@@ -181,16 +384,33 @@ func (e *Enforcer) HasPolicy(params ...interface{}) bool {
 
 // HasNamedPolicy determines whether a named authorization rule exists.
 func (e *Enforcer) HasNamedPolicy(ptype string, params ...interface{}) bool {
+	policy, ok := paramsToRule(params)
+	if !ok {
+		return false
+	}
+
+	return e.model.HasPolicy("p", ptype, e.trimRule(policy))
+}
+
+// paramsToRule converts the parameters of the management APIs, either a []string
+// or strings, to a rule. It returns false if they are empty or not strings.
+func paramsToRule(params []interface{}) ([]string, bool) {
+	if len(params) == 0 {
+		return nil, false
+	}
 	if strSlice, ok := params[0].([]string); len(params) == 1 && ok {
-		return e.model.HasPolicy("p", ptype, strSlice)
+		return strSlice, len(strSlice) != 0
 	}
 
-	policy := make([]string, 0)
+	rule := make([]string, 0, len(params))
 	for _, param := range params {
-		policy = append(policy, param.(string))
+		str, ok := param.(string)
+		if !ok {
+			return nil, false
+		}
+		rule = append(rule, str)
 	}
-
-	return e.model.HasPolicy("p", ptype, policy)
+	return rule, true
 }
 
 // AddPolicy adds an authorization rule to the current policy.
@@ -254,6 +474,8 @@ func (e *Enforcer) UpdatePolicy(oldPolicy []string, newPolicy []string) (bool, e
 	return e.UpdateNamedPolicy("p", oldPolicy, newPolicy)
 }
 
+// UpdateNamedPolicy updates an authorization rule from the current named policy in place, notifying the watcher once.
+// It returns false without error if the old rule doesn't exist.
 func (e *Enforcer) UpdateNamedPolicy(ptype string, p1 []string, p2 []string) (bool, error) {
 	return e.updatePolicy("p", ptype, p1, p2)
 }
@@ -263,6 +485,8 @@ func (e *Enforcer) UpdatePolicies(oldPolices [][]string, newPolicies [][]string)
 	return e.UpdateNamedPolicies("p", oldPolices, newPolicies)
 }
 
+// UpdateNamedPolicies updates authorization rules from the current named policy, notifying the watcher once.
+// The rules are updated all or none: it returns false without error if one of the old rules doesn't exist.
 func (e *Enforcer) UpdateNamedPolicies(ptype string, p1 [][]string, p2 [][]string) (bool, error) {
 	return e.updatePolicies("p", ptype, p1, p2)
 }
@@ -308,6 +532,42 @@ func (e *Enforcer) RemoveFilteredNamedPolicy(ptype string, fieldIndex int, field
 	return e.removeFilteredPolicy("p", ptype, fieldIndex, fieldValues)
 }
 
+// RemoveFilteredNamedPolicyReturnsEffects removes authorization rules from the current named policy like
+// RemoveFilteredNamedPolicy, and also returns the removed rules, so that they can be logged or added back.
+func (e *Enforcer) RemoveFilteredNamedPolicyReturnsEffects(ptype string, fieldIndex int, fieldValues ...string) (bool, [][]string, error) {
+	return e.removeFilteredPolicyReturnsEffects("p", ptype, fieldIndex, fieldValues)
+}
+
+// RemoveFilteredPolicyMatch removes the authorization rules whose field at fieldIndex satisfies matcher,
+// like util.GlobMatch or util.KeyMatch bound to a pattern.
+func (e *Enforcer) RemoveFilteredPolicyMatch(fieldIndex int, matcher func(string) bool) (bool, error) {
+	return e.RemoveFilteredNamedPolicyMatch("p", fieldIndex, matcher)
+}
+
+// RemoveFilteredNamedPolicyMatch removes the authorization rules of the named policy whose field at fieldIndex satisfies matcher.
+func (e *Enforcer) RemoveFilteredNamedPolicyMatch(ptype string, fieldIndex int, matcher func(string) bool) (bool, error) {
+	rules := e.getFilteredPolicyMatch("p", ptype, fieldIndex, matcher)
+	if len(rules) == 0 {
+		return false, nil
+	}
+	return e.removePolicies("p", ptype, rules)
+}
+
+// getFilteredPolicyMatch gets copies of the rules whose field at fieldIndex satisfies matcher.
+func (e *Enforcer) getFilteredPolicyMatch(sec string, ptype string, fieldIndex int, matcher func(string) bool) [][]string {
+	var rules [][]string
+	ast, ok := e.model[sec][ptype]
+	if !ok {
+		return rules
+	}
+	for _, rule := range ast.Policy {
+		if fieldIndex >= 0 && fieldIndex < len(rule) && matcher(rule[fieldIndex]) {
+			rules = append(rules, deepCopyPolicy(rule))
+		}
+	}
+	return rules
+}
+
 // HasGroupingPolicy determines whether a role inheritance rule exists.
 func (e *Enforcer) HasGroupingPolicy(params ...interface{}) bool {
 	return e.HasNamedGroupingPolicy("g", params...)
@@ -315,16 +575,12 @@ func (e *Enforcer) HasGroupingPolicy(params ...interface{}) bool {
 
 // HasNamedGroupingPolicy determines whether a named role inheritance rule exists.
 func (e *Enforcer) HasNamedGroupingPolicy(ptype string, params ...interface{}) bool {
-	if strSlice, ok := params[0].([]string); len(params) == 1 && ok {
-		return e.model.HasPolicy("g", ptype, strSlice)
-	}
-
-	policy := make([]string, 0)
-	for _, param := range params {
-		policy = append(policy, param.(string))
+	policy, ok := paramsToRule(params)
+	if !ok {
+		return false
 	}
 
-	return e.model.HasPolicy("g", ptype, policy)
+	return e.model.HasPolicy("g", ptype, e.trimRule(policy))
 }
 
 // AddGroupingPolicy adds a role inheritance rule to the current policy.
@@ -352,6 +608,10 @@ func (e *Enforcer) AddGroupingPoliciesEx(rules [][]string) (bool, error) {
 // If the rule already exists, the function returns false and the rule will not be added.
 // Otherwise the function returns true by adding the new rule.
 func (e *Enforcer) AddNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
+
 	var ruleAdded bool
 	var err error
 	if strSlice, ok := params[0].([]string); len(params) == 1 && ok {
@@ -372,6 +632,9 @@ func (e *Enforcer) AddNamedGroupingPolicy(ptype string, params ...interface{}) (
 // If the rule already exists, the function returns false for the corresponding policy rule and the rule will not be added.
 // Otherwise the function returns true for the corresponding policy rule by adding the new rule.
 func (e *Enforcer) AddNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
 	return e.addPolicies("g", ptype, rules, false)
 }
 
@@ -379,6 +642,9 @@ func (e *Enforcer) AddNamedGroupingPolicies(ptype string, rules [][]string) (boo
 // If the rule already exists, the rule will not be added.
 // But unlike AddNamedGroupingPolicies, other non-existent rules are added instead of returning false directly
 func (e *Enforcer) AddNamedGroupingPoliciesEx(ptype string, rules [][]string) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
 	return e.addPolicies("g", ptype, rules, true)
 }
 
@@ -399,6 +665,10 @@ func (e *Enforcer) RemoveFilteredGroupingPolicy(fieldIndex int, fieldValues ...s
 
 // RemoveNamedGroupingPolicy removes a role inheritance rule from the current named policy.
 func (e *Enforcer) RemoveNamedGroupingPolicy(ptype string, params ...interface{}) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
+
 	var ruleRemoved bool
 	var err error
 	if strSlice, ok := params[0].([]string); len(params) == 1 && ok {
@@ -417,6 +687,9 @@ func (e *Enforcer) RemoveNamedGroupingPolicy(ptype string, params ...interface{}
 
 // RemoveNamedGroupingPolicies removes role inheritance rules from the current named policy.
 func (e *Enforcer) RemoveNamedGroupingPolicies(ptype string, rules [][]string) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
 	return e.removePolicies("g", ptype, rules)
 }
 
@@ -430,21 +703,63 @@ func (e *Enforcer) UpdateGroupingPolicies(oldRules [][]string, newRules [][]stri
 }
 
 func (e *Enforcer) UpdateNamedGroupingPolicy(ptype string, oldRule []string, newRule []string) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
 	return e.updatePolicy("g", ptype, oldRule, newRule)
 }
 
 func (e *Enforcer) UpdateNamedGroupingPolicies(ptype string, oldRules [][]string, newRules [][]string) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
 	return e.updatePolicies("g", ptype, oldRules, newRules)
 }
 
 // RemoveFilteredNamedGroupingPolicy removes a role inheritance rule from the current named policy, field filters can be specified.
 func (e *Enforcer) RemoveFilteredNamedGroupingPolicy(ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
+	if err := e.checkGroupingType(ptype); err != nil {
+		return false, err
+	}
 	return e.removeFilteredPolicy("g", ptype, fieldIndex, fieldValues)
 }
 
-// AddFunction adds a customized function.
+// checkGroupingType returns an error if the grouping policy type ptype, like "g2", is not defined in the model.
+func (e *Enforcer) checkGroupingType(ptype string) error {
+	if _, ok := e.model["g"][ptype]; !ok {
+		return fmt.Errorf("%w: %s", Err.ErrGroupingTypeNotFound, ptype)
+	}
+	return nil
+}
+
+// AddFunction adds a customized function, which can be called by the matchers and by the sub-rules of eval().
+// A function already added under the same name, like the built-in keyMatch, is kept.
+// It is safe to call while requests are being enforced: the functions are taken per enforcement, and the compiled
+// matchers are invalidated under the model lock, so that they pick up the function, like one added for a grouping
+// policy type preserved by PreserveGroupingFunction.
 func (e *Enforcer) AddFunction(name string, function govaluate.ExpressionFunction) {
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
 	e.fm.AddFunction(name, function)
+	e.invalidateMatcherMap()
+}
+
+// ContextFunction is a customized function which also receives the request being enforced,
+// as a map from the request tokens (like r_sub or r_dom) to their values.
+type ContextFunction func(request map[string]interface{}, args ...interface{}) (interface{}, error)
+
+// AddFunctionWithContext adds a customized function which also receives the whole request,
+// so that it can use any request value (like r.dom) even if the matcher doesn't pass it as an argument.
+// It takes precedence over a function of the same name added by AddFunction.
+// The matcher expressions are not cached once such a function is added, as they hold the request.
+func (e *Enforcer) AddFunctionWithContext(name string, function ContextFunction) {
+	e.modelLock.Lock()
+	defer e.modelLock.Unlock()
+	if e.contextFunctions == nil {
+		e.contextFunctions = make(map[string]ContextFunction)
+	}
+	e.contextFunctions[name] = function
+	e.invalidateMatcherMap()
 }
 
 func (e *Enforcer) SelfAddPolicy(sec string, ptype string, rule []string) (bool, error) {
@@ -468,7 +783,8 @@ func (e *Enforcer) SelfRemovePolicies(sec string, ptype string, rules [][]string
 }
 
 func (e *Enforcer) SelfRemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) (bool, error) {
-	return e.removeFilteredPolicyWithoutNotify(sec, ptype, fieldIndex, fieldValues)
+	ok, _, err := e.removeFilteredPolicyWithoutNotify(sec, ptype, fieldIndex, fieldValues)
+	return ok, err
 }
 
 func (e *Enforcer) SelfUpdatePolicy(sec string, ptype string, oldRule, newRule []string) (bool, error) {
@@ -478,3 +794,128 @@ func (e *Enforcer) SelfUpdatePolicy(sec string, ptype string, oldRule, newRule [
 func (e *Enforcer) SelfUpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) (bool, error) {
 	return e.updatePoliciesWithoutNotify(sec, ptype, oldRules, newRules)
 }
+
+// AdapterAddPolicy adds a rule to the adapter and notifies the watcher, without changing the current policy,
+// for deployments which treat the storage as the source of truth and get the rule back on the next reload.
+func (e *Enforcer) AdapterAddPolicy(sec string, ptype string, rule []string) (bool, error) {
+	if e.adapter == nil {
+		return false, errors.New("no adapter to add the policy to")
+	}
+	if err := e.adapter.AddPolicy(sec, ptype, rule); err != nil {
+		return false, err
+	}
+
+	if e.shouldNotify() {
+		if watcher, ok := e.watcher.(persist.IncrementalWatcher); ok {
+			return true, watcher.UpdateForAddPolicy(sec, ptype, rule...)
+		}
+		return true, e.watcher.Update()
+	}
+	return true, nil
+}
+
+// AdapterAddPolicies adds rules to the adapter and notifies the watcher, without changing the current policy.
+func (e *Enforcer) AdapterAddPolicies(sec string, ptype string, rules [][]string) (bool, error) {
+	if e.adapter == nil {
+		return false, errors.New("no adapter to add the policies to")
+	}
+	if err := e.addPoliciesToAdapter(sec, ptype, rules); err != nil {
+		return false, err
+	}
+
+	if e.shouldNotify() {
+		if watcher, ok := e.watcher.(persist.WatcherEx); ok {
+			return true, watcher.UpdateForAddPolicies(sec, ptype, rules...)
+		}
+		return true, e.watcher.Update()
+	}
+	return true, nil
+}
+
+// AdapterRemovePolicy removes a rule from the adapter and notifies the watcher, without changing the current policy.
+func (e *Enforcer) AdapterRemovePolicy(sec string, ptype string, rule []string) (bool, error) {
+	if e.adapter == nil {
+		return false, errors.New("no adapter to remove the policy from")
+	}
+	if err := e.adapter.RemovePolicy(sec, ptype, rule); err != nil {
+		return false, err
+	}
+
+	if e.shouldNotify() {
+		if watcher, ok := e.watcher.(persist.IncrementalWatcher); ok {
+			return true, watcher.UpdateForRemovePolicy(sec, ptype, rule...)
+		}
+		return true, e.watcher.Update()
+	}
+	return true, nil
+}
+
+// AdapterRemovePolicies removes rules from the adapter and notifies the watcher, without changing the current policy.
+func (e *Enforcer) AdapterRemovePolicies(sec string, ptype string, rules [][]string) (bool, error) {
+	if e.adapter == nil {
+		return false, errors.New("no adapter to remove the policies from")
+	}
+	if batchAdapter, ok := e.adapter.(persist.BatchAdapter); ok {
+		if err := batchAdapter.RemovePolicies(sec, ptype, rules); err != nil {
+			return false, err
+		}
+	} else {
+		for _, rule := range rules {
+			if err := e.adapter.RemovePolicy(sec, ptype, rule); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if e.shouldNotify() {
+		if watcher, ok := e.watcher.(persist.WatcherEx); ok {
+			return true, watcher.UpdateForRemovePolicies(sec, ptype, rules...)
+		}
+		return true, e.watcher.Update()
+	}
+	return true, nil
+}
+
+// PolicyChangeSet is the set of rules changed by management calls, see DryRun. The rules are grouped
+// by policy type, like "p" or "g". An updated rule is reported as the old rule removed and the new rule added.
+type PolicyChangeSet struct {
+	Added   map[string][][]string
+	Removed map[string][][]string
+}
+
+// DryRun previews the changes of the management calls made by fn, like AddPolicies, RemovePolicies or UpdatePolicies:
+// fn is called with a clone of the enforcer (see Clone), and the rules added and removed by fn are returned
+// once it is done. The enforcer, its adapter and its watcher are left untouched. The clone has no adapter,
+// so fn should not load or save the policy.
+func (e *Enforcer) DryRun(fn func(c *Enforcer) error) (*PolicyChangeSet, error) {
+	c, err := e.Clone()
+	if err != nil {
+		return nil, err
+	}
+	c.adapter = nil
+	if err = fn(c); err != nil {
+		return nil, err
+	}
+
+	changes := &PolicyChangeSet{
+		Added:   make(map[string][][]string),
+		Removed: make(map[string][][]string),
+	}
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range c.model[sec] {
+			for _, rule := range ast.Policy {
+				if !e.model.HasPolicy(sec, ptype, rule) {
+					changes.Added[ptype] = append(changes.Added[ptype], append([]string(nil), rule...))
+				}
+			}
+		}
+		for ptype, ast := range e.model[sec] {
+			for _, rule := range ast.Policy {
+				if !c.model.HasPolicy(sec, ptype, rule) {
+					changes.Removed[ptype] = append(changes.Removed[ptype], append([]string(nil), rule...))
+				}
+			}
+		}
+	}
+	return changes, nil
+}
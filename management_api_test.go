@@ -15,8 +15,13 @@
 package casbin
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
+	Err "github.com/casbin/casbin/v2/errors"
+	"github.com/casbin/casbin/v2/model"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
 	"github.com/casbin/casbin/v2/util"
 )
 
@@ -39,6 +44,83 @@ func TestGetList(t *testing.T) {
 	testStringList(t, "Roles", e.GetAllRoles, []string{"data2_admin"})
 }
 
+func TestGetNamedList(t *testing.T) {
+	e, _ := NewEnforcer("examples/multiple_policy_definitions_model.conf", "examples/multiple_policy_definitions_policy.csv")
+
+	getList := func(f func(string) []string, ptype string) func() []string {
+		return func() []string { return f(ptype) }
+	}
+
+	testStringList(t, "Subjects of p", getList(e.GetAllNamedSubjects, "p"), []string{"data2_admin"})
+	testStringList(t, "Subjects of p2", getList(e.GetAllNamedSubjects, "p2"), []string{
+		"r2.sub.Age > 18 && r2.sub.Age < 60",
+		"r2.sub.Age > 60 && r2.sub.Age < 100"})
+	testStringList(t, "Objects of p2", getList(e.GetAllNamedObjects, "p2"), []string{"/data1"})
+	testStringList(t, "Actions of p2", getList(e.GetAllNamedActions, "p2"), []string{"read"})
+	testStringList(t, "Subjects of p3", getList(e.GetAllNamedSubjects, "p3"), []string{})
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	testStringList(t, "Objects with domains", getList(e.GetAllNamedObjects, "p"), []string{"data1", "data2"})
+	testStringList(t, "Actions with domains", getList(e.GetAllNamedActions, "p"), []string{"read", "write"})
+	testStringList(t, "All subjects with domains", e.GetAllSubjects, []string{"admin"})
+	testStringList(t, "All objects with domains", e.GetAllObjects, []string{"data1", "data2"})
+	testStringList(t, "All actions with domains", e.GetAllActions, []string{"read", "write"})
+	testStringList(t, "Roles of g", getList(e.GetAllNamedRoles, "g"), []string{"admin"})
+	testStringList(t, "Roles of g2", getList(e.GetAllNamedRoles, "g2"), []string{})
+}
+
+func TestFindConflictingPolicies(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_deny_model.conf", "examples/rbac_with_deny_policy.csv")
+
+	testConflictingPolicies(t, e, [][2][]string{
+		{{"data2_admin", "data2", "write", "allow"}, {"alice", "data2", "write", "deny"}},
+	})
+
+	_, _ = e.AddPolicy("alice", "*", "read", "deny")
+	testConflictingPolicies(t, e, [][2][]string{
+		{{"alice", "data1", "read", "allow"}, {"alice", "*", "read", "deny"}},
+		{{"data2_admin", "data2", "read", "allow"}, {"alice", "*", "read", "deny"}},
+		{{"data2_admin", "data2", "write", "allow"}, {"alice", "data2", "write", "deny"}},
+	})
+
+	// the rules are copies, and the rules without an effect are skipped
+	pairs, _ := e.FindConflictingPolicies()
+	allow, deny := append([]string(nil), pairs[0][0]...), append([]string(nil), pairs[0][1]...)
+	pairs[0][0][0], pairs[0][1][0] = "changed", "changed"
+	for _, rule := range [][]string{allow, deny} {
+		if len(e.GetFilteredPolicy(0, rule...)) != 1 {
+			t.Errorf("Policy %v was changed through the result", rule)
+		}
+	}
+	_, _ = e.AddPolicy("bob", "data1", "read")
+	if _, err := e.FindConflictingPolicies(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, _ = NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	testConflictingPolicies(t, e, [][2][]string{})
+}
+
+func testConflictingPolicies(t *testing.T, e *Enforcer, res [][2][]string) {
+	t.Helper()
+	myRes, err := e.FindConflictingPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("Conflicting policies: ", myRes)
+
+	flatten := func(pairs [][2][]string) [][]string {
+		rules := [][]string{}
+		for _, pair := range pairs {
+			rules = append(rules, append(append([]string{}, pair[0]...), pair[1]...))
+		}
+		return rules
+	}
+	if !util.Set2DEquals(flatten(res), flatten(myRes)) {
+		t.Error("Conflicting policies: ", myRes, ", supposed to be ", res)
+	}
+}
+
 func testGetPolicy(t *testing.T, e *Enforcer, res [][]string) {
 	t.Helper()
 	myRes := e.GetPolicy()
@@ -121,6 +203,19 @@ func TestGetPolicyAPI(t *testing.T) {
 		{"bob", "data2", "write"},
 		{"data2_admin", "data2", "read"},
 		{"data2_admin", "data2", "write"}})
+	testGetFilteredNamedPolicyWithMatcher(t, e, "p", "keyMatch(p.obj, 'data2*') && p.act != 'read'", [][]string{
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "write"}})
+	testGetFilteredNamedPolicyWithMatcher(t, e, "p", "1 == 2", nil)
+
+	// the rules are copies.
+	rules, _ := e.GetFilteredNamedPolicyWithMatcher("p", "p.sub == 'alice'")
+	rules[0][0] = "bob"
+	testHasPolicy(t, e, []string{"alice", "data1", "read"}, true)
+
+	if _, err := e.GetFilteredNamedPolicyWithMatcher("p2", "p2.sub == 'alice'"); err == nil {
+		t.Error("GetFilteredNamedPolicyWithMatcher should return an error for an unknown policy type")
+	}
 
 	testGetFilteredPolicy(t, e, 0, [][]string{{"alice", "data1", "read"}}, "alice")
 	testGetFilteredPolicy(t, e, 0, [][]string{{"bob", "data2", "write"}}, "bob")
@@ -158,6 +253,37 @@ func TestGetPolicyAPI(t *testing.T) {
 
 	testHasGroupingPolicy(t, e, []string{"alice", "data2_admin"}, true)
 	testHasGroupingPolicy(t, e, []string{"bob", "data2_admin"}, false)
+
+	if !e.HasPolicy("alice", "data1", "read") || e.HasPolicy("alice", "data1") {
+		t.Error("HasPolicy with variadic strings should only match the exact rule")
+	}
+	if !e.HasNamedPolicy("p", []string{"bob", "data2", "write"}) || !e.HasNamedGroupingPolicy("g", "alice", "data2_admin") {
+		t.Error("HasNamedPolicy and HasNamedGroupingPolicy should find the existing rules")
+	}
+	if e.HasPolicy() || e.HasPolicy([]string{}) || e.HasPolicy("alice", 1, "read") {
+		t.Error("HasPolicy should return false for an empty or invalid rule")
+	}
+	if e.HasNamedPolicy("p2", "alice", "data1", "read") || e.HasNamedGroupingPolicy("g2", "alice", "data2_admin") {
+		t.Error("HasNamedPolicy should return false for an unknown ptype")
+	}
+}
+
+func TestGetPolicyCopy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	policy := e.GetPolicy()
+	policy[0][0] = "bob"
+	e.GetFilteredNamedPolicy("p", 0, "bob")[0][2] = "read"
+	e.GetGroupingPolicy()[0][0] = "bob"
+
+	testGetPolicy(t, e, [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"}})
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "data2_admin"}})
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "bob", "data1", "read", false)
 }
 
 func TestModifyPolicyAPI(t *testing.T) {
@@ -241,6 +367,280 @@ func TestModifyPolicyAPI(t *testing.T) {
 	testGetPolicy(t, e, [][]string{{"user1", "data1", "read"}, {"user2", "data2", "read"}, {"user3", "data3", "read"}, {"user4", "data4", "read"}})
 }
 
+// singleRuleAdapter is an adapter without the batch API, which fails to add the rule failOn.
+type singleRuleAdapter struct {
+	rules  map[string]bool
+	failOn string
+}
+
+func (a *singleRuleAdapter) LoadPolicy(model model.Model) error {
+	return nil
+}
+
+func (a *singleRuleAdapter) SavePolicy(model model.Model) error {
+	return nil
+}
+
+func (a *singleRuleAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	key := strings.Join(rule, ",")
+	if key == a.failOn {
+		return errors.New("failed to add " + key)
+	}
+	a.rules[key] = true
+	return nil
+}
+
+func (a *singleRuleAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	delete(a.rules, strings.Join(rule, ","))
+	return nil
+}
+
+func (a *singleRuleAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return errors.New("not implemented")
+}
+
+func TestAddPoliciesAllOrNothing(t *testing.T) {
+	a := &singleRuleAdapter{rules: map[string]bool{}, failOn: "cathy,data3,read"}
+	e, _ := NewEnforcer("examples/rbac_model.conf", a)
+
+	ok, err := e.AddPolicies([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}, {"cathy", "data3", "read"}})
+	if ok || err == nil {
+		t.Errorf("AddPolicies() with a failing rule: %t, %v, supposed to be false with an error", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{})
+	if len(a.rules) != 0 {
+		t.Errorf("Adapter rules: %v, supposed to be empty", a.rules)
+	}
+
+	ok, err = e.AddPolicies([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	if !ok || err != nil {
+		t.Errorf("AddPolicies(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+
+	ok, _ = e.AddPolicies([][]string{{"alice", "data2", "read"}, {"bob", "data2", "write"}})
+	if ok {
+		t.Error("AddPolicies() with an existing rule should add nothing")
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	if len(a.rules) != 2 {
+		t.Errorf("Adapter rules: %v, supposed to be 2 rules", a.rules)
+	}
+
+	// the rules are removed from the adapter again when building their role links fails
+	ok, err = e.AddGroupingPolicies([][]string{{"alice", "admin"}, {"bob"}})
+	if ok || err == nil {
+		t.Errorf("AddGroupingPolicies() with an invalid rule: %t, %v, supposed to be false with an error", ok, err)
+	}
+	testGetGroupingPolicy(t, e, [][]string{})
+	if len(a.rules) != 2 {
+		t.Errorf("Adapter rules: %v, supposed to be 2 rules", a.rules)
+	}
+}
+
+func TestUpdatePolicyWithoutUpdatableAdapter(t *testing.T) {
+	a := &singleRuleAdapter{rules: map[string]bool{}, failOn: "cathy,data3,read"}
+	e, _ := NewEnforcer("examples/rbac_model.conf", a)
+	_, _ = e.AddPolicy("alice", "data1", "read")
+	w := &SampleWatcherIncremental{}
+	_ = e.SetWatcher(w)
+
+	ok, err := e.UpdatePolicy([]string{"alice", "data1", "read"}, []string{"alice", "data2", "read"})
+	if !ok || err != nil {
+		t.Errorf("UpdatePolicy(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data2", "read"}})
+	if len(a.rules) != 1 || !a.rules["alice,data2,read"] {
+		t.Errorf("Adapter rules: %v, supposed to be [alice,data2,read]", a.rules)
+	}
+	if w.updates != 1 || len(w.added) != 0 || len(w.removed) != 0 {
+		t.Errorf("Watcher notifications: %v, %v, %d, supposed to be a single update", w.added, w.removed, w.updates)
+	}
+
+	ok, err = e.UpdatePolicy([]string{"bob", "data1", "read"}, []string{"bob", "data2", "read"})
+	if ok || err != nil {
+		t.Errorf("UpdatePolicy() of a missing rule: %t, %v, supposed to be false, nil", ok, err)
+	}
+
+	ok, err = e.UpdatePolicy([]string{"alice", "data2", "read"}, []string{"cathy", "data3", "read"})
+	if ok || err == nil {
+		t.Errorf("UpdatePolicy() with a failing adapter: %t, %v, supposed to be false with an error", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data2", "read"}})
+	if len(a.rules) != 1 || !a.rules["alice,data2,read"] || w.updates != 1 {
+		t.Errorf("Adapter rules: %v, watcher updates: %d, supposed to be unchanged", a.rules, w.updates)
+	}
+}
+
+func TestUpdatePoliciesWithoutUpdatableAdapter(t *testing.T) {
+	a := &singleRuleAdapter{rules: map[string]bool{}, failOn: "cathy,data3,read"}
+	e, _ := NewEnforcer("examples/rbac_model.conf", a)
+	_, _ = e.AddPolicies([][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	w := &SampleWatcherIncremental{}
+	_ = e.SetWatcher(w)
+
+	if ok, err := e.UpdatePolicies([][]string{{"alice", "data1", "read"}}, [][]string{}); ok || err == nil {
+		t.Errorf("UpdatePolicies() with different lengths: %t, %v, supposed to be false with an error", ok, err)
+	}
+	ok, err := e.UpdatePolicies(
+		[][]string{{"alice", "data1", "read"}, {"cathy", "data1", "read"}},
+		[][]string{{"alice", "data1", "write"}, {"cathy", "data1", "write"}})
+	if ok || err != nil {
+		t.Errorf("UpdatePolicies() with a missing rule: %t, %v, supposed to be false, nil", ok, err)
+	}
+	ok, err = e.UpdatePolicies(
+		[][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}},
+		[][]string{{"alice", "data1", "write"}, {"cathy", "data3", "read"}})
+	if ok || err == nil {
+		t.Errorf("UpdatePolicies() with a failing adapter: %t, %v, supposed to be false with an error", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	if len(a.rules) != 2 || !a.rules["alice,data1,read"] || !a.rules["bob,data2,write"] || w.updates != 0 {
+		t.Errorf("Adapter rules: %v, watcher updates: %d, supposed to be unchanged", a.rules, w.updates)
+	}
+
+	ok, err = e.UpdatePolicies(
+		[][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}},
+		[][]string{{"alice", "data1", "write"}, {"bob", "data3", "write"}})
+	if !ok || err != nil {
+		t.Errorf("UpdatePolicies(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "write"}, {"bob", "data3", "write"}})
+	if len(a.rules) != 2 || !a.rules["alice,data1,write"] || !a.rules["bob,data3,write"] || w.updates != 1 {
+		t.Errorf("Adapter rules: %v, watcher updates: %d, supposed to be updated once", a.rules, w.updates)
+	}
+
+	// the rules are restored in the adapter when building the new role links fails
+	_, _ = e.AddGroupingPolicy("alice", "admin")
+	ok, err = e.UpdateGroupingPolicies([][]string{{"alice", "admin"}}, [][]string{{"alice"}})
+	if ok || err == nil {
+		t.Errorf("UpdateGroupingPolicies() with an invalid rule: %t, %v, supposed to be false with an error", ok, err)
+	}
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "admin"}})
+	if len(a.rules) != 3 || !a.rules["alice,admin"] || a.rules["alice"] {
+		t.Errorf("Adapter rules: %v, supposed to be restored", a.rules)
+	}
+}
+
+func TestAdapterPolicyAPI(t *testing.T) {
+	a := &singleRuleAdapter{rules: map[string]bool{}, failOn: "cathy,data3,read"}
+	e, _ := NewEnforcer("examples/rbac_model.conf", a)
+	w := &SampleWatcherIncremental{}
+	_ = e.SetWatcher(w)
+
+	if ok, err := e.AdapterAddPolicy("p", "p", []string{"alice", "data1", "read"}); !ok || err != nil {
+		t.Errorf("AdapterAddPolicy(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	if ok, err := e.AdapterAddPolicies("p", "p", [][]string{{"bob", "data2", "write"}, {"cathy", "data3", "read"}}); ok || err == nil {
+		t.Errorf("AdapterAddPolicies() with a failing rule: %t, %v, supposed to be false with an error", ok, err)
+	}
+	if ok, err := e.AdapterAddPolicies("p", "p", [][]string{{"bob", "data2", "write"}}); !ok || err != nil {
+		t.Errorf("AdapterAddPolicies(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	if ok, err := e.AdapterRemovePolicy("p", "p", []string{"alice", "data1", "read"}); !ok || err != nil {
+		t.Errorf("AdapterRemovePolicy(): %t, %v, supposed to be true, nil", ok, err)
+	}
+
+	// the current policy is not changed
+	testGetPolicy(t, e, [][]string{})
+	if !util.SetEquals([]string{"bob,data2,write"}, func() []string {
+		var rules []string
+		for rule := range a.rules {
+			rules = append(rules, rule)
+		}
+		return rules
+	}()) {
+		t.Errorf("Adapter rules: %v, supposed to be [bob,data2,write]", a.rules)
+	}
+	if !util.Array2DEquals([][]string{{"p", "alice", "data1", "read"}}, w.added) ||
+		!util.Array2DEquals([][]string{{"p", "alice", "data1", "read"}}, w.removed) || w.updates != 1 {
+		t.Errorf("Watcher notifications: %v, %v, %d", w.added, w.removed, w.updates)
+	}
+
+	if ok, err := e.AdapterRemovePolicies("p", "p", [][]string{{"bob", "data2", "write"}}); !ok || err != nil {
+		t.Errorf("AdapterRemovePolicies(): %t, %v, supposed to be true, nil", ok, err)
+	}
+	if len(a.rules) != 0 || w.updates != 2 {
+		t.Errorf("Adapter rules: %v, watcher updates: %d", a.rules, w.updates)
+	}
+}
+
+func TestRemoveFilteredNamedPolicyReturnsEffects(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	ok, effects, err := e.RemoveFilteredNamedPolicyReturnsEffects("p", 1, "data2")
+	if !ok || err != nil {
+		t.Errorf("RemoveFilteredNamedPolicyReturnsEffects(): %t, %v", ok, err)
+	}
+	if !util.Array2DEquals([][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}}, effects) {
+		t.Errorf("removed rules: %v", effects)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}})
+
+	ok, effects, err = e.RemoveFilteredNamedPolicyReturnsEffects("p", 1, "data2")
+	if ok || len(effects) != 0 || err != nil {
+		t.Errorf("RemoveFilteredNamedPolicyReturnsEffects() without matching rules: %t, %v, %v", ok, effects, err)
+	}
+
+	// the removed rules can be added back.
+	e, _ = NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	_, effects, _ = e.RemoveFilteredNamedPolicyReturnsEffects("p", 0, "data2_admin")
+	_, _ = e.AddPolicies(effects)
+	testEnforce(t, e, "alice", "data2", "write", true)
+}
+
+func TestDryRun(t *testing.T) {
+	a := &singleRuleAdapter{rules: map[string]bool{}}
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e.SetAdapter(a)
+	w := &SampleWatcherIncremental{}
+	_ = e.SetWatcher(w)
+
+	changes, err := e.DryRun(func(c *Enforcer) error {
+		if _, err := c.AddPolicies([][]string{{"cathy", "data3", "read"}, {"cathy", "data3", "write"}}); err != nil {
+			return err
+		}
+		if _, err := c.RemovePolicies([][]string{{"bob", "data2", "write"}}); err != nil {
+			return err
+		}
+		if _, err := c.UpdatePolicy([]string{"data2_admin", "data2", "read"}, []string{"data2_admin", "data3", "read"}); err != nil {
+			return err
+		}
+		_, err := c.AddGroupingPolicy("cathy", "data2_admin")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in DryRun: %v", err)
+	}
+	if !util.Array2DEquals([][]string{{"data2_admin", "data3", "read"}, {"cathy", "data3", "read"}, {"cathy", "data3", "write"}}, changes.Added["p"]) ||
+		!util.Array2DEquals([][]string{{"cathy", "data2_admin"}}, changes.Added["g"]) {
+		t.Errorf("DryRun() added rules: %v", changes.Added)
+	}
+	if !util.Array2DEquals([][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "read"}}, changes.Removed["p"]) ||
+		len(changes.Removed["g"]) != 0 {
+		t.Errorf("DryRun() removed rules: %v", changes.Removed)
+	}
+
+	// the enforcer, its adapter and its watcher are untouched.
+	testGetPolicy(t, e, [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"},
+	})
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "data2_admin"}})
+	testEnforce(t, e, "cathy", "data2", "write", false)
+	if len(a.rules) != 0 || len(w.added) != 0 || len(w.removed) != 0 || w.updates != 0 {
+		t.Errorf("DryRun() changed the adapter or notified the watcher: %v, %v, %v, %d", a.rules, w.added, w.removed, w.updates)
+	}
+
+	if _, err = e.DryRun(func(c *Enforcer) error {
+		return errors.New("rejected")
+	}); err == nil {
+		t.Error("DryRun() should return the error of fn")
+	}
+}
+
 func TestModifyGroupingPolicyAPI(t *testing.T) {
 	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
 
@@ -296,6 +696,13 @@ func TestModifyGroupingPolicyAPI(t *testing.T) {
 	testGetUsers(t, e, []string{}, "data1_admin")
 	testGetUsers(t, e, []string{}, "data2_admin")
 	testGetUsers(t, e, []string{"eve"}, "data3_admin")
+	// data2_admin has no users but has permissions, while data1_admin is unknown
+	if _, err := e.GetUsersForRole("data2_admin"); err != nil {
+		t.Errorf("GetUsersForRole(data2_admin): %v, supposed to be nil", err)
+	}
+	if _, err := e.GetUsersForRole("data1_admin"); err != Err.ErrNameNotFound {
+		t.Errorf("GetUsersForRole(data1_admin): %v, supposed to be %v", err, Err.ErrNameNotFound)
+	}
 	_, _ = e.AddGroupingPolicy("data3_admin", "data4_admin")
 	_, _ = e.UpdateGroupingPolicy([]string{"eve", "data3_admin"}, []string{"eve", "admin"})
 	_, _ = e.UpdateGroupingPolicy([]string{"data3_admin", "data4_admin"}, []string{"admin", "data4_admin"})
@@ -323,3 +730,339 @@ func TestModifyGroupingPolicyAPI(t *testing.T) {
 	_, _ = e.AddNamedGroupingPoliciesEx("g", [][]string{{"user1", "member"}, {"user2", "member"}, {"user3", "member"}})
 	testGetUsers(t, e, []string{"user1", "user2", "user3"}, "member")
 }
+
+func TestGetFilteredPolicyWithIndices(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	rules, indices, err := e.GetFilteredPolicyWithIndices(1, "data2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !util.Array2DEquals(rules, [][]string{{"bob", "data2", "write"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}}) {
+		t.Error("Policy for data2: ", rules)
+	}
+	if !util.SetEqualsInt(indices, []int{1, 2, 3}) {
+		t.Error("Indices for data2: ", indices, ", supposed to be [1 2 3]")
+	}
+
+	policy := e.GetPolicy()
+	for i, index := range indices {
+		if !util.ArrayEquals(policy[index], rules[i]) {
+			t.Error("Rule at index ", index, ": ", policy[index], ", supposed to be ", rules[i])
+		}
+	}
+
+	// the rules are copies, changing them does not change the policy.
+	rules[0][0] = "eve"
+	testHasPolicy(t, e, []string{"bob", "data2", "write"}, true)
+	testHasPolicy(t, e, []string{"eve", "data2", "write"}, false)
+	testEnforce(t, e, "eve", "data2", "write", false)
+
+	_, _, err = e.GetFilteredPolicyWithIndices(2, "read", "extra")
+	if err == nil {
+		t.Error("GetFilteredPolicyWithIndices should return an error for an out of range field index")
+	}
+
+	_, _, err = e.GetFilteredNamedPolicyWithIndices("p2", 0, "alice")
+	if err == nil {
+		t.Error("GetFilteredNamedPolicyWithIndices should return an error for an unknown policy type")
+	}
+}
+
+func TestGetAllGroupingPolicies(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_resource_roles_model.conf", "examples/rbac_with_resource_roles_policy.csv")
+
+	res := e.GetAllGroupingPolicies()
+	if len(res) != 2 {
+		t.Fatal("Grouping policies: ", res, ", supposed to have 2 grouping policy types")
+	}
+	if !util.Array2DEquals(res["g"], [][]string{{"alice", "data_group_admin"}}) {
+		t.Error("Grouping policies of g: ", res["g"])
+	}
+	if !util.Array2DEquals(res["g2"], [][]string{{"data1", "data_group"}, {"data2", "data_group"}}) {
+		t.Error("Grouping policies of g2: ", res["g2"])
+	}
+
+	// the result is a copy of the policy
+	res["g"][0][0] = "bob"
+	testHasGroupingPolicy(t, e, []string{"alice", "data_group_admin"}, true)
+}
+
+func TestGetPolicySortedByPriority(t *testing.T) {
+	e, _ := NewEnforcer("examples/priority_model_explicit.conf", "examples/priority_policy_explicit.csv")
+
+	// the policy is sorted by LoadPolicy
+	res, err := e.GetPolicySortedByPriority("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !util.Array2DEquals(res, e.GetPolicy()) {
+		t.Error("Policy sorted by priority: ", res, ", supposed to be ", e.GetPolicy())
+	}
+
+	// a rule appended behind the model is still returned in its priority order
+	ast := e.GetModel()["p"]["p"]
+	ast.Policy = append(ast.Policy, []string{"0", "carol", "data1", "read", "allow"})
+	res, _ = e.GetPolicySortedByPriority("p")
+	if !util.ArrayEquals(res[0], []string{"0", "carol", "data1", "read", "allow"}) || len(res) != len(ast.Policy) {
+		t.Error("Policy sorted by priority: ", res)
+	}
+
+	if _, err = e.GetPolicySortedByPriority("p2"); err == nil {
+		t.Error("GetPolicySortedByPriority() should fail for an unknown policy type")
+	}
+}
+
+func TestRemoveFilteredPolicyMatch(t *testing.T) {
+	e, _ := NewEnforcer("examples/keymatch_model.conf", "examples/keymatch_policy.csv")
+
+	matchBobData := func(obj string) bool { return util.KeyMatch(obj, "/bob_data/*") }
+	ok, err := e.RemoveFilteredPolicyMatch(1, matchBobData)
+	if err != nil || !ok {
+		t.Fatalf("RemoveFilteredPolicyMatch() = %t, %v, supposed to remove the rules", ok, err)
+	}
+	for _, rule := range e.GetPolicy() {
+		if matchBobData(rule[1]) {
+			t.Error("Rule ", rule, " should be removed")
+		}
+	}
+	testEnforce(t, e, "bob", "/bob_data/resource1", "POST", false)
+	testEnforce(t, e, "alice", "/alice_data/resource1", "GET", true)
+
+	ok, _ = e.RemoveFilteredPolicyMatch(1, matchBobData)
+	if ok {
+		t.Error("RemoveFilteredPolicyMatch() should not affect anything when no rule matches")
+	}
+}
+
+func TestOperationJournal(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	// the operations are not recorded unless the journal is enabled.
+	_, _ = e.AddPolicy("cathy", "data3", "read")
+	if ok, err := e.Undo(); ok || err != nil {
+		t.Errorf("Undo() without the journal: %t, %v", ok, err)
+	}
+
+	e.EnableOperationJournal(true)
+	w := &SampleWatcherIncremental{}
+	_ = e.SetWatcher(w)
+
+	_, _ = e.AddPolicy("eve", "data3", "read")
+	_, _ = e.RemovePolicy("bob", "data2", "write")
+	_, _ = e.UpdatePolicy([]string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	_, _ = e.RemoveFilteredPolicy(0, "data2_admin")
+	_, _ = e.AddGroupingPolicy("eve", "data2_admin")
+	_, _ = e.AddPoliciesEx([][]string{{"eve", "data3", "read"}, {"eve", "data3", "write"}})
+	testEnforce(t, e, "eve", "data3", "write", true)
+
+	for i := 0; i < 6; i++ {
+		if ok, err := e.Undo(); !ok || err != nil {
+			t.Fatalf("Undo() #%d: %t, %v", i, ok, err)
+		}
+	}
+	if ok, err := e.Undo(); ok || err != nil {
+		t.Errorf("Undo() without any operation left: %t, %v", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{
+		{"alice", "data1", "read"},
+		{"cathy", "data3", "read"},
+		{"data2_admin", "data2", "read"},
+		{"data2_admin", "data2", "write"},
+		{"bob", "data2", "write"},
+	})
+	testGetGroupingPolicy(t, e, [][]string{{"alice", "data2_admin"}})
+	// the watcher is notified of the reverted operations.
+	if !util.Array2DEquals([][]string{{"p", "eve", "data3", "read"}, {"g", "eve", "data2_admin"}, {"p", "bob", "data2", "write"}}, w.added) ||
+		!util.Array2DEquals([][]string{{"p", "bob", "data2", "write"}, {"p", "eve", "data3", "write"}, {"g", "eve", "data2_admin"}, {"p", "eve", "data3", "read"}}, w.removed) {
+		t.Errorf("watcher: added %v, removed %v", w.added, w.removed)
+	}
+
+	for i := 0; i < 6; i++ {
+		if ok, err := e.Redo(); !ok || err != nil {
+			t.Fatalf("Redo() #%d: %t, %v", i, ok, err)
+		}
+	}
+	testGetPolicy(t, e, [][]string{
+		{"alice", "data1", "write"},
+		{"cathy", "data3", "read"},
+		{"eve", "data3", "read"},
+		{"eve", "data3", "write"},
+	})
+	testEnforce(t, e, "eve", "data2", "read", false)
+
+	// a new operation drops the undone ones.
+	_, _ = e.Undo()
+	_, _ = e.RemovePolicy("cathy", "data3", "read")
+	if ok, err := e.Redo(); ok || err != nil {
+		t.Errorf("Redo() after a new operation: %t, %v", ok, err)
+	}
+
+	// disabling the journal drops the recorded operations.
+	e.EnableOperationJournal(false)
+	e.EnableOperationJournal(true)
+	if ok, err := e.Undo(); ok || err != nil {
+		t.Errorf("Undo() on a fresh journal: %t, %v", ok, err)
+	}
+
+	// an operation which can no longer be reverted is kept.
+	_, _ = e.AddPolicy("frank", "data1", "read")
+	_, _ = e.SelfRemovePolicy("p", "p", []string{"frank", "data1", "read"})
+	if ok, err := e.Undo(); ok || err != nil {
+		t.Errorf("Undo() of a rule removed meanwhile: %t, %v", ok, err)
+	}
+	_, _ = e.SelfAddPolicy("p", "p", []string{"frank", "data1", "read"})
+	if ok, err := e.Undo(); !ok || err != nil {
+		t.Errorf("Undo() of a rule added back: %t, %v", ok, err)
+	}
+	testHasPolicy(t, e, []string{"frank", "data1", "read"}, false)
+
+	// an operation which returned an error is not recorded.
+	if _, err := e.AddGroupingPolicy("frank"); err == nil {
+		t.Errorf("AddGroupingPolicy() of an incomplete rule succeeded")
+	}
+	if ok, err := e.Undo(); ok || err != nil {
+		t.Errorf("Undo() of a failed operation: %t, %v", ok, err)
+	}
+	_, _ = e.RemoveGroupingPolicy("frank")
+
+	// the journal is bounded.
+	e.SetOperationJournalSize(2)
+	for _, sub := range []string{"g1", "g2", "g3"} {
+		_, _ = e.AddPolicy(sub, "data1", "read")
+	}
+	_, _ = e.Undo()
+	_, _ = e.Undo()
+	if ok, _ := e.Undo(); ok {
+		t.Errorf("Undo() beyond the journal size succeeded")
+	}
+	testHasPolicy(t, e, []string{"g1", "data1", "read"}, true)
+	testHasPolicy(t, e, []string{"g2", "data1", "read"}, false)
+
+	// the journal is dropped when the policy is reloaded.
+	_, _ = e.AddPolicy("g4", "data1", "read")
+	_ = e.LoadPolicy()
+	if ok, _ := e.Undo(); ok {
+		t.Errorf("Undo() after LoadPolicy succeeded")
+	}
+}
+
+func TestNamedGroupingPolicy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_resource_roles_model.conf", "examples/rbac_with_resource_roles_policy.csv")
+
+	if !util.Array2DEquals([][]string{{"data1", "data_group"}, {"data2", "data_group"}}, e.GetNamedGroupingPolicy("g2")) {
+		t.Errorf("GetNamedGroupingPolicy(g2): %v", e.GetNamedGroupingPolicy("g2"))
+	}
+
+	// the role links of g2 only are changed.
+	testEnforce(t, e, "alice", "data3", "write", false)
+	if ok, err := e.AddNamedGroupingPolicy("g2", "data3", "data_group"); !ok || err != nil {
+		t.Errorf("AddNamedGroupingPolicy(g2): %t, %v", ok, err)
+	}
+	testEnforce(t, e, "alice", "data3", "write", true)
+	testEnforce(t, e, "data3", "data_group_admin", "write", false)
+	if ok, err := e.RemoveNamedGroupingPolicy("g2", "data1", "data_group"); !ok || err != nil {
+		t.Errorf("RemoveNamedGroupingPolicy(g2): %t, %v", ok, err)
+	}
+	testEnforce(t, e, "alice", "data1", "write", false)
+	testEnforce(t, e, "alice", "data2", "write", true)
+
+	// an unknown grouping policy type is reported instead of panicking.
+	if policy := e.GetNamedGroupingPolicy("g3"); policy == nil || len(policy) != 0 {
+		t.Errorf("GetNamedGroupingPolicy(g3): %v, supposed to be empty", policy)
+	}
+	if _, err := e.AddNamedGroupingPolicy("g3", "alice", "admin"); !errors.Is(err, Err.ErrGroupingTypeNotFound) {
+		t.Errorf("AddNamedGroupingPolicy(g3): %v, supposed to be %v", err, Err.ErrGroupingTypeNotFound)
+	}
+	if _, err := e.RemoveNamedGroupingPolicy("g3", "alice", "admin"); !errors.Is(err, Err.ErrGroupingTypeNotFound) {
+		t.Errorf("RemoveNamedGroupingPolicy(g3): %v, supposed to be %v", err, Err.ErrGroupingTypeNotFound)
+	}
+	if _, err := e.AddNamedGroupingPolicies("g3", [][]string{{"alice", "admin"}}); !errors.Is(err, Err.ErrGroupingTypeNotFound) {
+		t.Errorf("AddNamedGroupingPolicies(g3): %v, supposed to be %v", err, Err.ErrGroupingTypeNotFound)
+	}
+	if _, err := e.RemoveFilteredNamedGroupingPolicy("g3", 0, "alice"); !errors.Is(err, Err.ErrGroupingTypeNotFound) {
+		t.Errorf("RemoveFilteredNamedGroupingPolicy(g3): %v, supposed to be %v", err, Err.ErrGroupingTypeNotFound)
+	}
+}
+
+// countingAdapter is a file adapter counting the rules of the file.
+type countingAdapter struct {
+	*fileadapter.Adapter
+}
+
+func (a *countingAdapter) CountPolicy(sec string, ptype string) (int, error) {
+	m, err := model.NewModelFromFile("examples/rbac_model.conf")
+	if err != nil {
+		return 0, err
+	}
+	if err = a.LoadPolicy(m); err != nil {
+		return 0, err
+	}
+	return m.CountPolicy(sec, ptype), nil
+}
+
+func TestCountPolicy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if _, err := e.CountPolicy("p", "p"); !errors.Is(err, Err.ErrCountingNotSupported) {
+		t.Errorf("CountPolicy(): %v, supposed to be %v", err, Err.ErrCountingNotSupported)
+	}
+
+	testCount := func(sec string, ptype string, stored int, loaded int) {
+		t.Helper()
+		if count, err := e.CountPolicy(sec, ptype); count != stored || err != nil {
+			t.Errorf("CountPolicy(%s, %s): %d, %v, supposed to be %d", sec, ptype, count, err, stored)
+		}
+		if count := e.CountLoadedPolicy(sec, ptype); count != loaded {
+			t.Errorf("CountLoadedPolicy(%s, %s): %d, supposed to be %d", sec, ptype, count, loaded)
+		}
+	}
+
+	e.SetAdapter(&countingAdapter{fileadapter.NewAdapter("examples/rbac_policy.csv")})
+	testCount("p", "p", 4, 4)
+	testCount("g", "g", 1, 1)
+	testCount("g", "g2", 0, 0)
+
+	// the loaded policy is counted apart from the storage.
+	e.EnableAutoSave(false)
+	_, _ = e.AddPolicy("eve", "data3", "read")
+	testCount("p", "p", 4, 5)
+}
+
+func TestRangePolicy(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	var rules [][]string
+	e.RangePolicy("p", func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+	if !util.Array2DEquals(rules, e.GetPolicy()) {
+		t.Errorf("RangePolicy(p): %v, supposed to be %v", rules, e.GetPolicy())
+	}
+
+	// the iteration stops once fn returns false.
+	rules = nil
+	e.RangePolicy("p", func(rule []string) bool {
+		rules = append(rules, rule)
+		return len(rules) < 2
+	})
+	if !util.Array2DEquals(rules, e.GetPolicy()[:2]) {
+		t.Errorf("RangePolicy(p): %v, supposed to stop after 2 rules", rules)
+	}
+
+	rules = nil
+	e.RangePolicy("g", func(rule []string) bool {
+		rules = append(rules, rule)
+		return true
+	})
+	if !util.Array2DEquals(rules, [][]string{{"alice", "data2_admin"}}) {
+		t.Errorf("RangePolicy(g): %v, supposed to be %v", rules, [][]string{{"alice", "data2_admin"}})
+	}
+
+	for _, ptype := range []string{"", "p2", "g2"} {
+		e.RangePolicy(ptype, func(rule []string) bool {
+			t.Errorf("RangePolicy(%s): %v, supposed to be empty", ptype, rule)
+			return true
+		})
+	}
+}
@@ -104,6 +104,10 @@ func (ast *Assertion) copy() *Assertion {
 	for k, v := range ast.PolicyMap {
 		policyMap[k] = v
 	}
+	fieldIndexMap := make(map[string]int)
+	for k, v := range ast.FieldIndexMap {
+		fieldIndexMap[k] = v
+	}
 
 	newAst := &Assertion{
 		Key:           ast.Key,
@@ -111,7 +115,7 @@ func (ast *Assertion) copy() *Assertion {
 		PolicyMap:     policyMap,
 		Tokens:        tokens,
 		Policy:        policy,
-		FieldIndexMap: ast.FieldIndexMap,
+		FieldIndexMap: fieldIndexMap,
 	}
 
 	return newAst
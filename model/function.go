@@ -49,6 +49,7 @@ func LoadFunctionMap() FunctionMap {
 	fm.AddFunction("regexMatch", util.RegexMatchFunc)
 	fm.AddFunction("ipMatch", util.IPMatchFunc)
 	fm.AddFunction("globMatch", util.GlobMatchFunc)
+	fm.AddFunction("timeMatch", util.TimeMatchFunc)
 
 	return *fm
 }
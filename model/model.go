@@ -18,7 +18,7 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
-	"regexp"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
@@ -157,6 +157,18 @@ func NewModelFromString(text string) (Model, error) {
 	return m, nil
 }
 
+// NewModelFromReader creates a model from the model text read from r, like an embedded model file.
+func NewModelFromReader(r io.Reader) (Model, error) {
+	m := NewModel()
+
+	err := m.LoadModelFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
 // LoadModel loads the model from model CONF file.
 func (model Model) LoadModel(path string) error {
 	cfg, err := config.NewConfig(path)
@@ -177,6 +189,16 @@ func (model Model) LoadModelFromText(text string) error {
 	return model.loadModelFromConfig(cfg)
 }
 
+// LoadModelFromReader loads the model from the text read from r.
+func (model Model) LoadModelFromReader(r io.Reader) error {
+	cfg, err := config.NewConfigFromReader(r)
+	if err != nil {
+		return err
+	}
+
+	return model.loadModelFromConfig(cfg)
+}
+
 func (model Model) loadModelFromConfig(cfg config.ConfigInterface) error {
 	for s := range sectionNameMap {
 		loadSection(model, cfg, s)
@@ -222,28 +244,16 @@ func (model Model) SortPoliciesBySubjectHierarchy() error {
 	if model["e"]["e"].Value != constant.SubjectPriorityEffect {
 		return nil
 	}
-	subIndex := 0
 	for ptype, assertion := range model["p"] {
-		domainIndex, err := model.GetFieldIndex(ptype, constant.DomainIndex)
-		if err != nil {
-			domainIndex = -1
-		}
-		policies := assertion.Policy
-		subjectHierarchyMap, err := getSubjectHierarchyMap(model["g"]["g"].Policy)
+		order, err := model.GetSubjectHierarchyOrder(ptype)
 		if err != nil {
 			return err
 		}
-		sort.SliceStable(policies, func(i, j int) bool {
-			domain1, domain2 := defaultDomain, defaultDomain
-			if domainIndex != -1 {
-				domain1 = policies[i][domainIndex]
-				domain2 = policies[j][domainIndex]
-			}
-			name1, name2 := getNameWithDomain(domain1, policies[i][subIndex]), getNameWithDomain(domain2, policies[j][subIndex])
-			p1 := subjectHierarchyMap[name1]
-			p2 := subjectHierarchyMap[name2]
-			return p1 > p2
-		})
+		policies := make([][]string, len(order))
+		for i, index := range order {
+			policies[i] = assertion.Policy[index]
+		}
+		copy(assertion.Policy, policies)
 		for i, policy := range assertion.Policy {
 			assertion.PolicyMap[strings.Join(policy, ",")] = i
 		}
@@ -251,6 +261,62 @@ func (model Model) SortPoliciesBySubjectHierarchy() error {
 	return nil
 }
 
+// GetSubjectHierarchyOrder returns the indices of the policy rules of ptype in the order they are sorted by
+// SortPoliciesBySubjectHierarchy, the rules of the deepest subjects of the role hierarchy of "g" coming first.
+// The original order is returned if the policy effect is not subjectPriority, or if the model has no "g".
+func (model Model) GetSubjectHierarchyOrder(ptype string) ([]int, error) {
+	assertion, ok := model["p"][ptype]
+	if !ok {
+		return nil, fmt.Errorf("policy type %s is not found in the model", ptype)
+	}
+	order := make([]int, len(assertion.Policy))
+	for i := range order {
+		order[i] = i
+	}
+	if model["e"]["e"] == nil || model["e"]["e"].Value != constant.SubjectPriorityEffect || model["g"]["g"] == nil {
+		return order, nil
+	}
+
+	subIndex := 0
+	domainIndex, err := model.GetFieldIndex(ptype, constant.DomainIndex)
+	if err != nil {
+		domainIndex = -1
+	}
+	subjectHierarchyMap, err := getSubjectHierarchyMap(model["g"]["g"].Policy)
+	if err != nil {
+		return nil, err
+	}
+	depth := func(policy []string) int {
+		domain := defaultDomain
+		if domainIndex != -1 {
+			domain = policy[domainIndex]
+		}
+		return subjectHierarchyMap[getNameWithDomain(domain, policy[subIndex])]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return depth(assertion.Policy[order[i]]) > depth(assertion.Policy[order[j]])
+	})
+	return order, nil
+}
+
+// GetSubjectHierarchyDepths returns the depth of each subject in the role hierarchy of "g" used by
+// SortPoliciesBySubjectHierarchy, the roles at the root being at depth 0. The subjects of the grouping rules
+// with a domain are given as "domain::subject". The result is empty if the model has no "g".
+func (model Model) GetSubjectHierarchyDepths() (map[string]int, error) {
+	depths := make(map[string]int)
+	if model["g"]["g"] == nil {
+		return depths, nil
+	}
+	subjectHierarchyMap, err := getSubjectHierarchyMap(model["g"]["g"].Policy)
+	if err != nil {
+		return nil, err
+	}
+	for name, depth := range subjectHierarchyMap {
+		depths[strings.TrimPrefix(name, defaultDomain+defaultSeparator)] = depth
+	}
+	return depths, nil
+}
+
 func getSubjectHierarchyMap(policies [][]string) (map[string]int, error) {
 	subjectHierarchyMap := make(map[string]int)
 	// Tree structure of role
@@ -312,18 +378,7 @@ func (model Model) SortPoliciesByPriority() error {
 		if err != nil {
 			continue
 		}
-		policies := assertion.Policy
-		sort.SliceStable(policies, func(i, j int) bool {
-			p1, err := strconv.Atoi(policies[i][priorityIndex])
-			if err != nil {
-				return true
-			}
-			p2, err := strconv.Atoi(policies[j][priorityIndex])
-			if err != nil {
-				return true
-			}
-			return p1 < p2
-		})
+		sortByPriority(assertion.Policy, priorityIndex)
 		for i, policy := range assertion.Policy {
 			assertion.PolicyMap[strings.Join(policy, ",")] = i
 		}
@@ -331,26 +386,60 @@ func (model Model) SortPoliciesByPriority() error {
 	return nil
 }
 
+// GetPolicySortedByPriority gets copies of the rules of ptype in the order SortPoliciesByPriority sorts them,
+// the rules are kept in their current order if ptype has no priority field.
+func (model Model) GetPolicySortedByPriority(ptype string) ([][]string, error) {
+	assertion, ok := model["p"][ptype]
+	if !ok {
+		return nil, fmt.Errorf("the policy type %s does not exist", ptype)
+	}
+	policies := make([][]string, 0, len(assertion.Policy))
+	for _, rule := range assertion.Policy {
+		policies = append(policies, append([]string(nil), rule...))
+	}
+	if priorityIndex, err := model.GetFieldIndex(ptype, constant.PriorityIndex); err == nil {
+		sortByPriority(policies, priorityIndex)
+	}
+	return policies, nil
+}
+
+func sortByPriority(policies [][]string, priorityIndex int) {
+	sort.SliceStable(policies, func(i, j int) bool {
+		p1, err := strconv.Atoi(policies[i][priorityIndex])
+		if err != nil {
+			return true
+		}
+		p2, err := strconv.Atoi(policies[j][priorityIndex])
+		if err != nil {
+			return true
+		}
+		return p1 < p2
+	})
+}
+
+// ToText renders the model back into the text of a model file, the assertions of each section sorted by key.
 func (model Model) ToText() string {
 	tokenPatterns := make(map[string]string)
-
-	pPattern, rPattern := regexp.MustCompile("^p_"), regexp.MustCompile("^r_")
-	for _, ptype := range []string{"r", "p"} {
-		for _, token := range model[ptype][ptype].Tokens {
-			tokenPatterns[token] = rPattern.ReplaceAllString(pPattern.ReplaceAllString(token, "p."), "r.")
+	for _, sec := range []string{"r", "p"} {
+		for key, ast := range model[sec] {
+			for _, token := range ast.Tokens {
+				tokenPatterns[token] = strings.Replace(token, "_", ".", 1)
+			}
+			if sec == "p" {
+				tokenPatterns[key+"_eft"] = key + ".eft"
+			}
 		}
 	}
-	if strings.Contains(model["e"]["e"].Value, "p_eft") {
-		tokenPatterns["p_eft"] = "p.eft"
-	}
 	s := strings.Builder{}
 	writeString := func(sec string) {
-		for ptype := range model[sec] {
-			value := model[sec][ptype].Value
-			for tokenPattern, newToken := range tokenPatterns {
-				value = strings.Replace(value, tokenPattern, newToken, -1)
+		for _, key := range model.sortedKeys(sec) {
+			value := model[sec][key].Value
+			if sec != "g" {
+				for tokenPattern, newToken := range tokenPatterns {
+					value = strings.Replace(value, tokenPattern, newToken, -1)
+				}
 			}
-			s.WriteString(fmt.Sprintf("%s = %s\n", sec, value))
+			s.WriteString(fmt.Sprintf("%s = %s\n", key, value))
 		}
 	}
 	s.WriteString("[request_definition]\n")
@@ -359,9 +448,7 @@ func (model Model) ToText() string {
 	writeString("p")
 	if _, ok := model["g"]; ok {
 		s.WriteString("[role_definition]\n")
-		for ptype := range model["g"] {
-			s.WriteString(fmt.Sprintf("%s = %s\n", ptype, model["g"][ptype].Value))
-		}
+		writeString("g")
 	}
 	s.WriteString("[policy_effect]\n")
 	writeString("e")
@@ -370,6 +457,16 @@ func (model Model) ToText() string {
 	return s.String()
 }
 
+// sortedKeys returns the keys of the assertions in the section sec, sorted.
+func (model Model) sortedKeys(sec string) []string {
+	keys := make([]string, 0, len(model[sec]))
+	for key := range model[sec] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (model Model) Copy() Model {
 	newModel := NewModel()
 
@@ -16,6 +16,7 @@ package model
 
 import (
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -74,6 +75,21 @@ func TestNewModelFromString(t *testing.T) {
 	}
 }
 
+func TestNewModelFromReader(t *testing.T) {
+	f, err := os.Open(basicExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := NewModelFromReader(f)
+	if err != nil {
+		t.Errorf("model failed to load from reader: %s", err)
+	}
+	if m == nil || m["m"]["m"].Value != "r_sub == p_sub && r_obj == p_obj && r_act == p_act" {
+		t.Errorf("model loaded from reader: %v", m)
+	}
+}
+
 func TestLoadModelFromConfig(t *testing.T) {
 	m := NewModel()
 	err := m.loadModelFromConfig(basicConfig)
@@ -130,6 +146,27 @@ func TestModelToTest(t *testing.T) {
 	testModelToText(t, "r.sub == p.sub && r.obj == p.obj && p_func(r.act, p.act) && testp_func(r.act, p.act)", "r_sub == p_sub && r_obj == p_obj && p_func(r_act, p_act) && testp_func(r_act, p_act)")
 }
 
+func TestModelToTextMultipleDefinitions(t *testing.T) {
+	m, _ := NewModelFromFile("../examples/multiple_policy_definitions_model.conf")
+	expected := `[request_definition]
+r = sub, obj, act
+r2 = sub, obj, act
+[policy_definition]
+p = sub, obj, act
+p2 = sub_rule, obj, act, eft
+[role_definition]
+g = _, _
+[policy_effect]
+e = some(where (p.eft == allow))
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+m2 = eval(p2.sub_rule) && r2.obj == p2.obj && r2.act == p2.act
+`
+	if text := m.ToText(); text != expected {
+		t.Errorf("ToText(): %s, supposed to be %s", text, expected)
+	}
+}
+
 func testModelToText(t *testing.T, mData, mExpected string) {
 	m := NewModel()
 	data := map[string]string{
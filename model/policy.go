@@ -36,11 +36,21 @@ const (
 const DefaultSep = ","
 
 // BuildIncrementalRoleLinks provides incremental build the role inheritance relations.
+// sec is the section of the rules, "g" for the grouping policy types like "g" or "g2" given by ptype,
+// whose role manager in rmMap is updated. The rules of the other sections have no role links.
 func (model Model) BuildIncrementalRoleLinks(rmMap map[string]rbac.RoleManager, op PolicyOp, sec string, ptype string, rules [][]string) error {
-	if sec == "g" {
-		return model[sec][ptype].buildIncrementalRoleLinks(rmMap[ptype], op, rules)
+	if sec != "g" {
+		return nil
 	}
-	return nil
+	ast, ok := model[sec][ptype]
+	if !ok {
+		return fmt.Errorf("the grouping policy type %s does not exist", ptype)
+	}
+	rm, ok := rmMap[ptype]
+	if !ok || rm == nil {
+		return fmt.Errorf("the grouping policy type %s has no role manager", ptype)
+	}
+	return ast.buildIncrementalRoleLinks(rm, op, rules)
 }
 
 // BuildRoleLinks initializes the roles in RBAC.
@@ -101,12 +111,57 @@ func (model Model) ClearPolicy() {
 	}
 }
 
-// GetPolicy gets all rules in a policy.
+// CheckPolicyCompatibility checks whether the policy rules loaded in other fit the policy definitions of model:
+// every policy type having rules must be defined in model, the rules of "p" must have as many fields as
+// the policy definition, and the rules of "g" must have at least as many fields as the role definition.
+func (model Model) CheckPolicyCompatibility(other Model) error {
+	for _, sec := range []string{"p", "g"} {
+		for ptype, otherAst := range other[sec] {
+			if len(otherAst.Policy) == 0 {
+				continue
+			}
+			ast, ok := model[sec][ptype]
+			if !ok {
+				return fmt.Errorf("the policy type %s has %d rules but is not defined in the model", ptype, len(otherAst.Policy))
+			}
+			expected := len(ast.Tokens)
+			if sec == "g" {
+				expected = strings.Count(ast.Value, "_")
+			}
+			for _, rule := range otherAst.Policy {
+				if sec == "p" && len(rule) != expected || sec == "g" && len(rule) < expected {
+					return fmt.Errorf("the rule %v of the policy type %s does not fit the model, expected %d fields, got %d", rule, ptype, expected, len(rule))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetPolicy gets a copy of all rules in a policy, so that the policy cannot be changed through it.
+// An empty policy is returned if ptype is not defined in the model.
 func (model Model) GetPolicy(sec string, ptype string) [][]string {
-	return model[sec][ptype].Policy
+	ast, ok := model[sec][ptype]
+	if !ok {
+		return [][]string{}
+	}
+	policy := make([][]string, 0, len(ast.Policy))
+	for _, rule := range ast.Policy {
+		policy = append(policy, append([]string(nil), rule...))
+	}
+	return policy
+}
+
+// CountPolicy returns the number of rules in a policy, 0 if the policy is not defined.
+func (model Model) CountPolicy(sec string, ptype string) int {
+	ast, ok := model[sec][ptype]
+	if !ok {
+		return 0
+	}
+	return len(ast.Policy)
 }
 
-// GetFilteredPolicy gets rules based on field filters from a policy.
+// GetFilteredPolicy gets a copy of the rules based on field filters from a policy.
 func (model Model) GetFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) [][]string {
 	res := [][]string{}
 
@@ -120,13 +175,36 @@ func (model Model) GetFilteredPolicy(sec string, ptype string, fieldIndex int, f
 		}
 
 		if matched {
-			res = append(res, rule)
+			res = append(res, append([]string(nil), rule...))
 		}
 	}
 
 	return res
 }
 
+// GetFilteredPolicyWithIndices gets a copy of the rules based on field filters from a policy, together with their indices in the policy.
+func (model Model) GetFilteredPolicyWithIndices(sec string, ptype string, fieldIndex int, fieldValues ...string) ([][]string, []int) {
+	res := [][]string{}
+	indices := []int{}
+
+	for index, rule := range model[sec][ptype].Policy {
+		matched := true
+		for i, fieldValue := range fieldValues {
+			if fieldValue != "" && rule[fieldIndex+i] != fieldValue {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			res = append(res, append([]string(nil), rule...))
+			indices = append(indices, index)
+		}
+	}
+
+	return res, indices
+}
+
 // HasPolicyEx determines whether a model has the specified policy rule with error.
 func (model Model) HasPolicyEx(sec string, ptype string, rule []string) (bool, error) {
 	assertion := model[sec][ptype]
@@ -153,7 +231,11 @@ func (model Model) HasPolicyEx(sec string, ptype string, rule []string) (bool, e
 
 // HasPolicy determines whether a model has the specified policy rule.
 func (model Model) HasPolicy(sec string, ptype string, rule []string) bool {
-	_, ok := model[sec][ptype].PolicyMap[strings.Join(rule, DefaultSep)]
+	ast, ok := model[sec][ptype]
+	if !ok {
+		return false
+	}
+	_, ok = ast.PolicyMap[strings.Join(rule, DefaultSep)]
 	return ok
 }
 
@@ -168,6 +250,30 @@ func (model Model) HasPolicies(sec string, ptype string, rules [][]string) bool
 	return false
 }
 
+// TrimPolicyFields trims the surrounding whitespace of every field of the policy rules,
+// the rules which become duplicated after trimming are removed.
+func (model Model) TrimPolicyFields() {
+	for _, sec := range []string{"p", "g"} {
+		for _, ast := range model[sec] {
+			policy := make([][]string, 0, len(ast.Policy))
+			policyMap := make(map[string]int, len(ast.Policy))
+			for _, rule := range ast.Policy {
+				for i := range rule {
+					rule[i] = strings.TrimSpace(rule[i])
+				}
+				key := strings.Join(rule, DefaultSep)
+				if _, ok := policyMap[key]; ok {
+					continue
+				}
+				policyMap[key] = len(policy)
+				policy = append(policy, rule)
+			}
+			ast.Policy = policy
+			ast.PolicyMap = policyMap
+		}
+	}
+}
+
 // AddPolicy adds a policy rule to the model.
 func (model Model) AddPolicy(sec string, ptype string, rule []string) {
 	assertion := model[sec][ptype]
@@ -349,7 +455,11 @@ func (model Model) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int
 func (model Model) GetValuesForFieldInPolicy(sec string, ptype string, fieldIndex int) []string {
 	values := []string{}
 
-	for _, rule := range model[sec][ptype].Policy {
+	ast, ok := model[sec][ptype]
+	if !ok {
+		return values
+	}
+	for _, rule := range ast.Policy {
 		values = append(values, rule[fieldIndex])
 	}
 
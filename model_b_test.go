@@ -253,6 +253,20 @@ func BenchmarkABACRuleModel(b *testing.B) {
 	}
 }
 
+func BenchmarkABACRuleModelJsonRequest(b *testing.B) {
+	e, _ := NewEnforcer("examples/abac_rule_model.conf", false)
+	e.EnableAcceptJsonRequest(true)
+
+	for i := 0; i < 1000; i++ {
+		_, _ = e.AddPolicy(fmt.Sprintf("r.sub.Age > %d && r.sub.Name != 'bob'", i%100), fmt.Sprintf("data%d", i), "read")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = e.Enforce(`{"Name": "alice", "Age": 70}`, "data999", "read")
+	}
+}
+
 func BenchmarkKeyMatchModel(b *testing.B) {
 	e, _ := NewEnforcer("examples/keymatch_model.conf", "examples/keymatch_policy.csv", false)
 
@@ -16,9 +16,11 @@ package casbin
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/casbin/casbin/v2/log"
+	"github.com/casbin/casbin/v2/model"
 	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
 	"github.com/casbin/casbin/v2/rbac"
 	"github.com/casbin/casbin/v2/util"
@@ -31,6 +33,13 @@ func testEnforce(t *testing.T, e *Enforcer, sub interface{}, obj interface{}, ac
 	}
 }
 
+func testEnforceWithMatcher(t *testing.T, e *Enforcer, matcher string, sub interface{}, obj interface{}, act string, res bool) {
+	t.Helper()
+	if myRes, _ := e.EnforceWithMatcher(matcher, sub, obj, act); myRes != res {
+		t.Errorf("%s, %v, %v, %s: %t, supposed to be %t", matcher, sub, obj, act, myRes, res)
+	}
+}
+
 func testEnforceWithoutUsers(t *testing.T, e *Enforcer, obj string, act string, res bool) {
 	t.Helper()
 	if myRes, _ := e.Enforce(obj, act); myRes != res {
@@ -458,6 +467,132 @@ func TestABACJsonRequest(t *testing.T) {
 	testEnforce(t, e, sub3Json, "/data2", "write", false)
 }
 
+func TestABACJsonRequestSharedPolicyValues(t *testing.T) {
+	e, _ := NewEnforcer("examples/abac_rule_model.conf", "examples/abac_rule_policy.csv")
+	e.EnableAcceptJsonRequest(true)
+
+	_, _ = e.AddPolicy("r.sub.Age > 18", "/data3", "read")
+	_, _ = e.AddPolicy("r.sub.Name == 'bob'", "/data3", "write")
+	_, _ = e.AddPolicy("r.sub.Age > 18", "/data3", "write")
+
+	aliceJson := `{"Name": "alice", "Age": 16}`
+	bobJson := `{"Name": "bob", "Age": 30}`
+
+	testEnforce(t, e, aliceJson, "/data3", "read", false)
+	testEnforce(t, e, aliceJson, "/data3", "write", false)
+	testEnforce(t, e, bobJson, "/data3", "read", true)
+	testEnforce(t, e, bobJson, "/data3", "write", true)
+	testEnforce(t, e, aliceJson, "/data3", "read", false)
+}
+
+func TestABACJsonPolicy(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = constraint, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub.Age >= p.constraint.minAge && r.sub.Dept == p.constraint.dept && r.obj == p.obj && r.act == p.act
+`)
+	e, _ := NewEnforcer(m)
+	e.EnableAcceptJsonRequest(true)
+
+	_, _ = e.AddPolicy(`{"minAge": 18, "dept": "sales"}`, "/data1", "read")
+	_, _ = e.AddPolicy(`{"minAge": 30, "dept": "dev"}`, "/data2", "read")
+	// a rule with a plain policy value accessed by field does not match
+	_, _ = e.AddPolicy("plain", "/data1", "read")
+	_, _ = e.AddPolicy("plain", "/data3", "read")
+
+	aliceJson := `{"Name": "alice", "Age": 20, "Dept": "sales"}`
+	bobJson := `{"Name": "bob", "Age": 35, "Dept": "dev"}`
+
+	testEnforce(t, e, aliceJson, "/data1", "read", true)
+	testEnforce(t, e, aliceJson, "/data2", "read", false)
+	testEnforce(t, e, bobJson, "/data1", "read", false)
+	testEnforce(t, e, bobJson, "/data2", "read", true)
+
+	ok, err := e.Enforce(aliceJson, "/data3", "read")
+	if err != nil || ok {
+		t.Errorf("Enforce with a plain policy value: got %t, %v, supposed to be false, nil", ok, err)
+	}
+	ok, err = e.Enforce(`{"Age": 16, "Dept": "sales"}`, "/data1", "read")
+	if err != nil || ok {
+		t.Errorf("Enforce with a plain policy value: got %t, %v, supposed to be false, nil", ok, err)
+	}
+}
+
+func TestABACJsonRequestNested(t *testing.T) {
+	e, _ := NewEnforcer("examples/abac_rule_model.conf")
+	e.EnableAcceptJsonRequest(true)
+
+	_, _ = e.AddPolicy("r.sub.profile.address.city == 'Paris' && r.sub.profile.level >= 3", "/data1", "read")
+	_, _ = e.AddPolicy("r.sub.roles.0 == 'admin'", "/data2", "read")
+	_, _ = e.AddPolicy("r.sub.profile.missing.field == ''", "/data3", "read")
+	_, _ = e.AddPolicy("r.sub.profile.zip == '75001'", "/data4", "read")
+
+	aliceJson := `{"Name": "alice", "roles": ["admin", "user"], "profile": {"level": 5, "zip": "75001", "address": {"city": "Paris"}}}`
+	bobJson := `{"Name": "bob", "roles": ["user"], "profile": {"level": 2, "zip": 75001, "address": {"city": "Paris"}}}`
+	quotedJson := `{"roles": ["a\"dmin"], "profile": {"level": 5, "address": {"city": "Par\\is"}}}`
+
+	testEnforce(t, e, aliceJson, "/data1", "read", true)
+	testEnforce(t, e, bobJson, "/data1", "read", false)
+	testEnforce(t, e, aliceJson, "/data2", "read", true)
+	testEnforce(t, e, bobJson, "/data2", "read", false)
+	testEnforce(t, e, aliceJson, "/data3", "read", true)
+	// A numeric leaf stays unquoted, so it does not equal the string '75001'.
+	testEnforce(t, e, aliceJson, "/data4", "read", true)
+	testEnforce(t, e, bobJson, "/data4", "read", false)
+	// Quotes and backslashes in string leaves do not break the expression.
+	testEnforce(t, e, quotedJson, "/data1", "read", false)
+	testEnforce(t, e, quotedJson, "/data2", "read", false)
+
+	e, _ = NewEnforcer("examples/abac_model.conf")
+	e.EnableAcceptJsonRequest(true)
+	e.GetModel()["m"]["m"].Value = "r_sub.profile.level > 3 && r_obj.Owner == r_sub.Name"
+	testEnforce(t, e, aliceJson, `{"Owner": "alice"}`, "read", true)
+	testEnforce(t, e, aliceJson, `{"Owner": "bob"}`, "read", false)
+	testEnforce(t, e, bobJson, `{"Owner": "bob"}`, "read", false)
+}
+
+type testAttributer map[string]interface{}
+
+func (a testAttributer) GetAttribute(name string) (interface{}, error) {
+	value, ok := a[name]
+	if !ok {
+		return nil, fmt.Errorf("attribute %s not found", name)
+	}
+	return value, nil
+}
+
+func TestABACAttributer(t *testing.T) {
+	e, _ := NewEnforcer("examples/abac_model.conf")
+
+	data1 := testAttributer{"Owner": "alice"}
+	testEnforce(t, e, "alice", data1, "read", true)
+	testEnforce(t, e, "bob", data1, "read", false)
+
+	alice := testAttributer{"department": "sales", "age": 30}
+	bob := testAttributer{"department": "it", "age": 16}
+	report := testAttributer{"department": "sales"}
+	matcher := "r.sub.department == r.obj.department"
+	testEnforceWithMatcher(t, e, matcher, alice, report, "read", true)
+	testEnforceWithMatcher(t, e, matcher, bob, report, "read", false)
+
+	e, _ = NewEnforcer("examples/abac_rule_model.conf")
+	_, _ = e.AddPolicy("r.sub.age > 18", "/data1", "read")
+	testEnforce(t, e, alice, "/data1", "read", true)
+	testEnforce(t, e, bob, "/data1", "read", false)
+
+	if _, err := e.EnforceWithMatcher("r.sub.title == 'manager'", alice, "/data1", "read"); err == nil {
+		t.Error("Enforce() should fail when the attribute is not found")
+	}
+}
+
 func TestKeyMatchModel(t *testing.T) {
 	e, _ := NewEnforcer("examples/keymatch_model.conf", "examples/keymatch_policy.csv")
 
@@ -519,6 +654,45 @@ func TestKeyMatchCustomModel(t *testing.T) {
 	testEnforce(t, e, "alice", "/alice_data2/myid/using/res_id", "GET", true)
 }
 
+func TestContextFunction(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+
+	// compile the matcher with a plain function first, the context function must replace it.
+	e.AddFunction("ownedByDomain", func(args ...interface{}) (interface{}, error) {
+		return false, nil
+	})
+	if res, _ := e.EnforceWithMatcher("ownedByDomain(r.obj) && r.act == 'read'", "alice", "domain1", "data1", "read"); res {
+		t.Error("ownedByDomain: true, supposed to be false")
+	}
+
+	// the function only gets the object, the domain is read from the request.
+	e.AddFunctionWithContext("ownedByDomain", func(request map[string]interface{}, args ...interface{}) (interface{}, error) {
+		dom := request["r_dom"].(string)
+		obj := args[0].(string)
+		return dom[len(dom)-1] == obj[len(obj)-1], nil
+	})
+
+	matcher := "ownedByDomain(r.obj) && r.act == 'read'"
+	testCases := []struct {
+		request []interface{}
+		res     bool
+	}{
+		{[]interface{}{"alice", "domain1", "data1", "read"}, true},
+		{[]interface{}{"alice", "domain2", "data1", "read"}, false},
+		{[]interface{}{"bob", "domain2", "data2", "read"}, true},
+		{[]interface{}{"bob", "domain2", "data2", "write"}, false},
+	}
+	for _, tc := range testCases {
+		if res, err := e.EnforceWithMatcher(matcher, tc.request...); err != nil || res != tc.res {
+			t.Errorf("%v: %t, %v, supposed to be %t", tc.request, res, err, tc.res)
+		}
+	}
+
+	// the g-function and the model matcher keep working with a context function added.
+	testDomainEnforce(t, e, "alice", "domain1", "data1", "read", true)
+	testDomainEnforce(t, e, "alice", "domain2", "data2", "read", false)
+}
+
 func TestIPMatchModel(t *testing.T) {
 	e, _ := NewEnforcer("examples/ipmatch_model.conf", "examples/ipmatch_policy.csv")
 
@@ -606,6 +780,46 @@ func newTestSubject(name string, age int) testSub {
 	return s
 }
 
+func TestABACEvalCache(t *testing.T) {
+	e, _ := NewEnforcer("examples/abac_rule_model.conf", "examples/abac_rule_policy.csv")
+	sub1 := newTestSubject("alice", 16)
+	sub2 := newTestSubject("alice", 20)
+	sub3 := newTestSubject("alice", 65)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testEnforce(t, e, sub1, "/data1", "read", false)
+			testEnforce(t, e, sub1, "/data2", "write", true)
+			testEnforce(t, e, sub2, "/data1", "read", true)
+			testEnforce(t, e, sub2, "/data2", "write", true)
+			testEnforce(t, e, sub3, "/data1", "read", true)
+			testEnforce(t, e, sub3, "/data2", "write", false)
+		}()
+	}
+	wg.Wait()
+
+	countEvalCache := func() int {
+		count := 0
+		e.evalMatcherMap.Range(func(key, value interface{}) bool {
+			count++
+			return true
+		})
+		return count
+	}
+	if count := countEvalCache(); count != 2 {
+		t.Errorf("Cached sub-rules: %d, supposed to be 2", count)
+	}
+
+	_ = e.LoadPolicy()
+	if count := countEvalCache(); count != 0 {
+		t.Errorf("Cached sub-rules after LoadPolicy: %d, supposed to be 0", count)
+	}
+	testEnforce(t, e, sub2, "/data1", "read", true)
+}
+
 func TestABACNotUsingPolicy(t *testing.T) {
 	e, _ := NewEnforcer("examples/abac_not_using_policy_model.conf", "examples/abac_rule_effect_policy.csv")
 	data1 := newTestResource("data1", "alice")
@@ -682,3 +896,36 @@ func TestAllMatchModel(t *testing.T) {
 	testDomainEnforce(t, e, "alice", "domain2", "/book/1", "read", false)
 	testDomainEnforce(t, e, "alice", "domain2", "/book/1", "write", true)
 }
+
+func TestPolicyIndexAndCountParameters(t *testing.T) {
+	m := model.NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act")
+	m.AddDef("e", "e", "some(where (p.eft == allow))")
+	m.AddDef("m", "m", "r.sub == p.sub && r.obj == p.obj && r.act == p.act && p.index < 2 && p_count == 3")
+
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy("alice", "data1", "read")
+	_, _ = e.AddPolicy("bob", "data2", "write")
+	_, _ = e.AddPolicy("cathy", "data3", "read")
+
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "bob", "data2", "write", true)
+	testEnforce(t, e, "cathy", "data3", "read", false)
+
+	_, _ = e.RemovePolicy("alice", "data1", "read")
+	testEnforce(t, e, "bob", "data2", "write", false)
+
+	// a real policy token takes precedence over the built-in parameter
+	m = model.NewModel()
+	m.AddDef("r", "r", "sub, obj, act")
+	m.AddDef("p", "p", "sub, obj, act, index")
+	m.AddDef("e", "e", "some(where (p.eft == allow))")
+	m.AddDef("m", "m", "r.sub == p.sub && r.obj == p.obj && r.act == p.act && p.index == 'first'")
+
+	e, _ = NewEnforcer(m)
+	_, _ = e.AddPolicy("alice", "data1", "read", "first")
+	_, _ = e.AddPolicy("bob", "data2", "write", "second")
+	testEnforce(t, e, "alice", "data1", "read", true)
+	testEnforce(t, e, "bob", "data2", "write", false)
+}
@@ -0,0 +1,255 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package casbin
+
+import (
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+)
+
+// DefaultOperationJournalSize is the number of operations kept by the operation journal
+// unless SetOperationJournalSize is called.
+const DefaultOperationJournalSize = 100
+
+// journalChange records the rules of a policy type removed and added by a management operation.
+// The rules of an update are paired by their index.
+type journalChange struct {
+	sec     string
+	ptype   string
+	removed [][]string
+	added   [][]string
+	update  bool
+}
+
+// journalOperation records a management operation, as its changes to one or several policy types.
+type journalOperation []journalChange
+
+// operationJournal keeps the operations which can be undone, and the undone ones which can be redone.
+type operationJournal struct {
+	size      int
+	undo      []journalOperation
+	redo      []journalOperation
+	replaying bool
+}
+
+// EnableOperationJournal enables or disables the operation journal, which records the management operations
+// so that they can be reverted by Undo and replayed by Redo. Disabling it drops the recorded operations.
+// The journal is kept in memory only, it is not persisted and does not survive a restart of the process.
+func (e *Enforcer) EnableOperationJournal(enable bool) {
+	if !enable {
+		e.journal = nil
+		return
+	}
+	if e.journal == nil {
+		e.journal = &operationJournal{size: DefaultOperationJournalSize}
+	}
+}
+
+// SetOperationJournalSize bounds the number of operations kept by the operation journal,
+// the oldest ones being dropped beyond it.
+func (e *Enforcer) SetOperationJournalSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	if e.journal == nil {
+		e.journal = &operationJournal{}
+	}
+	e.journal.size = size
+	e.journal.undo = trimJournal(e.journal.undo, size)
+	e.journal.redo = trimJournal(e.journal.redo, size)
+}
+
+// trimJournal drops the oldest operations of ops beyond size.
+func trimJournal(ops []journalOperation, size int) []journalOperation {
+	if len(ops) <= size {
+		return ops
+	}
+	return append([]journalOperation(nil), ops[len(ops)-size:]...)
+}
+
+// clearOperationJournal drops the recorded operations, as they no longer apply when the policy is replaced.
+func (e *Enforcer) clearOperationJournal() {
+	if e.journal != nil {
+		e.journal.undo = nil
+		e.journal.redo = nil
+	}
+}
+
+// journaling reports whether the management operations are to be recorded.
+func (e *Enforcer) journaling() bool {
+	return e.journal != nil && !e.journal.replaying
+}
+
+// recordOperation records an operation which removed and added the given rules, and drops the undone operations.
+func (e *Enforcer) recordOperation(sec string, ptype string, removed [][]string, added [][]string, update bool) {
+	if len(removed) == 0 && len(added) == 0 {
+		return
+	}
+	e.recordChanges(journalOperation{{sec: sec, ptype: ptype, removed: removed, added: added, update: update}})
+}
+
+// recordChanges records the changes of several policy types as a single operation, and drops the undone operations.
+func (e *Enforcer) recordChanges(op journalOperation) {
+	if !e.journaling() || len(op) == 0 {
+		return
+	}
+	e.journal.undo = trimJournal(append(e.journal.undo, op), e.journal.size)
+	e.journal.redo = nil
+}
+
+// Undo reverts the last management operation recorded by the operation journal, through the adapter and the watcher
+// like the operation itself. It returns false if there is no operation to undo, or if the policy has changed in a way
+// the operation can no longer be reverted, in which case the operation is kept.
+func (e *Enforcer) Undo() (bool, error) {
+	if e.journal == nil || len(e.journal.undo) == 0 {
+		return false, nil
+	}
+	op := e.journal.undo[len(e.journal.undo)-1]
+	ok, err := e.replayOperation(op, true)
+	if !ok {
+		return false, err
+	}
+	e.journal.undo = e.journal.undo[:len(e.journal.undo)-1]
+	e.journal.redo = append(e.journal.redo, op)
+	return true, err
+}
+
+// Redo replays the last management operation reverted by Undo. The undone operations are dropped
+// as soon as a new management operation is recorded.
+func (e *Enforcer) Redo() (bool, error) {
+	if e.journal == nil || len(e.journal.redo) == 0 {
+		return false, nil
+	}
+	op := e.journal.redo[len(e.journal.redo)-1]
+	ok, err := e.replayOperation(op, false)
+	if !ok {
+		return false, err
+	}
+	e.journal.redo = e.journal.redo[:len(e.journal.redo)-1]
+	e.journal.undo = append(e.journal.undo, op)
+	return true, err
+}
+
+// replayOperation replays the changes of op, or reverts them in the reverse order if undo is true,
+// without recording them in the journal.
+func (e *Enforcer) replayOperation(op journalOperation, undo bool) (bool, error) {
+	changes := make([]journalChange, len(op))
+	for i, change := range op {
+		if undo {
+			change.removed, change.added = change.added, change.removed
+			changes[len(op)-1-i] = change
+		} else {
+			changes[i] = change
+		}
+	}
+	for _, change := range changes {
+		if !e.canReplay(change.sec, change.ptype, change.removed, change.added) {
+			return false, nil
+		}
+	}
+
+	e.journal.replaying = true
+	defer func() { e.journal.replaying = false }()
+
+	for _, change := range changes {
+		if ok, err := e.replayChange(change.sec, change.ptype, change.removed, change.added, change.update); !ok || err != nil {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+// replayChange removes the rules in removed and adds the ones in added, or updates the former to the latter.
+func (e *Enforcer) replayChange(sec string, ptype string, removed [][]string, added [][]string, update bool) (bool, error) {
+	if update {
+		return e.updatePolicies(sec, ptype, removed, added)
+	}
+	// the single rules are replayed one by one, so that the incremental watchers are notified of them.
+	var ok bool
+	var err error
+	switch len(removed) {
+	case 0:
+	case 1:
+		ok, err = e.removePolicy(sec, ptype, removed[0])
+	default:
+		ok, err = e.removePolicies(sec, ptype, removed)
+	}
+	if len(removed) != 0 && (!ok || err != nil) {
+		return ok, err
+	}
+	switch len(added) {
+	case 0:
+	case 1:
+		ok, err = e.addPolicy(sec, ptype, added[0])
+	default:
+		ok, err = e.addPolicies(sec, ptype, added, false)
+	}
+	if len(added) != 0 && (!ok || err != nil) {
+		return ok, err
+	}
+	return true, nil
+}
+
+// canReplay reports whether all the rules in removed are in the policy, and none of the ones in added
+// is left after they are removed, so that an operation is replayed all or none.
+func (e *Enforcer) canReplay(sec string, ptype string, removed [][]string, added [][]string) bool {
+	if _, ok := e.model[sec][ptype]; !ok {
+		return false
+	}
+	removing := make(map[string]bool, len(removed))
+	for _, rule := range removed {
+		if !e.model.HasPolicy(sec, ptype, rule) {
+			return false
+		}
+		removing[strings.Join(rule, model.DefaultSep)] = true
+	}
+	for _, rule := range added {
+		if e.model.HasPolicy(sec, ptype, rule) && !removing[strings.Join(rule, model.DefaultSep)] {
+			return false
+		}
+	}
+	return true
+}
+
+// missingRules returns the rules which are not in the policy yet, once each.
+func (e *Enforcer) missingRules(sec string, ptype string, rules [][]string) [][]string {
+	var missing [][]string
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		key := strings.Join(rule, model.DefaultSep)
+		if seen[key] || e.model.HasPolicy(sec, ptype, rule) {
+			continue
+		}
+		seen[key] = true
+		missing = append(missing, rule)
+	}
+	return missing
+}
+
+// presentRules returns the rules which are in the policy, once each.
+func (e *Enforcer) presentRules(sec string, ptype string, rules [][]string) [][]string {
+	var present [][]string
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		key := strings.Join(rule, model.DefaultSep)
+		if seen[key] || !e.model.HasPolicy(sec, ptype, rule) {
+			continue
+		}
+		seen[key] = true
+		present = append(present, rule)
+	}
+	return present
+}
@@ -23,12 +23,18 @@ import (
 
 // LoadPolicyLine loads a text line as a policy rule to model.
 func LoadPolicyLine(line string, m model.Model) error {
+	return LoadPolicyLineWithSeparator(line, ',', m)
+}
+
+// LoadPolicyLineWithSeparator loads a text line whose fields are separated by sep as a policy rule to model.
+// A field containing sep must be quoted like in CSV.
+func LoadPolicyLineWithSeparator(line string, sep rune, m model.Model) error {
 	if line == "" || strings.HasPrefix(line, "#") {
 		return nil
 	}
 
 	r := csv.NewReader(strings.NewReader(line))
-	r.Comma = ','
+	r.Comma = sep
 	r.Comment = '#'
 	r.TrimLeadingSpace = true
 
@@ -0,0 +1,23 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+// CountingAdapter is the interface for Casbin adapters which can count the policy rules in the storage
+// without loading them.
+type CountingAdapter interface {
+	Adapter
+	// CountPolicy returns the number of policy rules of the ptype in the sec section ("p" or "g") in the storage.
+	CountPolicy(sec string, ptype string) (int, error)
+}
@@ -27,3 +27,12 @@ type FilteredAdapter interface {
 	// IsFiltered returns true if the loaded policy has been filtered.
 	IsFiltered() bool
 }
+
+// FilteredSaveAdapter is the interface for Casbin filtered adapters which can save back a filtered policy.
+type FilteredSaveAdapter interface {
+	FilteredAdapter
+
+	// SaveFilteredPolicy saves the policy loaded with the filter: the stored rules matching the filter are replaced
+	// by the rules of the model, while the stored rules outside of the filter, which were not loaded, are kept.
+	SaveFilteredPolicy(model model.Model, filter interface{}) error
+}
@@ -0,0 +1,26 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persist
+
+// StreamingAdapter is the interface for Casbin adapters able to push the policy rules one by one,
+// so that a large policy can be loaded without building it twice in memory.
+type StreamingAdapter interface {
+	Adapter
+
+	// LoadPolicyStream sends all policy rules from the storage to rules, each in the form accepted by
+	// LoadPolicyArray: the ptype followed by the fields, like ["p", "alice", "data1", "read"].
+	// It must not close rules.
+	LoadPolicyStream(rules chan<- []string) error
+}
@@ -23,13 +23,13 @@ import (
 
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
-	"github.com/casbin/casbin/v2/util"
 )
 
 // Adapter is the file adapter for Casbin.
 // It can load policy from file or save policy to file.
 type Adapter struct {
-	filePath string
+	filePath  string
+	separator rune
 }
 
 func (a *Adapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
@@ -46,7 +46,13 @@ func (a *Adapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]
 
 // NewAdapter is the constructor for Adapter.
 func NewAdapter(filePath string) *Adapter {
-	return &Adapter{filePath: filePath}
+	return NewAdapterWithSeparator(filePath, ',')
+}
+
+// NewAdapterWithSeparator is the constructor for Adapter with the fields of the policy lines separated by sep
+// instead of a comma, like '|'. The fields containing sep are quoted when the policy is saved.
+func NewAdapterWithSeparator(filePath string, sep rune) *Adapter {
+	return &Adapter{filePath: filePath, separator: sep}
 }
 
 // LoadPolicy loads all policy rules from the storage.
@@ -55,7 +61,7 @@ func (a *Adapter) LoadPolicy(model model.Model) error {
 		return errors.New("invalid file path, file path cannot be empty")
 	}
 
-	return a.loadPolicyFile(model, persist.LoadPolicyLine)
+	return a.loadPolicyFile(model, a.loadPolicyLine)
 }
 
 // SavePolicy saves all policy rules to the storage.
@@ -68,16 +74,14 @@ func (a *Adapter) SavePolicy(model model.Model) error {
 
 	for ptype, ast := range model["p"] {
 		for _, rule := range ast.Policy {
-			tmp.WriteString(ptype + ", ")
-			tmp.WriteString(util.ArrayToString(rule))
+			tmp.WriteString(a.policyLine(ptype, rule))
 			tmp.WriteString("\n")
 		}
 	}
 
 	for ptype, ast := range model["g"] {
 		for _, rule := range ast.Policy {
-			tmp.WriteString(ptype + ", ")
-			tmp.WriteString(util.ArrayToString(rule))
+			tmp.WriteString(a.policyLine(ptype, rule))
 			tmp.WriteString("\n")
 		}
 	}
@@ -85,6 +89,29 @@ func (a *Adapter) SavePolicy(model model.Model) error {
 	return a.savePolicyFile(strings.TrimRight(tmp.String(), "\n"))
 }
 
+func (a *Adapter) loadPolicyLine(line string, model model.Model) error {
+	return persist.LoadPolicyLineWithSeparator(line, a.separator, model)
+}
+
+// policyLine gets the text line of a policy rule. The fields are separated by ", " for a comma, like in
+// the policy files, and by the separator alone otherwise. They are quoted when they contain the separator,
+// a quote or a line break, or start with a space which would be trimmed when loading.
+func (a *Adapter) policyLine(ptype string, rule []string) string {
+	sep := string(a.separator)
+	if a.separator == ',' {
+		sep = ", "
+	}
+
+	fields := make([]string, 0, len(rule)+1)
+	for _, field := range append([]string{ptype}, rule...) {
+		if strings.ContainsAny(field, string(a.separator)+"\"\r\n") || strings.HasPrefix(field, " ") || strings.HasPrefix(field, "\t") {
+			field = `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+		}
+		fields = append(fields, field)
+	}
+	return strings.Join(fields, sep)
+}
+
 func (a *Adapter) loadPolicyFile(model model.Model, handler func(string, model.Model) error) error {
 	f, err := os.Open(a.filePath)
 	if err != nil {
@@ -16,12 +16,13 @@ package fileadapter
 
 import (
 	"bufio"
+	"encoding/csv"
 	"errors"
 	"os"
 	"strings"
 
+	Err "github.com/casbin/casbin/v2/errors"
 	"github.com/casbin/casbin/v2/model"
-	"github.com/casbin/casbin/v2/persist"
 )
 
 // FilteredAdapter is the filtered file adapter for Casbin. It can load policy
@@ -45,9 +46,15 @@ type Filter struct {
 
 // NewFilteredAdapter is the constructor for FilteredAdapter.
 func NewFilteredAdapter(filePath string) *FilteredAdapter {
+	return NewFilteredAdapterWithSeparator(filePath, ',')
+}
+
+// NewFilteredAdapterWithSeparator is the constructor for FilteredAdapter with the fields of the policy lines
+// separated by sep instead of a comma, see NewAdapterWithSeparator.
+func NewFilteredAdapterWithSeparator(filePath string, sep rune) *FilteredAdapter {
 	a := FilteredAdapter{}
 	a.filtered = true
-	a.Adapter = NewAdapter(filePath)
+	a.Adapter = NewAdapterWithSeparator(filePath, sep)
 	return &a
 }
 
@@ -70,7 +77,7 @@ func (a *FilteredAdapter) LoadFilteredPolicy(model model.Model, filter interface
 	if !ok {
 		return errors.New("invalid filter type")
 	}
-	err := a.loadFilteredPolicyFile(model, filterValue, persist.LoadPolicyLine)
+	err := a.loadFilteredPolicyFile(model, filterValue, a.loadPolicyLine)
 	if err == nil {
 		a.filtered = true
 	}
@@ -88,7 +95,7 @@ func (a *FilteredAdapter) loadFilteredPolicyFile(model model.Model, filter *Filt
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
-		if filterLine(line, filter) {
+		if filterLine(a.lineFields(line), filter) {
 			continue
 		}
 
@@ -108,18 +115,95 @@ func (a *FilteredAdapter) IsFiltered() bool {
 // SavePolicy saves all policy rules to the storage.
 func (a *FilteredAdapter) SavePolicy(model model.Model) error {
 	if a.filtered {
-		return errors.New("cannot save a filtered policy")
+		return Err.ErrCannotSaveFilteredPolicy
 	}
 	return a.Adapter.SavePolicy(model)
 }
 
-func filterLine(line string, filter *Filter) bool {
+// SaveFilteredPolicy saves the policy loaded with the filter: the lines of the file matching the filter are replaced
+// by the rules of the model, while the other lines are kept. A rule of the model which is kept is not written twice.
+func (a *FilteredAdapter) SaveFilteredPolicy(model model.Model, filter interface{}) error {
 	if filter == nil {
-		return false
+		return a.Adapter.SavePolicy(model)
 	}
-	p := strings.Split(line, ",")
-	if len(p) == 0 {
-		return true
+	if a.filePath == "" {
+		return errors.New("invalid file path, file path cannot be empty")
+	}
+
+	filterValue, ok := filter.(*Filter)
+	if !ok {
+		return errors.New("invalid filter type")
+	}
+
+	f, err := os.Open(a.filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []string
+	kept := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// the comments and the lines which cannot be parsed are kept as they are.
+		fields := a.lineFields(line)
+		if fields == nil || filterLine(fields, filterValue) {
+			lines = append(lines, line)
+			kept[ruleKey(fields)] = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				if !kept[ruleKey(append([]string{ptype}, rule...))] {
+					lines = append(lines, a.policyLine(ptype, rule))
+				}
+			}
+		}
+	}
+
+	return a.savePolicyFile(strings.Join(lines, "\n"))
+}
+
+// lineFields parses the fields of a policy line like loadPolicyLine does, with the separator of the adapter.
+// It returns nil for an empty line, a comment, or a line which cannot be parsed.
+func (a *Adapter) lineFields(line string) []string {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	r := csv.NewReader(strings.NewReader(line))
+	r.Comma = a.separator
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	fields, err := r.Read()
+	if err != nil {
+		return nil
+	}
+	return fields
+}
+
+// ruleKey gets the key of the fields of a policy line, so that the lines can be compared.
+func ruleKey(fields []string) string {
+	key := make([]string, len(fields))
+	for i, field := range fields {
+		key[i] = strings.TrimSpace(field)
+	}
+	return strings.Join(key, "\x00")
+}
+
+// filterLine returns whether the policy line with the fields p is filtered out, the lines without fields are not.
+func filterLine(p []string, filter *Filter) bool {
+	if filter == nil || len(p) == 0 {
+		return false
 	}
 	var filterSlice []string
 	switch strings.TrimSpace(p[0]) {
@@ -0,0 +1,182 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mergingadapter
+
+import (
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	"github.com/casbin/casbin/v2/util"
+)
+
+// Adapter is the merging adapter for Casbin.
+// It loads the policy of a primary adapter, like a base policy file, then the rules of the overlay adapters,
+// like per-team policy files, on top of it. The identical rules are only loaded once, and the overlays can
+// only add rules: a rule of the primary adapter cannot be removed by an overlay.
+// The policy changes, including SavePolicy, are written to the primary adapter only, the overlays are read-only.
+type Adapter struct {
+	primary  persist.Adapter
+	overlays []persist.Adapter
+	// overlayRules records the rules loaded from the overlays only, they are not saved to the primary adapter.
+	overlayRules []policyRule
+}
+
+type policyRule struct {
+	sec   string
+	ptype string
+	rule  []string
+}
+
+// NewAdapter is the constructor for Adapter, the overlays are loaded in order after the primary adapter.
+func NewAdapter(primary persist.Adapter, overlays ...persist.Adapter) *Adapter {
+	return &Adapter{
+		primary:  primary,
+		overlays: overlays,
+	}
+}
+
+// NewFileAdapter is the constructor for Adapter loading the policy file primaryPath, then the overlay files in order.
+func NewFileAdapter(primaryPath string, overlayPaths ...string) *Adapter {
+	overlays := make([]persist.Adapter, len(overlayPaths))
+	for i, path := range overlayPaths {
+		overlays[i] = fileadapter.NewAdapter(path)
+	}
+	return NewAdapter(fileadapter.NewAdapter(primaryPath), overlays...)
+}
+
+// LoadPolicy loads the policy rules of the primary adapter, then adds the ones of the overlays which are not loaded yet.
+func (a *Adapter) LoadPolicy(m model.Model) error {
+	if err := a.primary.LoadPolicy(m); err != nil {
+		return err
+	}
+
+	a.overlayRules = nil
+	for _, overlay := range a.overlays {
+		overlayModel := m.Copy()
+		overlayModel.ClearPolicy()
+		if err := overlay.LoadPolicy(overlayModel); err != nil {
+			return err
+		}
+		for _, sec := range []string{"p", "g"} {
+			for ptype, ast := range overlayModel[sec] {
+				for _, rule := range ast.Policy {
+					if m.HasPolicy(sec, ptype, rule) {
+						continue
+					}
+					m.AddPolicy(sec, ptype, rule)
+					a.overlayRules = append(a.overlayRules, policyRule{sec: sec, ptype: ptype, rule: rule})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// SavePolicy saves the policy rules to the primary adapter, except the ones loaded from the overlays.
+func (a *Adapter) SavePolicy(m model.Model) error {
+	primaryModel := m.Copy()
+	for _, r := range a.overlayRules {
+		if _, ok := primaryModel[r.sec][r.ptype]; ok {
+			primaryModel.RemovePolicy(r.sec, r.ptype, r.rule)
+		}
+	}
+	return a.primary.SavePolicy(primaryModel)
+}
+
+// AddPolicy adds a policy rule to the primary adapter.
+func (a *Adapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.primary.AddPolicy(sec, ptype, rule)
+}
+
+// AddPolicies adds policy rules to the primary adapter.
+func (a *Adapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	if batchAdapter, ok := a.primary.(persist.BatchAdapter); ok {
+		return batchAdapter.AddPolicies(sec, ptype, rules)
+	}
+	for _, rule := range rules {
+		if err := a.primary.AddPolicy(sec, ptype, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemovePolicy removes a policy rule from the primary adapter.
+func (a *Adapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	err := a.primary.RemovePolicy(sec, ptype, rule)
+	if isRemoved(err) {
+		a.forgetOverlayRules(sec, ptype, func(r []string) bool { return util.ArrayEquals(r, rule) })
+	}
+	return err
+}
+
+// RemovePolicies removes policy rules from the primary adapter.
+func (a *Adapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	var err error
+	if batchAdapter, ok := a.primary.(persist.BatchAdapter); ok {
+		err = batchAdapter.RemovePolicies(sec, ptype, rules)
+	} else {
+		for _, rule := range rules {
+			if err = a.primary.RemovePolicy(sec, ptype, rule); err != nil {
+				break
+			}
+		}
+	}
+	if isRemoved(err) {
+		a.forgetOverlayRules(sec, ptype, func(r []string) bool {
+			for _, rule := range rules {
+				if util.ArrayEquals(r, rule) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return err
+}
+
+// RemoveFilteredPolicy removes the policy rules that match the filter from the primary adapter.
+func (a *Adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	err := a.primary.RemoveFilteredPolicy(sec, ptype, fieldIndex, fieldValues...)
+	if isRemoved(err) {
+		a.forgetOverlayRules(sec, ptype, func(r []string) bool {
+			for i, value := range fieldValues {
+				if value != "" && (fieldIndex+i >= len(r) || r[fieldIndex+i] != value) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return err
+}
+
+// isRemoved reports whether the enforcer removes the rules from its model after the primary adapter returned err,
+// which it also does when the primary adapter does not implement the removal and only saves the whole policy.
+func isRemoved(err error) bool {
+	return err == nil || err.Error() == "not implemented"
+}
+
+// forgetOverlayRules stops excluding from SavePolicy the overlay rules of ptype removed from the policy, so that
+// they are saved to the primary adapter if they are added back.
+func (a *Adapter) forgetOverlayRules(sec string, ptype string, removed func(rule []string) bool) {
+	kept := a.overlayRules[:0]
+	for _, r := range a.overlayRules {
+		if r.sec != sec || r.ptype != ptype || !removed(r.rule) {
+			kept = append(kept, r)
+		}
+	}
+	a.overlayRules = kept
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mergingadapter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	stringadapter "github.com/casbin/casbin/v2/persist/string-adapter"
+)
+
+func TestMergingAdapter(t *testing.T) {
+	primary := stringadapter.NewAdapter(`
+p, alice, data1, read
+p, bob, data2, write
+g, alice, admin
+`)
+	overlay1 := stringadapter.NewAdapter(`
+p, alice, data1, read
+p, admin, data3, read
+`)
+	overlay2 := stringadapter.NewAdapter(`
+p, cathy, data4, write
+g, cathy, admin
+`)
+	e, err := casbin.NewEnforcer("../../examples/rbac_model.conf", NewAdapter(primary, overlay1, overlay2))
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+
+	// the identical rules are loaded once.
+	expected := [][]string{
+		{"alice", "data1", "read"},
+		{"bob", "data2", "write"},
+		{"admin", "data3", "read"},
+		{"cathy", "data4", "write"},
+	}
+	if policy := e.GetPolicy(); !reflect.DeepEqual(policy, expected) {
+		t.Errorf("policy: %v, supposed to be %v", policy, expected)
+	}
+	for _, request := range [][]interface{}{{"alice", "data3", "read"}, {"cathy", "data3", "read"}, {"cathy", "data4", "write"}} {
+		if ok, _ := e.Enforce(request...); !ok {
+			t.Errorf("%v should be allowed", request)
+		}
+	}
+
+	// the rules of the overlays are not saved to the primary adapter.
+	_, _ = e.AddPolicy("david", "data5", "read")
+	if err = e.SavePolicy(); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	saved := "p, alice, data1, read\np, bob, data2, write\np, david, data5, read\ng, alice, admin"
+	if primary.Line != saved {
+		t.Errorf("saved policy: %q, supposed to be %q", primary.Line, saved)
+	}
+
+	// the overlay rules removed then added back are saved to the primary adapter.
+	_, _ = e.RemovePolicy("admin", "data3", "read")
+	_, _ = e.AddPolicy("admin", "data3", "read")
+	_, _ = e.RemoveFilteredGroupingPolicy(0, "cathy")
+	_, _ = e.AddGroupingPolicy("cathy", "admin")
+	if err = e.SavePolicy(); err != nil {
+		t.Fatalf("SavePolicy: %v", err)
+	}
+	saved = "p, alice, data1, read\np, bob, data2, write\np, david, data5, read\np, admin, data3, read\ng, alice, admin\ng, cathy, admin"
+	if primary.Line != saved {
+		t.Errorf("saved policy: %q, supposed to be %q", primary.Line, saved)
+	}
+
+	if err = e.LoadPolicy(); err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if len(e.GetPolicy()) != 5 || len(e.GetGroupingPolicy()) != 2 {
+		t.Errorf("reloaded policy: %v, %v", e.GetPolicy(), e.GetGroupingPolicy())
+	}
+}
+
+func TestMergingFileAdapter(t *testing.T) {
+	e, err := casbin.NewEnforcer("../../examples/rbac_model.conf", NewFileAdapter("../../examples/rbac_policy.csv",
+		"../../examples/basic_policy.csv", "../../examples/keymatch_policy.csv"))
+	if err != nil {
+		t.Fatalf("NewEnforcer: %v", err)
+	}
+	// basic_policy.csv only has rules of rbac_policy.csv, keymatch_policy.csv adds 5 rules.
+	if policy := e.GetPolicy(); len(policy) != 9 {
+		t.Errorf("policy: %v, supposed to have 9 rules", policy)
+	}
+	if !e.HasPolicy("cathy", "/cathy_data", "(GET)|(POST)") {
+		t.Error("the rules of the last overlay should be loaded")
+	}
+}
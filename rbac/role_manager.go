@@ -22,6 +22,10 @@ import (
 
 type MatchingFunc func(arg1 string, arg2 string) bool
 
+// ContextMatchingFunc is a matching function which also receives the request,
+// args are the value to match, the pattern, and then the values of the request.
+type ContextMatchingFunc func(args ...string) bool
+
 // RoleManager provides interface to define the operations for managing roles.
 type RoleManager interface {
 	// Clear clears all stored data and resets the role manager to the initial state.
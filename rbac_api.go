@@ -15,6 +15,8 @@
 package casbin
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/casbin/casbin/v2/constant"
@@ -24,14 +26,55 @@ import (
 
 // GetRolesForUser gets the roles that a user has.
 func (e *Enforcer) GetRolesForUser(name string, domain ...string) ([]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	domain = e.withDefaultDomain(domain)
 	res, err := e.model["g"]["g"].RM.GetRoles(name, domain...)
 	return res, err
 }
 
-// GetUsersForRole gets the users that has a role.
+// GetUsersForRole gets the users that has a role directly.
+// errors.ErrNameNotFound is returned if the role is neither the role of a grouping rule nor the subject of a "p" rule
+// (of the domain if given), to tell it from a role without users.
 func (e *Enforcer) GetUsersForRole(name string, domain ...string) ([]string, error) {
-	res, err := e.model["g"]["g"].RM.GetUsers(name, domain...)
-	return res, err
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	domain = e.withDefaultDomain(domain)
+	rm := e.model["g"]["g"].RM
+	res, err := rm.GetUsers(name, domain...)
+	if err != nil || len(res) != 0 {
+		return res, err
+	}
+	for _, rule := range e.model["g"]["g"].Policy {
+		if len(rule) < 2 || rule[1] != name {
+			continue
+		}
+		if len(domain) == 0 || len(rule) > 2 && rm.Match(domain[0], rule[2]) {
+			return res, nil
+		}
+	}
+	// a role may only have permissions
+	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
+	if err != nil {
+		subIndex = 0
+	}
+	domIndex := -1
+	if len(domain) != 0 {
+		if index, err := e.GetFieldIndex("p", constant.DomainIndex); err == nil {
+			domIndex = index
+		}
+	}
+	for _, rule := range e.model["p"]["p"].Policy {
+		if subIndex >= len(rule) || rule[subIndex] != name {
+			continue
+		}
+		if domIndex == -1 || domIndex < len(rule) && rm.Match(domain[0], rule[domIndex]) {
+			return res, nil
+		}
+	}
+	return res, errors.ErrNameNotFound
 }
 
 // HasRoleForUser determines whether a user has a role.
@@ -54,6 +97,7 @@ func (e *Enforcer) HasRoleForUser(name string, role string, domain ...string) (b
 // AddRoleForUser adds a role for a user.
 // Returns false if the user already has the role (aka not affected).
 func (e *Enforcer) AddRoleForUser(user string, role string, domain ...string) (bool, error) {
+	domain = e.withDefaultDomain(domain)
 	args := []string{user, role}
 	args = append(args, domain...)
 	return e.AddGroupingPolicy(args)
@@ -62,6 +106,7 @@ func (e *Enforcer) AddRoleForUser(user string, role string, domain ...string) (b
 // AddRolesForUser adds roles for a user.
 // Returns false if the user already has the roles (aka not affected).
 func (e *Enforcer) AddRolesForUser(user string, roles []string, domain ...string) (bool, error) {
+	domain = e.withDefaultDomain(domain)
 	var rules [][]string
 	for _, role := range roles {
 		rule := []string{user, role}
@@ -74,6 +119,7 @@ func (e *Enforcer) AddRolesForUser(user string, roles []string, domain ...string
 // DeleteRoleForUser deletes a role for a user.
 // Returns false if the user does not have the role (aka not affected).
 func (e *Enforcer) DeleteRoleForUser(user string, role string, domain ...string) (bool, error) {
+	domain = e.withDefaultDomain(domain)
 	args := []string{user, role}
 	args = append(args, domain...)
 	return e.RemoveGroupingPolicy(args)
@@ -82,6 +128,7 @@ func (e *Enforcer) DeleteRoleForUser(user string, role string, domain ...string)
 // DeleteRolesForUser deletes all roles for a user.
 // Returns false if the user does not have any roles (aka not affected).
 func (e *Enforcer) DeleteRolesForUser(user string, domain ...string) (bool, error) {
+	domain = e.withDefaultDomain(domain)
 	var args []string
 	if len(domain) == 0 {
 		args = []string{user}
@@ -93,50 +140,79 @@ func (e *Enforcer) DeleteRolesForUser(user string, domain ...string) (bool, erro
 	return e.RemoveFilteredGroupingPolicy(0, args...)
 }
 
-// DeleteUser deletes a user.
+// DeleteUser deletes a user: the grouping rules where it is the user or the role, and its permissions,
+// are removed as a single operation, notifying the watcher once.
 // Returns false if the user does not exist (aka not affected).
 func (e *Enforcer) DeleteUser(user string) (bool, error) {
-	var err error
-	res1, err := e.RemoveFilteredGroupingPolicy(0, user)
-	if err != nil {
-		return res1, err
-	}
-
-	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
-	if err != nil {
-		return false, err
-	}
-	res2, err := e.RemoveFilteredPolicy(subIndex, user)
-	return res1 || res2, err
+	return e.deleteSubject(user)
 }
 
-// DeleteRole deletes a role.
+// DeleteRole deletes a role: the grouping rules where it is the user or the role, and its permissions,
+// are removed as a single operation, notifying the watcher once.
 // Returns false if the role does not exist (aka not affected).
 func (e *Enforcer) DeleteRole(role string) (bool, error) {
-	var err error
-	res1, err := e.RemoveFilteredGroupingPolicy(1, role)
-	if err != nil {
-		return res1, err
-	}
+	return e.deleteSubject(role)
+}
 
+// deleteSubject removes the grouping rules of "g" referencing the subject, and the rules of "p" for the subject.
+func (e *Enforcer) deleteSubject(subject string) (bool, error) {
 	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
 	if err != nil {
 		return false, err
 	}
-	res2, err := e.RemoveFilteredPolicy(subIndex, role)
-	return res1 || res2, err
+
+	var groupingRules [][]string
+	if ast, ok := e.model["g"]["g"]; ok {
+		for _, rule := range ast.Policy {
+			if len(rule) > 1 && (rule[0] == subject || rule[1] == subject) {
+				groupingRules = append(groupingRules, rule)
+			}
+		}
+	}
+	return e.removePoliciesOfTypes([]policyRemoval{
+		{sec: "g", ptype: "g", rules: groupingRules},
+		{sec: "p", ptype: "p", rules: e.model.GetFilteredPolicy("p", "p", subIndex, subject)},
+	})
 }
 
-// DeletePermission deletes a permission.
+// DeletePermission deletes a permission from all the users and roles, as a single operation notifying the watcher once.
+// The permission is matched against the fields following the subject, like in AddPermissionForUser, an empty value
+// matching any value.
 // Returns false if the permission does not exist (aka not affected).
 func (e *Enforcer) DeletePermission(permission ...string) (bool, error) {
-	return e.RemoveFilteredPolicy(1, permission...)
+	if len(permission) == 0 {
+		return false, errors.ErrInvalidFieldValuesParameter
+	}
+	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
+	if err != nil {
+		return false, err
+	}
+
+	var rules [][]string
+	for _, rule := range e.model.GetPolicy("p", "p") {
+		if subIndex >= len(rule) {
+			continue
+		}
+		fields := append(append([]string(nil), rule[:subIndex]...), rule[subIndex+1:]...)
+		if len(permission) > len(fields) {
+			continue
+		}
+		matched := true
+		for i, value := range permission {
+			matched = matched && (value == "" || fields[i] == value)
+		}
+		if matched {
+			rules = append(rules, rule)
+		}
+	}
+	return e.removePoliciesOfTypes([]policyRemoval{{sec: "p", ptype: "p", rules: rules}})
 }
 
 // AddPermissionForUser adds a permission for a user or role.
+// The user is put at the position of the sub token of the policy definition, see SetFieldIndex.
 // Returns false if the user or role already has the permission (aka not affected).
 func (e *Enforcer) AddPermissionForUser(user string, permission ...string) (bool, error) {
-	return e.AddPolicy(util.JoinSlice(user, permission...))
+	return e.AddPolicy(e.permissionRule(user, permission))
 }
 
 // AddPermissionsForUser adds multiple permissions for a user or role.
@@ -144,7 +220,7 @@ func (e *Enforcer) AddPermissionForUser(user string, permission ...string) (bool
 func (e *Enforcer) AddPermissionsForUser(user string, permissions ...[]string) (bool, error) {
 	var rules [][]string
 	for _, permission := range permissions {
-		rules = append(rules, util.JoinSlice(user, permission...))
+		rules = append(rules, e.permissionRule(user, permission))
 	}
 	return e.AddPolicies(rules)
 }
@@ -152,7 +228,7 @@ func (e *Enforcer) AddPermissionsForUser(user string, permissions ...[]string) (
 // DeletePermissionForUser deletes a permission for a user or role.
 // Returns false if the user or role does not have the permission (aka not affected).
 func (e *Enforcer) DeletePermissionForUser(user string, permission ...string) (bool, error) {
-	return e.RemovePolicy(util.JoinSlice(user, permission...))
+	return e.RemovePolicy(e.permissionRule(user, permission))
 }
 
 // DeletePermissionsForUser deletes permissions for a user or role.
@@ -172,6 +248,7 @@ func (e *Enforcer) GetPermissionsForUser(user string, domain ...string) [][]stri
 
 // GetNamedPermissionsForUser gets permissions for a user or role by named policy.
 func (e *Enforcer) GetNamedPermissionsForUser(ptype string, user string, domain ...string) [][]string {
+	domain = e.withDefaultDomain(domain)
 	permission := make([][]string, 0)
 	for pType, assertion := range e.model["p"] {
 		if pType != ptype {
@@ -199,7 +276,25 @@ func (e *Enforcer) GetNamedPermissionsForUser(ptype string, user string, domain
 
 // HasPermissionForUser determines whether a user has a permission.
 func (e *Enforcer) HasPermissionForUser(user string, permission ...string) bool {
-	return e.HasPolicy(util.JoinSlice(user, permission...))
+	return e.HasPolicy(e.permissionRule(user, permission))
+}
+
+// permissionRule builds the policy rule of a permission of user, with user at the position of the sub token,
+// which is the first one unless set otherwise by SetFieldIndex.
+func (e *Enforcer) permissionRule(user string, permission []string) []string {
+	index, err := e.GetFieldIndex("p", constant.SubjectIndex)
+	if err != nil || index > len(permission) {
+		index = 0
+	}
+	return insertField(permission, index, user)
+}
+
+// insertField returns a copy of rule with value inserted at index.
+func insertField(rule []string, index int, value string) []string {
+	inserted := make([]string, 0, len(rule)+1)
+	inserted = append(inserted, rule[:index]...)
+	inserted = append(inserted, value)
+	return append(inserted, rule[index:]...)
 }
 
 // GetImplicitRolesForUser gets implicit roles that a user has.
@@ -211,38 +306,132 @@ func (e *Enforcer) HasPermissionForUser(user string, permission ...string) bool
 // GetRolesForUser("alice") can only get: ["role:admin"].
 // But GetImplicitRolesForUser("alice") will get: ["role:admin", "role:user"].
 func (e *Enforcer) GetImplicitRolesForUser(name string, domain ...string) ([]string, error) {
+	return e.getImplicitRolesForUser(name, 0, domain...)
+}
+
+// GetImplicitRolesForUserWithDepth gets implicit roles that a user has like GetImplicitRolesForUser,
+// but only inherited through at most depth levels, so that a depth of 1 gets the direct roles.
+// For example:
+// g, alice, role:admin
+// g, role:admin, role:user
+//
+// GetImplicitRolesForUserWithDepth("alice", 1) will get: ["role:admin"].
+func (e *Enforcer) GetImplicitRolesForUserWithDepth(name string, depth int, domain ...string) ([]string, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("the depth should be at least 1, got %d", depth)
+	}
+	return e.getImplicitRolesForUser(name, depth, domain...)
+}
+
+// getImplicitRolesForUser gets the roles inherited by the user through at most depth levels, or all of them if depth is 0.
+func (e *Enforcer) getImplicitRolesForUser(name string, depth int, domain ...string) ([]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	domain = e.withDefaultDomain(domain)
 	res := []string{}
+	// a role inherited through several role managers is only returned once
+	resSet := make(map[string]bool)
 
 	for _, rm := range e.rmMap {
 
+		// roleSet also prevents the cycles from being traversed again
 		roleSet := make(map[string]bool)
 		roleSet[name] = true
 		q := make([]string, 0)
 		q = append(q, name)
 
+		for level := 1; len(q) > 0 && (depth == 0 || level <= depth); level++ {
+			next := make([]string, 0)
+			for _, name := range q {
+				roles, err := rm.GetRoles(name, domain...)
+				if err != nil {
+					return nil, err
+				}
+				for _, r := range roles {
+					if _, ok := roleSet[r]; !ok {
+						if !resSet[r] {
+							res = append(res, r)
+							resSet[r] = true
+						}
+						next = append(next, r)
+						roleSet[r] = true
+					}
+				}
+			}
+			q = next
+		}
+	}
+
+	return res, nil
+}
+
+// RoleWithDistance is a role of a user together with its minimum inheritance distance from the user,
+// the direct roles have a distance of 1.
+type RoleWithDistance struct {
+	Name     string
+	Distance int
+}
+
+// GetImplicitRolesForUserOrdered gets implicit roles that a user has like GetImplicitRolesForUser,
+// ordered by their minimum inheritance distance from the user, and then by name.
+// For example:
+// g, alice, role:admin
+// g, role:admin, role:user
+// g, alice, role:user
+//
+// GetImplicitRolesForUserOrdered("alice") will get: [{"role:admin", 1}, {"role:user", 1}].
+func (e *Enforcer) GetImplicitRolesForUserOrdered(name string, domain ...string) ([]RoleWithDistance, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	domain = e.withDefaultDomain(domain)
+	distances := make(map[string]int)
+
+	for _, rm := range e.rmMap {
+		visited := map[string]int{name: 0}
+		q := []string{name}
+
 		for len(q) > 0 {
-			name := q[0]
+			current := q[0]
 			q = q[1:]
 
-			roles, err := rm.GetRoles(name, domain...)
+			roles, err := rm.GetRoles(current, domain...)
 			if err != nil {
 				return nil, err
 			}
 			for _, r := range roles {
-				if _, ok := roleSet[r]; !ok {
-					res = append(res, r)
-					q = append(q, r)
-					roleSet[r] = true
+				if _, ok := visited[r]; ok {
+					continue
+				}
+				visited[r] = visited[current] + 1
+				q = append(q, r)
+				if d, ok := distances[r]; !ok || visited[r] < d {
+					distances[r] = visited[r]
 				}
 			}
 		}
 	}
 
+	res := make([]RoleWithDistance, 0, len(distances))
+	for role, distance := range distances {
+		res = append(res, RoleWithDistance{Name: role, Distance: distance})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Distance != res[j].Distance {
+			return res[i].Distance < res[j].Distance
+		}
+		return res[i].Name < res[j].Name
+	})
 	return res, nil
 }
 
 // GetImplicitUsersForRole gets implicit users for a role.
 func (e *Enforcer) GetImplicitUsersForRole(name string, domain ...string) ([]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	domain = e.withDefaultDomain(domain)
 	res := []string{}
 
 	for _, rm := range e.rmMap {
@@ -296,32 +485,84 @@ func (e *Enforcer) GetImplicitPermissionsForUser(user string, domain ...string)
 // GetImplicitPermissionsForUser("alice") can only get: [["admin", "data1", "read"]], whose policy is default policy "p"
 // But you can specify the named policy "p2" to get: [["admin", "create"]] by    GetNamedImplicitPermissionsForUser("p2","alice")
 func (e *Enforcer) GetNamedImplicitPermissionsForUser(ptype string, user string, domain ...string) ([][]string, error) {
-	permission := make([][]string, 0)
+	domain = e.withDefaultDomain(domain)
+	indices, err := e.getNamedImplicitPermissionIndices(ptype, user, domain...)
+	if err != nil {
+		return nil, err
+	}
+	return e.copyImplicitPermissions(ptype, indices, domain...), nil
+}
+
+// GetImplicitPermissionsForUserPage gets a page of the implicit permissions for a user or role,
+// together with the total number of the implicit permissions. All the permissions are still expanded
+// to compute the total, but only the ones in [offset, offset+limit) are copied and returned.
+func (e *Enforcer) GetImplicitPermissionsForUserPage(user string, offset, limit int, domain ...string) ([][]string, int, error) {
+	domain = e.withDefaultDomain(domain)
+	if offset < 0 || limit < 0 {
+		return nil, 0, fmt.Errorf("invalid page: offset %d, limit %d", offset, limit)
+	}
+	indices, err := e.getNamedImplicitPermissionIndices("p", user, domain...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(indices)
+	if offset > total {
+		offset = total
+	}
+	if limit > total-offset {
+		limit = total - offset
+	}
+	return e.copyImplicitPermissions("p", indices[offset:offset+limit], domain...), total, nil
+}
+
+// getNamedImplicitPermissionIndices gets the indices of the implicit permissions for a user or role in the named policy.
+func (e *Enforcer) getNamedImplicitPermissionIndices(ptype string, user string, domain ...string) ([]int, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	if len(domain) > 1 {
+		return nil, errors.ErrDomainParameter
+	}
+
+	var indices []int
 	rm := e.GetRoleManager()
 	domainIndex, _ := e.GetFieldIndex(ptype, constant.DomainIndex)
-	for _, rule := range e.model["p"][ptype].Policy {
+	// the rules of different domains matching the given one are identical once their domain is replaced
+	seen := make(map[string]bool)
+	for i, rule := range e.model["p"][ptype].Policy {
+		var matched bool
 		if len(domain) == 0 {
-			matched, _ := rm.HasLink(user, rule[0])
-			if matched {
-				permission = append(permission, deepCopyPolicy(rule))
-			}
-		} else if len(domain) > 1 {
-			return nil, errors.ErrDomainParameter
-		} else {
-			d := domain[0]
-			matched := rm.Match(d, rule[domainIndex])
-			if !matched {
-				continue
-			}
-			matched, _ = rm.HasLink(user, rule[0], d)
+			matched, _ = rm.HasLink(user, rule[0])
+		} else if rm.Match(domain[0], rule[domainIndex]) {
+			matched, _ = rm.HasLink(user, rule[0], domain[0])
 			if matched {
-				newRule := deepCopyPolicy(rule)
-				newRule[domainIndex] = d
-				permission = append(permission, newRule)
+				replaced := deepCopyPolicy(rule)
+				replaced[domainIndex] = domain[0]
+				key := util.ArrayToString(replaced)
+				matched = !seen[key]
+				seen[key] = true
 			}
 		}
+		if matched {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// copyImplicitPermissions copies the named policy rules at indices, replacing their domain with the given one.
+func (e *Enforcer) copyImplicitPermissions(ptype string, indices []int, domain ...string) [][]string {
+	permission := make([][]string, 0, len(indices))
+	domainIndex, _ := e.GetFieldIndex(ptype, constant.DomainIndex)
+	for _, i := range indices {
+		newRule := deepCopyPolicy(e.model["p"][ptype].Policy[i])
+		if len(domain) != 0 {
+			newRule[domainIndex] = domain[0]
+		}
+		permission = append(permission, newRule)
 	}
-	return permission, nil
+	return permission
 }
 
 // GetImplicitUsersForPermission gets implicit users for a permission.
@@ -358,21 +599,160 @@ func (e *Enforcer) GetImplicitUsersForPermission(permission ...string) ([]string
 	return res, nil
 }
 
-// GetDomainsForUser gets all domains
+// GetDomainsForUser gets the sorted distinct domains in which the user has a role inheritance rule,
+// across all the grouping policy types with a domain.
 func (e *Enforcer) GetDomainsForUser(user string) ([]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
 	var domains []string
-	for _, rm := range e.rmMap {
+	for ptype, rm := range e.rmMap {
+		// the role managers without domain only report the default domain
+		if assertion, ok := e.model["g"][ptype]; !ok || len(assertion.Tokens) < 3 {
+			continue
+		}
 		domain, err := rm.GetDomains(user)
 		if err != nil {
 			return nil, err
 		}
 		domains = append(domains, domain...)
 	}
+	domains = util.RemoveDuplicateElement(domains)
+	sort.Strings(domains)
 	return domains, nil
 }
 
+// GetUserRoleMatrix gets whether each user is directly assigned each role in the "g" policy,
+// the result is keyed by user and then by role, and contains false for the pairs which are not assigned.
+// When a domain is given, only the assignments in the domain are considered,
+// otherwise a user has a role if it is assigned in any domain.
+// For example:
+// g, alice, admin
+// g, bob, user
+//
+// GetUserRoleMatrix() will get: {"alice": {"admin": true, "user": false}, "bob": {"admin": false, "user": true}}.
+func (e *Enforcer) GetUserRoleMatrix(domain ...string) (map[string]map[string]bool, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	if len(domain) > 1 {
+		return nil, errors.ErrDomainParameter
+	}
+	matrix := make(map[string]map[string]bool)
+	assertion, ok := e.model["g"]["g"]
+	if !ok {
+		return matrix, nil
+	}
+	rm := e.rmMap["g"]
+
+	roles := make(map[string]struct{})
+	for _, rule := range assertion.Policy {
+		if len(rule) < 2 {
+			continue
+		}
+		if len(domain) == 1 {
+			if len(rule) < 3 || !(rule[2] == domain[0] || rm != nil && rm.Match(domain[0], rule[2])) {
+				continue
+			}
+		}
+		if _, ok := matrix[rule[0]]; !ok {
+			matrix[rule[0]] = make(map[string]bool)
+		}
+		matrix[rule[0]][rule[1]] = true
+		roles[rule[1]] = struct{}{}
+	}
+
+	for _, userRoles := range matrix {
+		for role := range roles {
+			if _, ok := userRoles[role]; !ok {
+				userRoles[role] = false
+			}
+		}
+	}
+	return matrix, nil
+}
+
+// ExportRoleGraph exports the role inheritance relations of the named grouping policy in Graphviz DOT format.
+// Roles and users are the nodes of the graph, and an edge "a" -> "b" means that "a" inherits "b".
+// If a domain is given, only the links in this domain (or in a domain pattern matching it) are exported.
+// For example:
+// g, alice, admin
+// g, admin, user
+//
+// ExportRoleGraph("g") will get:
+//
+//	digraph "g" {
+//		"admin";
+//		"alice";
+//		"user";
+//		"admin" -> "user";
+//		"alice" -> "admin";
+//	}
+func (e *Enforcer) ExportRoleGraph(ptype string, domain ...string) (string, error) {
+	if len(domain) > 1 {
+		return "", errors.ErrDomainParameter
+	}
+	assertion, ok := e.model["g"][ptype]
+	if !ok {
+		return "", fmt.Errorf("grouping policy type %s does not exist", ptype)
+	}
+	rm := e.rmMap[ptype]
+
+	nodes := make(map[string]struct{})
+	edges := make(map[[2]string]struct{})
+	for _, rule := range assertion.Policy {
+		if len(rule) < 2 {
+			continue
+		}
+		if len(domain) == 1 {
+			if len(rule) < 3 || !(rule[2] == domain[0] || rm != nil && rm.Match(domain[0], rule[2])) {
+				continue
+			}
+		}
+		nodes[rule[0]] = struct{}{}
+		nodes[rule[1]] = struct{}{}
+		edges[[2]string{rule[0], rule[1]}] = struct{}{}
+	}
+
+	sortedNodes := make([]string, 0, len(nodes))
+	for node := range nodes {
+		sortedNodes = append(sortedNodes, node)
+	}
+	sort.Strings(sortedNodes)
+
+	sortedEdges := make([][2]string, 0, len(edges))
+	for edge := range edges {
+		sortedEdges = append(sortedEdges, edge)
+	}
+	sort.Slice(sortedEdges, func(i, j int) bool {
+		if sortedEdges[i][0] != sortedEdges[j][0] {
+			return sortedEdges[i][0] < sortedEdges[j][0]
+		}
+		return sortedEdges[i][1] < sortedEdges[j][1]
+	})
+
+	var sb strings.Builder
+	sb.WriteString("digraph " + quoteDotID(ptype) + " {\n")
+	for _, node := range sortedNodes {
+		sb.WriteString("\t" + quoteDotID(node) + ";\n")
+	}
+	for _, edge := range sortedEdges {
+		sb.WriteString("\t" + quoteDotID(edge[0]) + " -> " + quoteDotID(edge[1]) + ";\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String(), nil
+}
+
+// quoteDotID quotes a string as a Graphviz DOT identifier.
+func quoteDotID(id string) string {
+	id = strings.Replace(id, `\`, `\\`, -1)
+	id = strings.Replace(id, `"`, `\"`, -1)
+	return `"` + id + `"`
+}
+
 // GetImplicitResourcesForUser returns all policies that user obtaining in domain
 func (e *Enforcer) GetImplicitResourcesForUser(user string, domain ...string) ([][]string, error) {
+	domain = e.withDefaultDomain(domain)
 	permissions, err := e.GetImplicitPermissionsForUser(user, domain...)
 	if err != nil {
 		return nil, err
@@ -410,6 +790,62 @@ func (e *Enforcer) GetImplicitResourcesForUser(user string, domain ...string) ([
 	return res, nil
 }
 
+// GetImplicitResourcesByActionForUser returns the objects the user can access for each action, through the permissions
+// of the user and of its roles, as GetImplicitPermissionsForUser with the same domain filtering. The objects are given
+// once per action, in the order of the permissions. The obj and act fields are located like SetFieldIndex sets them,
+// the second and third fields by default.
+// For example:
+// p, admin, data1, read
+// p, alice, data2, read
+// g, alice, admin
+//
+// GetImplicitResourcesByActionForUser("alice") will get: {"read": ["data2", "data1"]}.
+func (e *Enforcer) GetImplicitResourcesByActionForUser(user string, domain ...string) (map[string][]string, error) {
+	permissions, err := e.GetImplicitPermissionsForUser(user, domain...)
+	if err != nil {
+		return nil, err
+	}
+
+	objIndex, err := e.GetFieldIndex("p", constant.ObjectIndex)
+	if err != nil {
+		objIndex = 1
+	}
+	actIndex, err := e.GetFieldIndex("p", constant.ActionIndex)
+	if err != nil {
+		actIndex = 2
+	}
+
+	resources := make(map[string][]string)
+	seen := make(map[string]map[string]bool)
+	for _, permission := range permissions {
+		if objIndex >= len(permission) || actIndex >= len(permission) {
+			continue
+		}
+		obj, act := permission[objIndex], permission[actIndex]
+		if seen[act] == nil {
+			seen[act] = make(map[string]bool)
+		}
+		if seen[act][obj] {
+			continue
+		}
+		seen[act][obj] = true
+		resources[act] = append(resources[act], obj)
+	}
+	return resources, nil
+}
+
+// withDefaultDomain returns the domain set by SetDefaultDomain when domain is empty and
+// the role definition "g" has a domain, or domain itself otherwise.
+func (e *Enforcer) withDefaultDomain(domain []string) []string {
+	if len(domain) != 0 || e.defaultDomain == "" {
+		return domain
+	}
+	if ast, ok := e.model["g"]["g"]; !ok || strings.Count(ast.Value, "_") < 3 {
+		return domain
+	}
+	return []string{e.defaultDomain}
+}
+
 // deepCopyPolicy returns a deepcopy version of the policy to prevent changing policies through returned slice
 func deepCopyPolicy(src []string) []string {
 	newRule := make([]string, len(src))
@@ -453,6 +889,24 @@ func (e *Enforcer) GetAllowedObjectConditions(user string, action string, prefix
 	return objectConditions, nil
 }
 
+// GetAllowedObjectsForUser returns the objects among candidates that the user can access with the action,
+// by enforcing the request {user, object, action} for each of them with the matcher of the model,
+// so that the objects are matched against the patterns of the policy, like "/api/*" with keyMatch.
+// For example: objects, err := e.GetAllowedObjectsForUser("alice", []string{"/api/users", "/admin"}, "GET")
+func (e *Enforcer) GetAllowedObjectsForUser(user string, candidates []string, action string) ([]string, error) {
+	objects := make([]string, 0)
+	for _, object := range candidates {
+		allowed, err := e.Enforce(user, object, action)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
 // removeDuplicatePermissions Convert permissions to string as a hash to deduplicate.
 func removeDuplicatePermissions(permissions [][]string) [][]string {
 	permissionsSet := make(map[string]bool)
@@ -479,6 +933,9 @@ func removeDuplicatePermissions(permissions [][]string) [][]string {
 // GetImplicitUsersForResource("data1") will return [[alice data1 read]]
 // Note: only users will be returned, roles (2nd arg in "g") will be excluded.
 func (e *Enforcer) GetImplicitUsersForResource(resource string) ([][]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
 	permissions := make([][]string, 0)
 	subjectIndex, _ := e.GetFieldIndex("p", "sub")
 	objectIndex, _ := e.GetFieldIndex("p", "obj")
@@ -518,18 +975,24 @@ func (e *Enforcer) GetImplicitUsersForResource(resource string) ([][]string, err
 }
 
 // GetImplicitUsersForResourceByDomain return implicit user based on resource and domain.
-// Compared to GetImplicitUsersForResource, domain is supported
+// Compared to GetImplicitUsersForResource, domain is supported.
+// Domain patterns are resolved with the domain matching function of the role manager, if any.
 func (e *Enforcer) GetImplicitUsersForResourceByDomain(resource string, domain string) ([][]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
 	permissions := make([][]string, 0)
 	subjectIndex, _ := e.GetFieldIndex("p", "sub")
 	objectIndex, _ := e.GetFieldIndex("p", "obj")
 	domIndex, _ := e.GetFieldIndex("p", "dom")
 	rm := e.GetRoleManager()
 
+	// roles assigned in a domain pattern (e.g. "tenant_*") are also roles of the matching domain
 	isRole := make(map[string]bool)
-
-	for _, role := range e.GetAllRolesByDomain(domain) {
-		isRole[role] = true
+	for _, policy := range e.model["g"]["g"].Policy {
+		if rm.Match(domain, policy[len(policy)-1]) {
+			isRole[policy[len(policy)-2]] = true
+		}
 	}
 
 	for _, rule := range e.model["p"]["p"].Policy {
@@ -543,7 +1006,7 @@ func (e *Enforcer) GetImplicitUsersForResourceByDomain(resource string, domain s
 		if !isRole[sub] {
 			permissions = append(permissions, rule)
 		} else {
-			if domain != rule[domIndex] {
+			if !rm.Match(domain, rule[domIndex]) {
 				continue
 			}
 			users, err := rm.GetUsers(sub, domain)
@@ -554,6 +1017,7 @@ func (e *Enforcer) GetImplicitUsersForResourceByDomain(resource string, domain s
 			for _, user := range users {
 				implicitUserRule := deepCopyPolicy(rule)
 				implicitUserRule[subjectIndex] = user
+				implicitUserRule[domIndex] = domain
 				permissions = append(permissions, implicitUserRule)
 			}
 		}
@@ -158,6 +158,21 @@ func (e *SyncedEnforcer) GetImplicitRolesForUser(name string, domain ...string)
 	return e.Enforcer.GetImplicitRolesForUser(name, domain...)
 }
 
+// GetImplicitRolesForUserWithDepth gets implicit roles that a user has, inherited through at most depth levels.
+func (e *SyncedEnforcer) GetImplicitRolesForUserWithDepth(name string, depth int, domain ...string) ([]string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetImplicitRolesForUserWithDepth(name, depth, domain...)
+}
+
+// GetImplicitRolesForUserOrdered gets implicit roles that a user has,
+// ordered by their minimum inheritance distance from the user, and then by name.
+func (e *SyncedEnforcer) GetImplicitRolesForUserOrdered(name string, domain ...string) ([]RoleWithDistance, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetImplicitRolesForUserOrdered(name, domain...)
+}
+
 // GetImplicitPermissionsForUser gets implicit permissions for a user or role.
 // Compared to GetPermissionsForUser(), this function retrieves permissions for inherited roles.
 // For example:
@@ -173,6 +188,14 @@ func (e *SyncedEnforcer) GetImplicitPermissionsForUser(user string, domain ...st
 	return e.Enforcer.GetImplicitPermissionsForUser(user, domain...)
 }
 
+// GetImplicitPermissionsForUserPage gets a page of the implicit permissions for a user or role,
+// together with the total number of the implicit permissions.
+func (e *SyncedEnforcer) GetImplicitPermissionsForUserPage(user string, offset, limit int, domain ...string) ([][]string, int, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetImplicitPermissionsForUserPage(user, offset, limit, domain...)
+}
+
 // GetNamedImplicitPermissionsForUser gets implicit permissions for a user or role by named policy.
 // Compared to GetNamedPermissionsForUser(), this function retrieves permissions for inherited roles.
 // For example:
@@ -201,3 +224,19 @@ func (e *SyncedEnforcer) GetImplicitUsersForPermission(permission ...string) ([]
 	defer e.m.RUnlock()
 	return e.Enforcer.GetImplicitUsersForPermission(permission...)
 }
+
+// GetAllowedObjectsForUser returns the objects among candidates that the user can access with the action.
+// For example: objects, err := e.GetAllowedObjectsForUser("alice", []string{"/api/users", "/admin"}, "GET")
+func (e *SyncedEnforcer) GetAllowedObjectsForUser(user string, candidates []string, action string) ([]string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetAllowedObjectsForUser(user, candidates, action)
+}
+
+// GetImplicitResourcesByActionForUser returns the objects the user can access for each action, through the permissions
+// of the user and of its roles.
+func (e *SyncedEnforcer) GetImplicitResourcesByActionForUser(user string, domain ...string) (map[string][]string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetImplicitResourcesByActionForUser(user, domain...)
+}
@@ -16,11 +16,13 @@ package casbin
 
 import (
 	"log"
+	"reflect"
 	"sort"
 	"testing"
 
 	"github.com/casbin/casbin/v2/constant"
 	"github.com/casbin/casbin/v2/errors"
+	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/util"
 )
 
@@ -55,6 +57,49 @@ func testGetUsers(t *testing.T, e *Enforcer, res []string, name string, domain .
 	}
 }
 
+func TestGetUsersForRoleNotFound(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+
+	if users, err := e.GetUsersForRole("admin", "domain1"); err != nil || !util.SetEquals(users, []string{"alice"}) {
+		t.Errorf("GetUsersForRole(admin, domain1): %v, %v, supposed to be [alice]", users, err)
+	}
+	// alice is only a user, and non_exist is not in the grouping policy.
+	for _, name := range []string{"alice", "non_exist"} {
+		if users, err := e.GetUsersForRole(name, "domain1"); err != errors.ErrNameNotFound || len(users) != 0 {
+			t.Errorf("GetUsersForRole(%s, domain1): %v, %v, supposed to be %v", name, users, err, errors.ErrNameNotFound)
+		}
+	}
+
+	// admin has no users left in domain1, but still has its permissions there.
+	_, _ = e.DeleteRoleForUserInDomain("alice", "admin", "domain1")
+	if users, err := e.GetUsersForRole("admin", "domain1"); err != nil || len(users) != 0 {
+		t.Errorf("GetUsersForRole(admin, domain1): %v, %v, supposed to be []", users, err)
+	}
+	if users, err := e.GetUsersForRole("admin", "domain2"); err != nil || !util.SetEquals(users, []string{"bob"}) {
+		t.Errorf("GetUsersForRole(admin, domain2): %v, %v, supposed to be [bob]", users, err)
+	}
+
+	// admin is neither a role nor a subject of domain3.
+	if users, err := e.GetUsersForRole("admin", "domain3"); err != errors.ErrNameNotFound || len(users) != 0 {
+		t.Errorf("GetUsersForRole(admin, domain3): %v, %v, supposed to be %v", users, err, errors.ErrNameNotFound)
+	}
+	_, _ = e.RemoveFilteredPolicy(0, "admin", "domain1")
+	if users, err := e.GetUsersForRole("admin", "domain1"); err != errors.ErrNameNotFound || len(users) != 0 {
+		t.Errorf("GetUsersForRole(admin, domain1): %v, %v, supposed to be %v", users, err, errors.ErrNameNotFound)
+	}
+
+	// a role without users in a domain matched by the pattern of its domain exists.
+	e, _ = NewEnforcer("examples/rbac_with_domain_pattern_model.conf")
+	e.SetDomainMatchingFunc("g", util.KeyMatch)
+	_, _ = e.AddGroupingPolicy("admin", "super_admin", "*")
+	if users, err := e.GetUsersForRole("super_admin", "domain1"); err != nil {
+		t.Errorf("GetUsersForRole(super_admin, domain1): %v, %v, supposed to exist", users, err)
+	}
+	if users, err := e.GetUsersForRole("admin", "domain1"); err != errors.ErrNameNotFound {
+		t.Errorf("GetUsersForRole(admin, domain1): %v, %v, supposed to be %v", users, err, errors.ErrNameNotFound)
+	}
+}
+
 func testHasRole(t *testing.T, e *Enforcer, name string, role string, res bool, domain ...string) {
 	t.Helper()
 	myRes, err := e.HasRoleForUser(name, role, domain...)
@@ -127,6 +172,138 @@ func TestRoleAPI(t *testing.T) {
 	testEnforce(t, e, "bob", "data2", "write", true)
 }
 
+func TestRoleAPIWithLazyRoleLinks(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	if err := e.SetLazyRoleLinks(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.LoadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the RBAC APIs build the role links which are still dirty
+	testGetRoles(t, e, []string{"data2_admin"}, "alice")
+	testHasRole(t, e, "alice", "data2_admin", true)
+	testGetUsers(t, e, []string{"alice"}, "data2_admin")
+	testGetImplicitRoles(t, e, "alice", []string{"data2_admin"})
+	testGetImplicitUsersForRole(t, e, "data2_admin", []string{"alice"})
+	testGetImplicitPermissions(t, e, "alice", [][]string{{"alice", "data1", "read"}, {"data2_admin", "data2", "read"}, {"data2_admin", "data2", "write"}})
+
+	// and see the policy changes made since
+	_, _ = e.AddRoleForUser("bob", "data2_admin")
+	testGetUsers(t, e, []string{"alice", "bob"}, "data2_admin")
+	_, _ = e.DeleteRoleForUser("alice", "data2_admin")
+	testGetRoles(t, e, []string{}, "alice")
+	testGetImplicitUsersForRole(t, e, "data2_admin", []string{"bob"})
+	testEnforce(t, e, "alice", "data2", "read", false)
+	testEnforce(t, e, "bob", "data2", "read", true)
+}
+
+func TestDeleteSubjectCascade(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	notifications := 0
+	_ = e.SetWatcher(&SampleWatcher{})
+	_ = e.watcher.SetUpdateCallback(func(string) { notifications++ })
+	_, _ = e.AddGroupingPolicy("data2_admin", "admin")
+	_, _ = e.AddGroupingPolicy("bob", "data2_admin")
+	_, _ = e.AddPolicy("data2_admin", "data3", "read")
+	notifications = 0
+
+	// the role is removed as a user and as a role of the grouping rules, with its permissions.
+	if ok, err := e.DeleteRole("data2_admin"); !ok || err != nil {
+		t.Errorf("DeleteRole(): %t, %v", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read"}, {"bob", "data2", "write"}})
+	testGetGroupingPolicy(t, e, [][]string{})
+	if notifications != 1 {
+		t.Errorf("DeleteRole() notified the watcher %d times, supposed to be once", notifications)
+	}
+	testEnforce(t, e, "alice", "data2", "read", false)
+	if ok, err := e.DeleteRole("data2_admin"); ok || err != nil {
+		t.Errorf("DeleteRole(): %t, %v, supposed to be not affected", ok, err)
+	}
+
+	_, _ = e.AddGroupingPolicy("alice", "data1_admin")
+	notifications = 0
+	if ok, err := e.DeleteUser("alice"); !ok || err != nil {
+		t.Errorf("DeleteUser(): %t, %v", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"bob", "data2", "write"}})
+	testGetGroupingPolicy(t, e, [][]string{})
+	if notifications != 1 {
+		t.Errorf("DeleteUser() notified the watcher %d times, supposed to be once", notifications)
+	}
+
+	_, _ = e.AddPolicies([][]string{{"alice", "data2", "write"}, {"alice", "data2", "read"}})
+	notifications = 0
+	if ok, err := e.DeletePermission("data2", "write"); !ok || err != nil {
+		t.Errorf("DeletePermission(): %t, %v", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{{"alice", "data2", "read"}})
+	if notifications != 1 {
+		t.Errorf("DeletePermission() notified the watcher %d times, supposed to be once", notifications)
+	}
+	if ok, err := e.DeletePermission("", "read"); !ok || err != nil {
+		t.Errorf("DeletePermission(): %t, %v", ok, err)
+	}
+	testGetPolicy(t, e, [][]string{})
+
+	// the cascade is undone as a single operation
+	e, _ = NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e.EnableOperationJournal(true)
+	if ok, err := e.DeleteUser("alice"); !ok || err != nil {
+		t.Errorf("DeleteUser(): %t, %v", ok, err)
+	}
+	if ok, err := e.Undo(); !ok || err != nil {
+		t.Errorf("Undo(): %t, %v", ok, err)
+	}
+	testHasPolicy(t, e, []string{"alice", "data1", "read"}, true)
+	testHasGroupingPolicy(t, e, []string{"alice", "data2_admin"}, true)
+	if ok, err := e.Redo(); !ok || err != nil {
+		t.Errorf("Redo(): %t, %v", ok, err)
+	}
+	testHasPolicy(t, e, []string{"alice", "data1", "read"}, false)
+	testHasGroupingPolicy(t, e, []string{"alice", "data2_admin"}, false)
+}
+
+func TestSetGuestRole(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	_, _ = e.AddPolicy("guest", "data1", "read")
+	_, _ = e.AddGroupingPolicy("guest", "reader")
+	_, _ = e.AddPolicy("reader", "data3", "read")
+
+	// existing behavior is unchanged until the guest role is set.
+	testEnforce(t, e, "anonymous", "data1", "read", false)
+
+	e.SetGuestRole("g", "guest")
+	testEnforce(t, e, "anonymous", "data1", "read", true)
+	testEnforce(t, e, "anonymous", "data3", "read", true)
+	testEnforce(t, e, "anonymous", "data2", "read", false)
+	// bob has no role either, alice has one so she is not a guest.
+	testEnforce(t, e, "bob", "data1", "read", true)
+	testEnforce(t, e, "alice", "data3", "read", false)
+	testEnforce(t, e, "alice", "data2", "read", true)
+	testGetRoles(t, e, []string{}, "anonymous")
+
+	_, _ = e.AddRoleForUser("anonymous", "data2_admin")
+	testEnforce(t, e, "anonymous", "data1", "read", false)
+	testEnforce(t, e, "anonymous", "data2", "read", true)
+
+	c, _ := e.Clone()
+	testEnforce(t, c, "carol", "data1", "read", true)
+
+	e.SetGuestRole("g", "")
+	testEnforce(t, e, "carol", "data1", "read", false)
+
+	// with domains, the users without any role in the domain of the request are guests of that domain.
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	e.SetGuestRole("g", "admin")
+	testDomainEnforce(t, e, "carol", "domain1", "data1", "read", true)
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "read", true)
+	testDomainEnforce(t, e, "alice", "domain2", "data2", "read", true)
+	testDomainEnforce(t, e, "alice", "domain1", "data2", "read", false)
+}
+
 func TestRoleAPI_Domains(t *testing.T) {
 	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
 
@@ -313,6 +490,94 @@ func TestImplicitRoleAPI(t *testing.T) {
 	//testGetImplicitRoles(t, e, "cathy", []string{"/book/1/2/3/4/5", "pen_admin", "/book/*", "book_group"})
 	testGetImplicitRoles(t, e, "cathy", []string{"/book/1/2/3/4/5", "pen_admin"})
 	testGetRoles(t, e, []string{"/book/1/2/3/4/5", "pen_admin"}, "cathy")
+
+	// cycles are not followed, and a role inherited through several role managers is only returned once
+	e, _ = NewEnforcer("examples/rbac_model.conf", "examples/rbac_with_hierarchy_policy.csv")
+	_, _ = e.AddGroupingPolicy("data1_admin", "alice")
+	_, _ = e.AddGroupingPolicy("data2_admin", "admin")
+	testGetImplicitRoles(t, e, "alice", []string{"admin", "data1_admin", "data2_admin"})
+
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+g2 = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = (g(r.sub, p.sub) || g2(r.sub, p.sub)) && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewEnforcer(m)
+	_, _ = e.AddGroupingPolicy("alice", "admin")
+	_, _ = e.AddNamedGroupingPolicy("g2", "alice", "admin")
+	_, _ = e.AddNamedGroupingPolicy("g2", "admin", "auditor")
+	myRes, _ := e.GetImplicitRolesForUser("alice")
+	if !util.SetEquals([]string{"admin", "auditor"}, myRes) || len(myRes) != 2 {
+		t.Error("Implicit roles for alice: ", myRes, ", supposed to be [admin auditor]")
+	}
+}
+
+func TestImplicitRoleAPIWithDepth(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf")
+	_, _ = e.AddGroupingPolicies([][]string{
+		{"alice", "role:1"},
+		{"role:1", "role:2"},
+		{"role:2", "role:3"},
+		{"role:3", "role:1"},
+	})
+
+	testGetImplicitRolesWithDepth := func(depth int, res []string, domain ...string) {
+		t.Helper()
+		myRes, err := e.GetImplicitRolesForUserWithDepth("alice", depth, domain...)
+		if err != nil || !util.SetEquals(res, myRes) {
+			t.Errorf("Implicit roles for alice with depth %d: %v, %v, supposed to be %v", depth, myRes, err, res)
+		}
+	}
+
+	testGetImplicitRolesWithDepth(1, []string{"role:1"})
+	testGetImplicitRolesWithDepth(2, []string{"role:1", "role:2"})
+	// the cycle back to role:1 is not traversed again
+	testGetImplicitRolesWithDepth(100, []string{"role:1", "role:2", "role:3"})
+	if _, err := e.GetImplicitRolesForUserWithDepth("alice", 0); err == nil {
+		t.Error("GetImplicitRolesForUserWithDepth() with a depth of 0 should fail")
+	}
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_hierarchy_with_domains_policy.csv")
+	testGetImplicitRolesWithDepth(1, []string{"role:global_admin"}, "domain1")
+	testGetImplicitRolesWithDepth(2, []string{"role:global_admin", "role:reader", "role:writer"}, "domain1")
+	testGetImplicitRolesWithDepth(2, []string{}, "domain2")
+}
+
+func testGetImplicitRolesOrdered(t *testing.T, e *Enforcer, name string, res []RoleWithDistance) {
+	t.Helper()
+	myRes, err := e.GetImplicitRolesForUserOrdered(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("Ordered implicit roles for ", name, ": ", myRes)
+
+	if !reflect.DeepEqual(res, myRes) {
+		t.Error("Ordered implicit roles for ", name, ": ", myRes, ", supposed to be ", res)
+	}
+}
+
+func TestImplicitRoleAPIOrdered(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_with_hierarchy_policy.csv")
+
+	testGetImplicitRolesOrdered(t, e, "alice", []RoleWithDistance{{"admin", 1}, {"data1_admin", 2}, {"data2_admin", 2}})
+	testGetImplicitRolesOrdered(t, e, "bob", []RoleWithDistance{})
+
+	// the shortest distance is kept, and cycles do not break the traversal
+	_, _ = e.AddGroupingPolicy("alice", "data2_admin")
+	_, _ = e.AddGroupingPolicy("data1_admin", "alice")
+	testGetImplicitRolesOrdered(t, e, "alice", []RoleWithDistance{{"admin", 1}, {"data2_admin", 1}, {"data1_admin", 2}})
 }
 
 func testGetImplicitPermissions(t *testing.T, e *Enforcer, name string, res [][]string, domain ...string) {
@@ -368,6 +633,15 @@ func TestImplicitPermissionAPI(t *testing.T) {
 		[][]string{{"admin", "domain2", "data2", "read"}, {"admin", "domain2", "data2", "write"}, {"admin", "domain2", "data3", "read"}},
 		"domain2")
 
+	// a permission granted both in the domain and in a matching domain pattern is only returned once
+	_, _ = e.AddPolicy("admin", "domain2", "data3", "read")
+	testGetImplicitPermissions(t, e, "alice",
+		[][]string{{"admin", "domain2", "data2", "read"}, {"admin", "domain2", "data2", "write"}, {"admin", "domain2", "data3", "read"}},
+		"domain2")
+	if myRes, _ := e.GetImplicitPermissionsForUser("alice", "domain2"); len(myRes) != 3 {
+		t.Error("Implicit permissions for alice under domain2: ", myRes, ", supposed to have no duplicates")
+	}
+
 	e, _ = NewEnforcer("examples/rbac_with_multiple_policy_model.conf", "examples/rbac_with_multiple_policy_policy.csv")
 
 	testGetNamedImplicitPermissions(t, e, "p", "alice", [][]string{{"user", "/data", "GET"}, {"admin", "/data", "POST"}})
@@ -380,6 +654,36 @@ func TestImplicitPermissionAPIWithDomain(t *testing.T) {
 	testGetImplicitPermissionsWithDomain(t, e, "alice", "domain1", [][]string{{"alice", "domain1", "data2", "read"}, {"role:reader", "domain1", "data1", "read"}, {"role:writer", "domain1", "data1", "write"}})
 }
 
+func testGetImplicitPermissionsPage(t *testing.T, e *Enforcer, name string, offset, limit int, res [][]string, total int, domain ...string) {
+	t.Helper()
+	myRes, myTotal, err := e.GetImplicitPermissionsForUserPage(name, offset, limit, domain...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("Implicit permissions page for ", name, ": ", myRes, ", total ", myTotal)
+
+	if !util.Array2DEquals(res, myRes) || total != myTotal {
+		t.Error("Implicit permissions page for ", name, ": ", myRes, ", total ", myTotal, ", supposed to be ", res, ", total ", total)
+	}
+}
+
+func TestImplicitPermissionAPIPage(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_with_hierarchy_policy.csv")
+
+	testGetImplicitPermissionsPage(t, e, "alice", 0, 2, [][]string{{"alice", "data1", "read"}, {"data1_admin", "data1", "read"}}, 5)
+	testGetImplicitPermissionsPage(t, e, "alice", 2, 2, [][]string{{"data1_admin", "data1", "write"}, {"data2_admin", "data2", "read"}}, 5)
+	testGetImplicitPermissionsPage(t, e, "alice", 4, 2, [][]string{{"data2_admin", "data2", "write"}}, 5)
+	testGetImplicitPermissionsPage(t, e, "alice", 6, 2, [][]string{}, 5)
+	testGetImplicitPermissionsPage(t, e, "bob", 0, 10, [][]string{{"bob", "data2", "write"}}, 1)
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_hierarchy_with_domains_policy.csv")
+	testGetImplicitPermissionsPage(t, e, "alice", 1, 1, [][]string{{"role:writer", "domain1", "data1", "write"}}, 3, "domain1")
+
+	if _, _, err := e.GetImplicitPermissionsForUserPage("alice", -1, 1); err == nil {
+		t.Error("GetImplicitPermissionsForUserPage should not support a negative offset")
+	}
+}
+
 func testGetImplicitUsers(t *testing.T, e *Enforcer, res []string, permission ...string) {
 	t.Helper()
 	myRes, _ := e.GetImplicitUsersForPermission(permission...)
@@ -456,6 +760,35 @@ func TestGetImplicitResourcesForUser(t *testing.T) {
 	}, "cathy")
 }
 
+func TestGetImplicitResourcesByActionForUser(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	_, _ = e.AddPolicy("alice", "data2", "read")
+
+	resources, err := e.GetImplicitResourcesByActionForUser("alice")
+	if err != nil {
+		t.Fatalf("GetImplicitResourcesByActionForUser: %v", err)
+	}
+	expected := map[string][]string{"read": {"data1", "data2"}, "write": {"data2"}}
+	if !reflect.DeepEqual(resources, expected) {
+		t.Errorf("alice resources: %v, supposed to be %v", resources, expected)
+	}
+	resources, _ = e.GetImplicitResourcesByActionForUser("cathy")
+	if len(resources) != 0 {
+		t.Errorf("cathy resources: %v, supposed to be empty", resources)
+	}
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	resources, _ = e.GetImplicitResourcesByActionForUser("alice", "domain1")
+	expected = map[string][]string{"read": {"data1"}, "write": {"data1"}}
+	if !reflect.DeepEqual(resources, expected) {
+		t.Errorf("alice resources in domain1: %v, supposed to be %v", resources, expected)
+	}
+	resources, _ = e.GetImplicitResourcesByActionForUser("alice", "domain2")
+	if len(resources) != 0 {
+		t.Errorf("alice resources in domain2: %v, supposed to be empty", resources)
+	}
+}
+
 func TestImplicitUsersForRole(t *testing.T) {
 	e, _ := NewEnforcer("examples/rbac_with_pattern_model.conf", "examples/rbac_with_pattern_policy.csv")
 
@@ -544,6 +877,49 @@ func TestCustomizedFieldIndex(t *testing.T) {
 	testEnforce(t, e, "bob", "data2", "write", false)
 }
 
+func TestPermissionsWithCustomizedFieldIndex(t *testing.T) {
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = dom, subject, obj, act
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.subject, r.dom) && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`)
+	e, _ := NewEnforcer(m)
+	e.SetFieldIndex("p", constant.SubjectIndex, 1)
+
+	_, _ = e.AddPermissionForUser("alice", "domain1", "data1", "read")
+	_, _ = e.AddPermissionsForUser("bob", []string{"domain1", "data1", "write"}, []string{"domain2", "data2", "read"})
+	testGetPolicy(t, e, [][]string{
+		{"domain1", "alice", "data1", "read"},
+		{"domain1", "bob", "data1", "write"},
+		{"domain2", "bob", "data2", "read"},
+	})
+	testDomainEnforce(t, e, "alice", "domain1", "data1", "read", true)
+	testDomainEnforce(t, e, "alice", "domain2", "data1", "read", false)
+	if !e.HasPermissionForUser("bob", "domain2", "data2", "read") {
+		t.Error("HasPermissionForUser() should find the permission with the customized subject index")
+	}
+
+	_, _ = e.DeletePermissionForUser("bob", "domain1", "data1", "write")
+	testGetPolicy(t, e, [][]string{
+		{"domain1", "alice", "data1", "read"},
+		{"domain2", "bob", "data2", "read"},
+	})
+
+	_, _ = e.AddPermissionForUserInDomain("cathy", "domain3", "data3", "write")
+	testHasPolicy(t, e, []string{"domain3", "cathy", "data3", "write"}, true)
+}
+
 func testGetAllowedObjectConditions(t *testing.T, e *Enforcer, user string, act string, prefix string, res []string, expectedErr error) {
 	myRes, actualErr := e.GetAllowedObjectConditions(user, act, prefix)
 
@@ -593,6 +969,34 @@ func TestGetAllowedObjectConditions(t *testing.T) {
 	}
 }
 
+func TestGetAllowedObjectsForUser(t *testing.T) {
+	e, _ := NewEnforcer("examples/keymatch_model.conf", "examples/keymatch_policy.csv")
+	candidates := []string{"/alice_data/resource1", "/alice_data/resource2", "/bob_data/resource1", "/cathy_data"}
+
+	objects, err := e.GetAllowedObjectsForUser("alice", candidates, "GET")
+	if err != nil {
+		t.Fatalf("GetAllowedObjectsForUser: %v", err)
+	}
+	if !util.ArrayEquals([]string{"/alice_data/resource1", "/alice_data/resource2"}, objects) {
+		t.Errorf("alice GET objects: %v", objects)
+	}
+
+	objects, _ = e.GetAllowedObjectsForUser("bob", candidates, "POST")
+	if !util.ArrayEquals([]string{"/bob_data/resource1"}, objects) {
+		t.Errorf("bob POST objects: %v", objects)
+	}
+
+	objects, _ = e.GetAllowedObjectsForUser("cathy", candidates, "DELETE")
+	if objects == nil || len(objects) != 0 {
+		t.Errorf("cathy DELETE objects: %v, supposed to be empty", objects)
+	}
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	if _, err = e.GetAllowedObjectsForUser("alice", candidates, "read"); err == nil {
+		t.Error("GetAllowedObjectsForUser() should fail when the request does not fit the model")
+	}
+}
+
 func testGetImplicitUsersForResource(t *testing.T, e *Enforcer, res [][]string, resource string, domain ...string) {
 	t.Helper()
 	myRes, err := e.GetImplicitUsersForResource(resource)
@@ -646,3 +1050,103 @@ func TestGetImplicitUsersForResourceByDomain(t *testing.T) {
 	testGetImplicitUsersForResourceByDomain(t, e, [][]string{{"bob", "domain2", "data2", "read"},
 		{"bob", "domain2", "data2", "write"}}, "data2", "domain2")
 }
+
+func TestImplicitUsersForRoleWithDomainMatchingFunc(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domain_pattern_model.conf", "examples/rbac_with_domain_pattern_policy.csv")
+	e.AddNamedDomainMatchingFunc("g", "KeyMatch", util.KeyMatch)
+
+	testGetImplicitUsersForRoleInDomain(t, e, "admin", "domain1", []string{"alice"})
+	testGetImplicitUsersForRoleInDomain(t, e, "admin", "domain2", []string{"alice", "bob"})
+	testGetImplicitUsersForRoleInDomain(t, e, "admin", "domain3", []string{"alice"})
+
+	testGetImplicitUsersForResourceByDomain(t, e, [][]string{{"alice", "domain1", "data1", "read"},
+		{"alice", "domain1", "data1", "write"}}, "data1", "domain1")
+	testGetImplicitUsersForResourceByDomain(t, e, [][]string{{"alice", "domain2", "data3", "read"},
+		{"bob", "domain2", "data3", "read"}}, "data3", "domain2")
+}
+
+func testGetImplicitUsersForRoleInDomain(t *testing.T, e *Enforcer, name string, domain string, res []string) {
+	t.Helper()
+	myRes, _ := e.GetImplicitUsersForRole(name, domain)
+	t.Log("Implicit users for ", name, " in domain ", domain, ": ", myRes)
+
+	if !util.SetEquals(res, myRes) {
+		t.Error("Implicit users for ", name, " in domain ", domain, ": ", myRes, ", supposed to be ", res)
+	}
+}
+
+func TestExportRoleGraph(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	_, _ = e.AddGroupingPolicy("data2_admin", "admin")
+	// cycles must not break the export
+	_, _ = e.AddGroupingPolicy("admin", "alice")
+
+	graph, err := e.ExportRoleGraph("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "digraph \"g\" {\n" +
+		"\t\"admin\";\n" +
+		"\t\"alice\";\n" +
+		"\t\"data2_admin\";\n" +
+		"\t\"admin\" -> \"alice\";\n" +
+		"\t\"alice\" -> \"data2_admin\";\n" +
+		"\t\"data2_admin\" -> \"admin\";\n" +
+		"}\n"
+	if graph != expected {
+		t.Errorf("Role graph: %s, supposed to be %s", graph, expected)
+	}
+
+	if _, err = e.ExportRoleGraph("g2"); err == nil {
+		t.Error("ExportRoleGraph should return an error for an unknown grouping policy type")
+	}
+
+	e, _ = NewEnforcer("examples/rbac_with_domain_pattern_model.conf", "examples/rbac_with_domain_pattern_policy.csv")
+	e.AddNamedDomainMatchingFunc("g", "KeyMatch", util.KeyMatch)
+
+	graph, _ = e.ExportRoleGraph("g", "domain1")
+	expected = "digraph \"g\" {\n" +
+		"\t\"admin\";\n" +
+		"\t\"alice\";\n" +
+		"\t\"alice\" -> \"admin\";\n" +
+		"}\n"
+	if graph != expected {
+		t.Errorf("Role graph in domain1: %s, supposed to be %s", graph, expected)
+	}
+}
+
+func testGetUserRoleMatrix(t *testing.T, e *Enforcer, res map[string]map[string]bool, domain ...string) {
+	t.Helper()
+	myRes, err := e.GetUserRoleMatrix(domain...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("User role matrix: ", myRes)
+
+	if !reflect.DeepEqual(res, myRes) {
+		t.Error("User role matrix: ", myRes, ", supposed to be ", res)
+	}
+}
+
+func TestGetUserRoleMatrix(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_with_hierarchy_policy.csv")
+	testGetUserRoleMatrix(t, e, map[string]map[string]bool{
+		"alice": {"admin": true, "data1_admin": false, "data2_admin": false},
+		"admin": {"admin": false, "data1_admin": true, "data2_admin": true},
+	})
+
+	e, _ = NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	_, _ = e.AddRoleForUserInDomain("alice", "user", "domain2")
+	testGetUserRoleMatrix(t, e, map[string]map[string]bool{
+		"alice": {"admin": true, "user": true},
+		"bob":   {"admin": true, "user": false},
+	})
+	testGetUserRoleMatrix(t, e, map[string]map[string]bool{
+		"alice": {"admin": false, "user": true},
+		"bob":   {"admin": true, "user": false},
+	}, "domain2")
+
+	if _, err := e.GetUserRoleMatrix("domain1", "domain2"); err != errors.ErrDomainParameter {
+		t.Error("GetUserRoleMatrix should not support multiple domains")
+	}
+}
@@ -14,16 +14,23 @@
 
 package casbin
 
-import "github.com/casbin/casbin/v2/constant"
+import (
+	"fmt"
+
+	"github.com/casbin/casbin/v2/constant"
+	Err "github.com/casbin/casbin/v2/errors"
+)
 
 // GetUsersForRoleInDomain gets the users that has a role inside a domain. Add by Gordon
 func (e *Enforcer) GetUsersForRoleInDomain(name string, domain string) []string {
+	_ = e.buildAllDirtyRoleLinks()
 	res, _ := e.model["g"]["g"].RM.GetUsers(name, domain)
 	return res
 }
 
 // GetRolesForUserInDomain gets the roles that a user has inside a domain.
 func (e *Enforcer) GetRolesForUserInDomain(name string, domain string) []string {
+	_ = e.buildAllDirtyRoleLinks()
 	res, _ := e.model["g"]["g"].RM.GetRoles(name, domain)
 	return res
 }
@@ -49,6 +56,9 @@ func (e *Enforcer) DeleteRoleForUserInDomain(user string, role string, domain st
 // DeleteRolesForUserInDomain deletes all roles for a user inside a domain.
 // Returns false if the user does not have any roles (aka not affected).
 func (e *Enforcer) DeleteRolesForUserInDomain(user string, domain string) (bool, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return false, err
+	}
 	roles, err := e.model["g"]["g"].RM.GetRoles(user, domain)
 	if err != nil {
 		return false, err
@@ -62,6 +72,48 @@ func (e *Enforcer) DeleteRolesForUserInDomain(user string, domain string) (bool,
 	return e.RemoveGroupingPolicies(rules)
 }
 
+// AddPermissionForUserInDomain adds a permission for a user or role inside a domain. The domain is put at
+// the position of the dom token of the policy definition, like "p = sub, dom, obj, act".
+// Returns false if the user or role already has the permission (aka not affected).
+func (e *Enforcer) AddPermissionForUserInDomain(user string, domain string, permission ...string) (bool, error) {
+	rule, err := e.permissionRuleInDomain(user, domain, permission)
+	if err != nil {
+		return false, err
+	}
+	return e.AddPolicy(rule)
+}
+
+// DeletePermissionForUserInDomain deletes a permission for a user or role inside a domain.
+// Returns false if the user or role does not have the permission (aka not affected).
+func (e *Enforcer) DeletePermissionForUserInDomain(user string, domain string, permission ...string) (bool, error) {
+	rule, err := e.permissionRuleInDomain(user, domain, permission)
+	if err != nil {
+		return false, err
+	}
+	return e.RemovePolicy(rule)
+}
+
+// permissionRuleInDomain builds the policy rule of a permission of user, with domain at the position of the dom token,
+// and user at the position of the sub token.
+func (e *Enforcer) permissionRuleInDomain(user string, domain string, permission []string) ([]string, error) {
+	index, err := e.GetFieldIndex("p", constant.DomainIndex)
+	if err != nil {
+		return nil, Err.ErrNoDomainInPolicy
+	}
+	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
+	if err != nil {
+		subIndex = 0
+	}
+	if index == subIndex || index > len(permission)+1 || subIndex > len(permission)+1 {
+		return nil, fmt.Errorf("the dom index %d does not fit the permission %v", index, permission)
+	}
+	// the field with the lower index is inserted first, so that both end up at their position.
+	if index < subIndex {
+		return insertField(insertField(permission, index, domain), subIndex, user), nil
+	}
+	return insertField(insertField(permission, subIndex, user), index, domain), nil
+}
+
 // GetAllUsersByDomain would get all users associated with the domain.
 func (e *Enforcer) GetAllUsersByDomain(domain string) []string {
 	m := make(map[string]struct{})
@@ -142,19 +194,24 @@ func (e *Enforcer) DeleteDomains(domains ...string) (bool, error) {
 
 // GetAllDomains would get all domains.
 func (e *Enforcer) GetAllDomains() ([]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
 	return e.model["g"]["g"].RM.GetAllDomains()
 }
 
-// GetAllRolesByDomain would get all roles associated with the domain.
+// GetAllRolesByDomain would get all roles associated with the domain,
+// including the roles assigned in a domain pattern matching it, like "tenant_*" for "tenant_1".
 // note: Not applicable to Domains with inheritance relationship  (implicit roles)
 func (e *Enforcer) GetAllRolesByDomain(domain string) []string {
 	g := e.model["g"]["g"]
 	policies := g.Policy
 	roles := make([]string, 0)
 	existMap := make(map[string]bool) // remove duplicates
+	rm := e.GetRoleManager()
 
 	for _, policy := range policies {
-		if policy[len(policy)-1] == domain {
+		if rm.Match(domain, policy[len(policy)-1]) {
 			role := policy[len(policy)-2]
 			if _, ok := existMap[role]; !ok {
 				roles = append(roles, role)
@@ -165,3 +222,63 @@ func (e *Enforcer) GetAllRolesByDomain(domain string) []string {
 
 	return roles
 }
+
+// GetImplicitResourcesForRoleInDomain gets the obj and act of the policies that a role
+// and its inherited roles have inside a domain, the inheritance is only expanded within the domain.
+// For example:
+// p, role:reader, domain1, data1, read
+// p, role:writer, domain1, data1, write
+// g, role:admin, role:reader, domain1
+// g, role:admin, role:writer, domain1
+//
+// GetImplicitResourcesForRoleInDomain("role:admin", "domain1") will get: [["data1", "read"], ["data1", "write"]].
+func (e *Enforcer) GetImplicitResourcesForRoleInDomain(role string, domain string) ([][]string, error) {
+	if err := e.buildAllDirtyRoleLinks(); err != nil {
+		return nil, err
+	}
+	subIndex, err := e.GetFieldIndex("p", constant.SubjectIndex)
+	if err != nil {
+		return nil, err
+	}
+	domIndex, err := e.GetFieldIndex("p", constant.DomainIndex)
+	if err != nil {
+		return nil, err
+	}
+	objIndex, err := e.GetFieldIndex("p", constant.ObjectIndex)
+	if err != nil {
+		return nil, err
+	}
+	actIndex, err := e.GetFieldIndex("p", constant.ActionIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	roleSet := map[string]bool{role: true}
+	if rm := e.GetRoleManager(); rm != nil {
+		q := []string{role}
+		for len(q) > 0 {
+			name := q[0]
+			q = q[1:]
+
+			roles, err := rm.GetRoles(name, domain)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range roles {
+				if !roleSet[r] {
+					roleSet[r] = true
+					q = append(q, r)
+				}
+			}
+		}
+	}
+
+	resources := make([][]string, 0)
+	for _, rule := range e.model["p"]["p"].Policy {
+		if roleSet[rule[subIndex]] && rule[domIndex] == domain {
+			resources = append(resources, []string{rule[objIndex], rule[actIndex]})
+		}
+	}
+
+	return removeDuplicatePermissions(resources), nil
+}
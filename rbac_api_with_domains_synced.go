@@ -51,6 +51,22 @@ func (e *SyncedEnforcer) DeleteRoleForUserInDomain(user string, role string, dom
 	return e.Enforcer.DeleteRoleForUserInDomain(user, role, domain)
 }
 
+// AddPermissionForUserInDomain adds a permission for a user or role inside a domain.
+// Returns false if the user or role already has the permission (aka not affected).
+func (e *SyncedEnforcer) AddPermissionForUserInDomain(user string, domain string, permission ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.AddPermissionForUserInDomain(user, domain, permission...)
+}
+
+// DeletePermissionForUserInDomain deletes a permission for a user or role inside a domain.
+// Returns false if the user or role does not have the permission (aka not affected).
+func (e *SyncedEnforcer) DeletePermissionForUserInDomain(user string, domain string, permission ...string) (bool, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.Enforcer.DeletePermissionForUserInDomain(user, domain, permission...)
+}
+
 // DeleteRolesForUserInDomain deletes all roles for a user inside a domain.
 // Returns false if the user does not have any roles (aka not affected).
 func (e *SyncedEnforcer) DeleteRolesForUserInDomain(user string, domain string) (bool, error) {
@@ -58,3 +74,11 @@ func (e *SyncedEnforcer) DeleteRolesForUserInDomain(user string, domain string)
 	defer e.m.Unlock()
 	return e.Enforcer.DeleteRolesForUserInDomain(user, domain)
 }
+
+// GetImplicitResourcesForRoleInDomain gets the obj and act of the policies that a role
+// and its inherited roles have inside a domain.
+func (e *SyncedEnforcer) GetImplicitResourcesForRoleInDomain(role string, domain string) ([][]string, error) {
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return e.Enforcer.GetImplicitResourcesForRoleInDomain(role, domain)
+}
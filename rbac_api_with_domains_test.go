@@ -15,9 +15,13 @@
 package casbin
 
 import (
+	"context"
+	"errors"
 	"sort"
 	"testing"
 
+	Err "github.com/casbin/casbin/v2/errors"
+	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/util"
 )
 
@@ -191,6 +195,52 @@ func TestPermissionAPIInDomain(t *testing.T) {
 	testGetPermissionsInDomain(t, e, "non_exist", "domain2", [][]string{})
 }
 
+func TestAddPermissionForUserInDomain(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+
+	ok, err := e.AddPermissionForUserInDomain("bob", "domain1", "data3", "read")
+	if !ok || err != nil {
+		t.Errorf("AddPermissionForUserInDomain(): %t, %v", ok, err)
+	}
+	if ok, _ = e.AddPermissionForUserInDomain("bob", "domain1", "data3", "read"); ok {
+		t.Error("AddPermissionForUserInDomain() should not add an existing permission")
+	}
+	testHasPolicy(t, e, []string{"bob", "domain1", "data3", "read"}, true)
+	testDomainEnforce(t, e, "bob", "domain1", "data3", "read", true)
+	testDomainEnforce(t, e, "bob", "domain2", "data3", "read", false)
+
+	if ok, err = e.DeletePermissionForUserInDomain("bob", "domain1", "data3", "read"); !ok || err != nil {
+		t.Errorf("DeletePermissionForUserInDomain(): %t, %v", ok, err)
+	}
+	testHasPolicy(t, e, []string{"bob", "domain1", "data3", "read"}, false)
+
+	// the domain is put at the position of the dom token.
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act, dom
+
+[policy_definition]
+p = sub, obj, act, dom
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewEnforcer(m)
+	_, _ = e.AddPermissionForUserInDomain("alice", "domain1", "data1", "read")
+	testGetPolicy(t, e, [][]string{{"alice", "data1", "read", "domain1"}})
+
+	e, _ = NewEnforcer("examples/basic_model.conf", "examples/basic_policy.csv")
+	if _, err = e.AddPermissionForUserInDomain("alice", "domain1", "data1", "read"); !errors.Is(err, Err.ErrNoDomainInPolicy) {
+		t.Errorf("AddPermissionForUserInDomain() without dom token: %v, supposed to be %v", err, Err.ErrNoDomainInPolicy)
+	}
+	if _, err = e.DeletePermissionForUserInDomain("alice", "domain1", "data1", "read"); !errors.Is(err, Err.ErrNoDomainInPolicy) {
+		t.Errorf("DeletePermissionForUserInDomain() without dom token: %v, supposed to be %v", err, Err.ErrNoDomainInPolicy)
+	}
+}
+
 func testGetDomainsForUser(t *testing.T, e *Enforcer, res []string, user string) {
 	t.Helper()
 	myRes, _ := e.GetDomainsForUser(user)
@@ -209,6 +259,35 @@ func TestGetDomainsForUser(t *testing.T) {
 	testGetDomainsForUser(t, e, []string{"domain1", "domain2"}, "alice")
 	testGetDomainsForUser(t, e, []string{"domain2", "domain3"}, "bob")
 	testGetDomainsForUser(t, e, []string{"domain3"}, "user")
+
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _, _
+g2 = _, _, _
+g3 = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewEnforcer(m)
+	_, _ = e.AddNamedGroupingPolicy("g", "alice", "admin", "domain1")
+	_, _ = e.AddNamedGroupingPolicy("g2", "alice", "auditor", "domain1")
+	_, _ = e.AddNamedGroupingPolicy("g2", "alice", "auditor", "domain2")
+	_, _ = e.AddNamedGroupingPolicy("g3", "alice", "staff")
+
+	domains, err := e.GetDomainsForUser("alice")
+	if err != nil || !util.ArrayEquals(domains, []string{"domain1", "domain2"}) {
+		t.Errorf("domains for user alice: %v, %v, supposed to be [domain1 domain2]", domains, err)
+	}
 }
 
 func testGetAllUsersByDomain(t *testing.T, e *Enforcer, domain string, expected []string) {
@@ -287,3 +366,205 @@ func TestGetAllRolesByDomain(t *testing.T) {
 	testGetAllRolesByDomain(t, e, "domain3", []string{"user"})
 
 }
+
+func TestSetDomainMatchingFunc(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domain_pattern_model.conf", "examples/rbac_with_domain_pattern_policy.csv")
+	testDomainEnforce(t, e, "alice", "domain1", "data1", "read", false)
+
+	e.SetDomainMatchingFunc("g", util.KeyMatch)
+	testDomainEnforce(t, e, "alice", "domain1", "data1", "read", true)
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "read", false)
+
+	// the declaration is kept for the role managers of the clones.
+	c, _ := e.Clone()
+	testDomainEnforce(t, c, "alice", "domain1", "data1", "read", true)
+
+	// the keyMatch(r.dom, p.dom) detection is a fallback, a nil function matches the domains exactly.
+	m, _ := model.NewModelFromString(`
+[request_definition]
+r = sub, dom, obj, act
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && keyMatch(r.dom, p.dom) && r.obj == p.obj && r.act == p.act
+`)
+	e, _ = NewEnforcer(m)
+	_, _ = e.AddPolicy("admin", "domain1", "data1", "read")
+	_, _ = e.AddGroupingPolicy("alice", "admin", "*")
+	testDomainEnforce(t, e, "alice", "domain1", "data1", "read", true)
+	e.SetDomainMatchingFunc("g", nil)
+	testDomainEnforce(t, e, "alice", "domain1", "data1", "read", false)
+}
+
+func TestRoleAPIWithDomainPattern(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domain_pattern_model.conf")
+	e.AddNamedDomainMatchingFunc("g", "KeyMatch", util.KeyMatch)
+	_, _ = e.AddGroupingPolicy("alice", "admin", "tenant_*")
+	_, _ = e.AddGroupingPolicy("alice", "admin", "tenant_1")
+	_, _ = e.AddGroupingPolicy("alice", "viewer", "tenant_1")
+	_, _ = e.AddGroupingPolicy("bob", "admin", "tenant_1")
+
+	testGetRolesInDomain(t, e, "alice", "tenant_1", []string{"admin", "viewer"})
+	testGetRolesInDomain(t, e, "alice", "tenant_2", []string{"admin"})
+	testGetRolesInDomain(t, e, "bob", "tenant_2", []string{})
+	testGetUsersInDomain(t, e, "admin", "tenant_1", []string{"alice", "bob"})
+	testGetUsersInDomain(t, e, "admin", "tenant_2", []string{"alice"})
+	testGetAllRolesByDomain(t, e, "tenant_1", []string{"admin", "viewer"})
+	testGetAllRolesByDomain(t, e, "tenant_2", []string{"admin"})
+	testGetAllRolesByDomain(t, e, "global", []string{})
+}
+
+func TestDefaultDomain(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	e.SetDefaultDomain("domain1")
+
+	testGetRoles(t, e, []string{"admin"}, "alice")
+	testGetRoles(t, e, []string{}, "bob")
+	testGetRoles(t, e, []string{"admin"}, "bob", "domain2")
+
+	_, _ = e.AddRoleForUser("bob", "admin")
+	testGetRoles(t, e, []string{"admin"}, "bob")
+	testHasGroupingPolicy(t, e, []string{"bob", "admin", "domain1"}, true)
+
+	testDomainEnforce(t, e, "bob", "domain1", "data1", "read", true)
+	testEnforce(t, e, "bob", "data1", "read", true)
+	testEnforce(t, e, "bob", "data2", "read", false)
+
+	_, _ = e.DeleteRoleForUser("bob", "admin")
+	testHasGroupingPolicy(t, e, []string{"bob", "admin", "domain1"}, false)
+	testEnforce(t, e, "bob", "data1", "read", false)
+
+	// the default domain is ignored by models without domains
+	e, _ = NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+	e.SetDefaultDomain("domain1")
+	testGetRoles(t, e, []string{"data2_admin"}, "alice")
+	testEnforce(t, e, "alice", "data2", "read", true)
+}
+
+// tenantKey is the context key of the domain in TestDomainContextKey.
+type tenantKey struct{}
+
+func TestDomainContextKey(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_domains_policy.csv")
+	e.SetDomainContextKey(tenantKey{})
+
+	testEnforceWithContext := func(ctx context.Context, sub string, obj string, act string, res bool) {
+		t.Helper()
+		if myRes, err := e.EnforceWithContext(ctx, sub, obj, act); err != nil || myRes != res {
+			t.Errorf("%s, %s, %s: %t, %v, supposed to be %t", sub, obj, act, myRes, err, res)
+		}
+	}
+	domain1 := context.WithValue(context.Background(), tenantKey{}, "domain1")
+	domain2 := context.WithValue(context.Background(), tenantKey{}, "domain2")
+	testEnforceWithContext(domain1, "alice", "data1", "read", true)
+	testEnforceWithContext(domain1, "bob", "data2", "read", false)
+	testEnforceWithContext(domain2, "bob", "data2", "read", true)
+	testEnforceWithContext(domain2, "alice", "data1", "read", false)
+
+	// a request with its domain is kept as it is.
+	if res, err := e.EnforceWithContext(domain2, "alice", "domain1", "data1", "read"); !res || err != nil {
+		t.Errorf("alice, domain1, data1, read: %t, %v, supposed to be true", res, err)
+	}
+
+	// the domain of the context takes precedence over the default domain.
+	e.SetDefaultDomain("domain1")
+	testEnforceWithContext(domain2, "bob", "data2", "read", true)
+	testEnforceWithContext(context.Background(), "alice", "data1", "read", true)
+
+	e.SetDefaultDomain("")
+	e.SetDomainContextKey(nil)
+	if _, err := e.EnforceWithContext(domain1, "alice", "data1", "read"); err == nil {
+		t.Error("EnforceWithContext() should fail on a request without its domain")
+	}
+}
+
+func TestDefaultDomainWithOptionalRequestTokens(t *testing.T) {
+	text :=
+		`
+[request_definition]
+r = sub, dom, obj, act, note
+
+[policy_definition]
+p = sub, dom, obj, act
+
+[role_definition]
+g = _, _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && r.obj == p.obj && r.act == p.act
+`
+	m, _ := model.NewModelFromString(text)
+	e, _ := NewEnforcer(m)
+	_, _ = e.AddPolicy("admin", "domain1", "data1", "read")
+	_, _ = e.AddPolicy("admin", "domain2", "data2", "read")
+	_, _ = e.AddGroupingPolicy("alice", "admin", "domain1")
+	_, _ = e.AddGroupingPolicy("alice", "admin", "domain2")
+	if err := e.SetOptionalRequestTokens("r", []string{"note"}); err != nil {
+		t.Fatal(err)
+	}
+	e.SetDefaultDomain("domain1")
+	e.SetDomainContextKey(tenantKey{})
+	domain2 := context.WithValue(context.Background(), tenantKey{}, "domain2")
+
+	testCases := []struct {
+		ctx     context.Context
+		request []interface{}
+		res     bool
+	}{
+		// a request omitting only its optional tokens keeps its domain
+		{context.Background(), []interface{}{"alice", "domain2", "data2", "read"}, true},
+		{domain2, []interface{}{"alice", "domain1", "data1", "read"}, true},
+		{context.Background(), []interface{}{"alice", "domain1", "data1", "read", "note"}, true},
+		// a request omitting its domain and its optional tokens gets the domain injected
+		{context.Background(), []interface{}{"alice", "data1", "read"}, true},
+		{context.Background(), []interface{}{"alice", "data2", "read"}, false},
+		{domain2, []interface{}{"alice", "data2", "read"}, true},
+	}
+	for _, tc := range testCases {
+		if res, err := e.EnforceWithContext(tc.ctx, tc.request...); err != nil || res != tc.res {
+			t.Errorf("%v: %t, %v, supposed to be %t", tc.request, res, err, tc.res)
+		}
+	}
+}
+
+func TestGetImplicitResourcesForRoleInDomain(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_with_domains_model.conf", "examples/rbac_with_hierarchy_with_domains_policy.csv")
+
+	testGetImplicitResourcesForRoleInDomain := func(role string, domain string, res [][]string) {
+		t.Helper()
+		myRes, err := e.GetImplicitResourcesForRoleInDomain(role, domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log("Implicit resources for ", role, " in ", domain, ": ", myRes)
+		if !util.Set2DEquals(res, myRes) {
+			t.Error("Implicit resources for ", role, " in ", domain, ": ", myRes, ", supposed to be ", res)
+		}
+	}
+
+	testGetImplicitResourcesForRoleInDomain("role:global_admin", "domain1", [][]string{{"data1", "read"}, {"data1", "write"}})
+	testGetImplicitResourcesForRoleInDomain("role:reader", "domain1", [][]string{{"data1", "read"}})
+	testGetImplicitResourcesForRoleInDomain("role:global_admin", "domain2", [][]string{})
+
+	// inheritance from another domain is not expanded
+	_, _ = e.AddPolicy("role:auditor", "domain2", "data2", "read")
+	_, _ = e.AddGroupingPolicy("role:global_admin", "role:auditor", "domain2")
+	testGetImplicitResourcesForRoleInDomain("role:global_admin", "domain1", [][]string{{"data1", "read"}, {"data1", "write"}})
+	testGetImplicitResourcesForRoleInDomain("role:global_admin", "domain2", [][]string{{"data2", "read"}})
+
+	// duplicated permissions and cycles
+	_, _ = e.AddPolicy("role:global_admin", "domain1", "data1", "read")
+	_, _ = e.AddGroupingPolicy("role:reader", "role:global_admin", "domain1")
+	testGetImplicitResourcesForRoleInDomain("role:reader", "domain1", [][]string{{"data1", "read"}, {"data1", "write"}})
+}
@@ -22,6 +22,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Knetic/govaluate"
 	"github.com/casbin/casbin/v2/rbac"
@@ -326,18 +327,19 @@ func RegexMatchFunc(args ...interface{}) (interface{}, error) {
 }
 
 // IPMatch determines whether IP address ip1 matches the pattern of IP address ip2, ip2 can be an IP address or a CIDR pattern.
+// Both IPv4 and IPv6 are supported, and false is returned if ip1 or ip2 is malformed.
 // For example, "192.168.2.123" matches "192.168.2.0/24"
 func IPMatch(ip1 string, ip2 string) bool {
 	objIP1 := net.ParseIP(ip1)
 	if objIP1 == nil {
-		panic("invalid argument: ip1 in IPMatch() function is not an IP address.")
+		return false
 	}
 
 	_, cidr, err := net.ParseCIDR(ip2)
 	if err != nil {
 		objIP2 := net.ParseIP(ip2)
 		if objIP2 == nil {
-			panic("invalid argument: ip2 in IPMatch() function is neither an IP address nor a CIDR.")
+			return false
 		}
 
 		return objIP1.Equal(objIP2)
@@ -375,6 +377,47 @@ func GlobMatchFunc(args ...interface{}) (interface{}, error) {
 	return GlobMatch(name1, name2)
 }
 
+// TimeMatch determines whether the current time is in the window from start to end, the start is inclusive
+// and the end is exclusive. start and end can be both RFC3339 times like "2023-01-02T09:00:00Z", or both
+// times of day like "09:00", in which case a window like "22:00" to "06:00" wraps around midnight.
+// False is returned if start or end is malformed.
+func TimeMatch(start string, end string) bool {
+	return timeMatch(time.Now(), start, end)
+}
+
+func timeMatch(now time.Time, start string, end string) bool {
+	startTime, err1 := time.Parse(time.RFC3339, start)
+	endTime, err2 := time.Parse(time.RFC3339, end)
+	if err1 == nil && err2 == nil {
+		return !now.Before(startTime) && now.Before(endTime)
+	}
+
+	startClock, err1 := time.Parse("15:04", start)
+	endClock, err2 := time.Parse("15:04", end)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	startMinute := startClock.Hour()*60 + startClock.Minute()
+	endMinute := endClock.Hour()*60 + endClock.Minute()
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	return minute >= startMinute || minute < endMinute
+}
+
+// TimeMatchFunc is the wrapper for TimeMatch.
+func TimeMatchFunc(args ...interface{}) (interface{}, error) {
+	if err := validateVariadicArgs(2, args...); err != nil {
+		return false, fmt.Errorf("%s: %s", "timeMatch", err)
+	}
+
+	start := args[0].(string)
+	end := args[1].(string)
+
+	return TimeMatch(start, end), nil
+}
+
 // GenerateGFunction is the factory method of the g(_, _[, _]) function.
 func GenerateGFunction(rm rbac.RoleManager) govaluate.ExpressionFunction {
 	memorized := sync.Map{}
@@ -417,3 +460,57 @@ func GenerateGFunction(rm rbac.RoleManager) govaluate.ExpressionFunction {
 		return v, nil
 	}
 }
+
+// GenerateContextGFunction is the factory method of the g(_, _, _) function which, besides the links of the
+// domain itself, also follows the links of the domains matched by fn for the request rvals.
+func GenerateContextGFunction(rm rbac.RoleManager, fn rbac.ContextMatchingFunc, rvals []interface{}) govaluate.ExpressionFunction {
+	request := make([]string, len(rvals))
+	for i, rval := range rvals {
+		request[i] = fmt.Sprint(rval)
+	}
+
+	gFunction := GenerateGFunction(rm)
+	return func(args ...interface{}) (interface{}, error) {
+		v, err := gFunction(args...)
+		if err != nil || v.(bool) || rm == nil || len(args) != 3 {
+			return v, err
+		}
+
+		name1, name2, domain := args[0].(string), args[1].(string), args[2].(string)
+		domains, err := rm.GetAllDomains()
+		if err != nil {
+			return false, nil
+		}
+		for _, pattern := range domains {
+			if pattern == domain || !fn(append([]string{domain, pattern}, request...)...) {
+				continue
+			}
+			if ok, _ := rm.HasLink(name1, name2, pattern); ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// GenerateGuestGFunction is the factory method of the g(_, _) function which, for a user without any role,
+// follows the links of the guest role instead, as if the user were the guest. gFunction is the g(_, _) function
+// used otherwise, like the one of GenerateGFunction.
+func GenerateGuestGFunction(rm rbac.RoleManager, guest string, gFunction govaluate.ExpressionFunction) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		v, err := gFunction(args...)
+		if err != nil || v.(bool) || rm == nil || args[0].(string) == guest {
+			return v, err
+		}
+
+		domain := make([]string, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			domain = append(domain, arg.(string))
+		}
+		roles, err := rm.GetRoles(args[0].(string), domain...)
+		if err != nil || len(roles) != 0 {
+			return false, nil
+		}
+		return gFunction(append([]interface{}{guest}, args[1:]...)...)
+	}
+}
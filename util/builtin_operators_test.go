@@ -16,6 +16,7 @@ package util
 
 import (
 	"testing"
+	"time"
 )
 
 func testKeyMatch(t *testing.T, key1 string, key2 string, res bool) {
@@ -312,6 +313,58 @@ func TestIPMatch(t *testing.T) {
 	testIPMatch(t, "192.168.2.123", "192.168.2.123/32", true)
 	testIPMatch(t, "10.0.0.11", "10.0.0.0/8", true)
 	testIPMatch(t, "11.0.0.123", "10.0.0.0/8", false)
+	testIPMatch(t, "2001:db8::1", "2001:db8::/32", true)
+	testIPMatch(t, "2001:db9::1", "2001:db8::/32", false)
+	testIPMatch(t, "2001:db8::1", "2001:db8::1", true)
+	testIPMatch(t, "192.168.2.123", "2001:db8::/32", false)
+	testIPMatch(t, "not an ip", "192.168.2.0/24", false)
+	testIPMatch(t, "192.168.2.123", "192.168.2.0/33", false)
+	testIPMatch(t, "192.168.2.123", "", false)
+}
+
+func testTimeMatch(t *testing.T, now string, start string, end string, res bool) {
+	t.Helper()
+	nowTime, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	myRes := timeMatch(nowTime, start, end)
+	t.Logf("%s in [%s, %s): %t", now, start, end, myRes)
+
+	if myRes != res {
+		t.Errorf("%s in [%s, %s): %t, supposed to be %t", now, start, end, !res, res)
+	}
+}
+
+func TestTimeMatch(t *testing.T) {
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "09:00", "17:00", true)
+	testTimeMatch(t, "2023-01-02T09:00:00Z", "09:00", "17:00", true)
+	testTimeMatch(t, "2023-01-02T08:59:59Z", "09:00", "17:00", false)
+	testTimeMatch(t, "2023-01-02T17:00:00Z", "09:00", "17:00", false)
+	testTimeMatch(t, "2023-01-02T16:59:00Z", "09:00", "17:00", true)
+
+	testTimeMatch(t, "2023-01-02T23:30:00Z", "22:00", "06:00", true)
+	testTimeMatch(t, "2023-01-02T00:00:00Z", "22:00", "06:00", true)
+	testTimeMatch(t, "2023-01-02T05:59:00Z", "22:00", "06:00", true)
+	testTimeMatch(t, "2023-01-02T06:00:00Z", "22:00", "06:00", false)
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "22:00", "06:00", false)
+
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "2023-01-01T00:00:00Z", "2023-01-03T00:00:00Z", true)
+	testTimeMatch(t, "2023-01-01T00:00:00Z", "2023-01-01T00:00:00Z", "2023-01-03T00:00:00Z", true)
+	testTimeMatch(t, "2023-01-03T00:00:00Z", "2023-01-01T00:00:00Z", "2023-01-03T00:00:00Z", false)
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "2023-01-02T14:00:00+02:00", "2023-01-03T00:00:00Z", true)
+
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "9am", "17:00", false)
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "09:00", "25:00", false)
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "09:00", "2023-01-03T00:00:00Z", false)
+	testTimeMatch(t, "2023-01-02T12:00:00Z", "", "", false)
+
+	if TimeMatch("00:00", "00:00") {
+		t.Error("an empty time window should never match")
+	}
+	if !TimeMatch("2000-01-01T00:00:00Z", "2100-01-01T00:00:00Z") {
+		t.Error("the current time should match a window containing it")
+	}
 }
 
 func testRegexMatchFunc(t *testing.T, res bool, err string, args ...interface{}) {
@@ -343,16 +343,30 @@ func (cache *LRUCache) Get(key interface{}) (value interface{}, ok bool) {
 }
 
 func (cache *LRUCache) Put(key interface{}, value interface{}) {
+	cache.PutAndEvict(key, value)
+}
+
+// PutAndEvict puts the value like Put, and returns whether the least recently used value was evicted to make room for it.
+func (cache *LRUCache) PutAndEvict(key interface{}, value interface{}) bool {
+	evicted := false
 	n, ok := cache.m[key]
 	if ok {
 		cache.remove(n, false)
+		n.value = value
 	} else {
 		n = &node{key, value, nil, nil}
 		if len(cache.m) >= cache.capacity {
 			cache.remove(cache.tail.prev, false)
+			evicted = true
 		}
 	}
 	cache.add(n, false)
+	return evicted
+}
+
+// Len returns the number of values in the cache.
+func (cache *LRUCache) Len() int {
+	return len(cache.m)
 }
 
 type SyncLRUCache struct {
@@ -377,3 +391,15 @@ func (cache *SyncLRUCache) Put(key interface{}, value interface{}) {
 	defer cache.rwm.Unlock()
 	cache.LRUCache.Put(key, value)
 }
+
+func (cache *SyncLRUCache) PutAndEvict(key interface{}, value interface{}) bool {
+	cache.rwm.Lock()
+	defer cache.rwm.Unlock()
+	return cache.LRUCache.PutAndEvict(key, value)
+}
+
+func (cache *SyncLRUCache) Len() int {
+	cache.rwm.RLock()
+	defer cache.rwm.RUnlock()
+	return cache.LRUCache.Len()
+}
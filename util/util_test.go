@@ -222,4 +222,13 @@ func TestLRUCache(t *testing.T) {
 	testCachePut(t, cache, "four", 4)
 	testCacheGet(t, cache, "two", nil, false)
 	testCacheEqual(t, cache, []int{1, 3, 4})
+
+	if cache.PutAndEvict("three", 33) || cache.Len() != 3 {
+		t.Errorf("Updating a cached value should not evict")
+	}
+	testCacheGet(t, cache, "three", 33, true)
+	if !cache.PutAndEvict("five", 5) || cache.Len() != 3 {
+		t.Errorf("Putting a value in a full cache should evict")
+	}
+	testCacheGet(t, cache, "one", nil, false)
 }
@@ -67,4 +67,6 @@ func TestSetWatcherEx(t *testing.T) {
 	_, _ = e.RemoveFilteredGroupingPolicy(1, "data1")
 	_, _ = e.AddPolicies([][]string{{"admin", "data1", "read"}, {"admin", "data2", "read"}})    // calls watcherEx.UpdateForAddPolicies()
 	_, _ = e.RemovePolicies([][]string{{"admin", "data1", "read"}, {"admin", "data2", "read"}}) // calls watcherEx.UpdateForRemovePolicies()
+	_, _ = e.AddPolicies([][]string{{"admin", "data1", "read"}, {"admin", "data2", "read"}})    // calls watcherEx.UpdateForAddPolicies()
+	_, _ = e.RemoveFilteredPolicyMatch(0, func(sub string) bool { return sub == "admin" })      // calls watcherEx.UpdateForRemovePolicies()
 }
@@ -0,0 +1,69 @@
+// Copyright 2023 The casbin Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package casbin
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2/util"
+)
+
+type SampleWatcherIncremental struct {
+	SampleWatcher
+	added   [][]string
+	removed [][]string
+	updates int
+}
+
+func (w *SampleWatcherIncremental) Update() error {
+	w.updates++
+	return nil
+}
+
+func (w *SampleWatcherIncremental) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	w.added = append(w.added, append([]string{ptype}, params...))
+	return nil
+}
+
+func (w *SampleWatcherIncremental) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	w.removed = append(w.removed, append([]string{ptype}, params...))
+	return nil
+}
+
+func TestSetWatcherIncremental(t *testing.T) {
+	e, _ := NewEnforcer("examples/rbac_model.conf", "examples/rbac_policy.csv")
+
+	sampleWatcherIncremental := &SampleWatcherIncremental{}
+	err := e.SetWatcher(sampleWatcherIncremental)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = e.AddPolicy("admin", "data1", "read")               // calls watcher.UpdateForAddPolicy()
+	_, _ = e.AddGroupingPolicy("alice", "admin")               // calls watcher.UpdateForAddPolicy()
+	_, _ = e.RemovePolicy("admin", "data1", "read")            // calls watcher.UpdateForRemovePolicy()
+	_, _ = e.RemoveFilteredPolicy(0, "data2_admin")            // calls watcher.Update()
+	_, _ = e.AddPolicies([][]string{{"bob", "data1", "read"}}) // calls watcher.Update()
+
+	if !util.Array2DEquals([][]string{{"p", "admin", "data1", "read"}, {"g", "alice", "admin"}}, sampleWatcherIncremental.added) {
+		t.Errorf("Added rules: %v", sampleWatcherIncremental.added)
+	}
+	if !util.Array2DEquals([][]string{{"p", "admin", "data1", "read"}}, sampleWatcherIncremental.removed) {
+		t.Errorf("Removed rules: %v", sampleWatcherIncremental.removed)
+	}
+	if sampleWatcherIncremental.updates != 2 {
+		t.Errorf("Updates: %d, supposed to be 2", sampleWatcherIncremental.updates)
+	}
+}